@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -12,10 +13,13 @@ import (
 
 	"github.com/ddvk/rmfakecloud/internal/app/hub"
 	"github.com/ddvk/rmfakecloud/internal/app/passcodestore"
+	"github.com/ddvk/rmfakecloud/internal/audit"
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/hwr"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
 	"github.com/ddvk/rmfakecloud/internal/mqtt"
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 
 	"github.com/ddvk/rmfakecloud/internal/storage/fs"
@@ -30,6 +34,21 @@ const (
 	syncVersionKey = "SyncVersion"
 )
 
+// quotaChecker limits the uploads to the user's quota
+type quotaChecker interface {
+	QuotaReader(uid string, r io.Reader, size int64) (io.Reader, error)
+}
+
+// searchIndexer keeps the search index up to date
+type searchIndexer interface {
+	UpdateSearchIndex(uid string) error
+}
+
+// rootAuditor tells which documents a root update changed
+type rootAuditor interface {
+	RootChanges(uid, oldHash, newHash string) ([]audit.Entry, error)
+}
+
 // App web app
 type App struct {
 	router        *gin.Engine
@@ -42,8 +61,16 @@ type App struct {
 	hub           *hub.Hub
 	passcodeStore passcodestore.Store
 	codeConnector CodeConnector
+	limiter       *ratelimit.Limiter
+	devices       storage.DeviceStorer
 	hwrClient     *hwr.HWRClient
 	mqttBroker    *mqtt.Broker
+	gc            garbageCollector
+	quota         quotaChecker
+	search        searchIndexer
+	rootChanges   rootAuditor
+	trash         trashPurger
+	stop          chan struct{}
 }
 
 // Start starts the app
@@ -56,10 +83,6 @@ func (app *App) Start() {
 	log.Info("Data: ", app.cfg.DataDir)
 	log.Info("HTTP listening on port: ", app.cfg.Port)
 
-	if !app.cfg.TrustProxy {
-		app.router.SetTrustedProxies(nil)
-	}
-
 	var tlsConfig *tls.Config
 	if app.cfg.Certificate.Certificate != nil {
 		tlsConfig = &tls.Config{
@@ -76,6 +99,16 @@ func (app *App) Start() {
 		if err := app.mqttBroker.Start(); err != nil {
 			log.Errorf("Failed to start MQTT broker: %v", err)
 		}
+		metrics.Gauge("mqtt_sessions", "Connected MQTT clients.", app.mqttBroker.Sessions)
+		metrics.Gauge("screenshare_rooms", "Open screenshare rooms.", app.mqttBroker.Rooms)
+	}
+
+	app.stop = make(chan struct{})
+	if app.cfg.GCInterval > 0 {
+		go runGC(app.gc, app.cfg.GCInterval, app.cfg.GCKeepHistory, app.stop)
+	}
+	if app.cfg.TrashRetention > 0 {
+		go runTrashPurge(app.trash, app.cfg.TrashRetention, app.stop)
 	}
 
 	app.srv = &http.Server{
@@ -102,6 +135,9 @@ func (app *App) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	// app.hub.Stop()
+	if app.stop != nil {
+		close(app.stop)
+	}
 	if app.mqttBroker != nil {
 		if err := app.mqttBroker.Stop(); err != nil {
 			log.Errorf("Error stopping MQTT broker: %v", err)
@@ -110,6 +146,7 @@ func (app *App) Stop() {
 	if err := app.srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server Shutdown:", err)
 	}
+	audit.Close()
 }
 
 
@@ -135,7 +172,32 @@ func NewApp(cfg *config.Config) App {
 	ntfHub := hub.NewHub()
 	pcStore := passcodestore.NewInMemory()
 	codeConnector := NewCodeConnector()
+	if cfg.CodeDBConfig != nil {
+		log.Info("Using ", cfg.CodeDBConfig.Driver, " pairing code storage")
+		codeConnector, err = NewDBCodeConnector(cfg.CodeDBConfig)
+		if err != nil {
+			log.Fatal("cannot open the pairing code database ", err)
+		}
+	}
 	router := gin.Default()
+	// only the proxy may set the client ip, the rate limits depend on it
+	if !cfg.TrustProxy {
+		router.SetTrustedProxies(nil)
+	} else if len(cfg.TrustedProxies) > 0 {
+		if err = router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+			log.Fatal("trusted proxies ", err)
+		}
+	}
+	limiter := ratelimit.New(ratelimit.Config{
+		LockoutAfter:        cfg.LockoutAfter,
+		AccountLockoutAfter: cfg.AccountLockoutAfter,
+		LockoutDuration:     cfg.LockoutDuration,
+	})
+	if cfg.AuditLog != "" {
+		if err = audit.Open(cfg.AuditLog); err != nil {
+			log.Fatal("cannot open the audit log ", err)
+		}
+	}
 
 	// corsConfig := cors.DefaultConfig()
 
@@ -151,6 +213,9 @@ func NewApp(cfg *config.Config) App {
 	// Register the middleware
 	// router.Use(cors.New(corsConfig))
 
+	router.Use(metrics.Middleware())
+	metrics.Gauge("websocket_clients", "Connected websocket (notification) clients.", ntfHub.ClientCount)
+
 	if debugMode {
 		router.Use(requestLoggerMiddleware())
 	}
@@ -165,6 +230,13 @@ func NewApp(cfg *config.Config) App {
 		hub:           ntfHub,
 		passcodeStore: pcStore,
 		codeConnector: codeConnector,
+		limiter:       limiter,
+		devices:       fsStorage,
+		gc:            fsStorage,
+		trash:         fsStorage,
+		quota:         fsStorage,
+		search:        fsStorage,
+		rootChanges:   fsStorage,
 		hwrClient: &hwr.HWRClient{
 			Cfg: cfg,
 		},
@@ -174,7 +246,7 @@ func NewApp(cfg *config.Config) App {
 
 	app.registerRoutes(router)
 
-	uiApp := ui.New(cfg, fsStorage, codeConnector, ntfHub, pcStore, fsStorage, fsStorage)
+	uiApp := ui.New(cfg, fsStorage, codeConnector, ntfHub, pcStore, fsStorage, fsStorage, fsStorage, fsStorage, limiter)
 	uiApp.RegisterRoutes(router)
 
 	storageapp := fs.NewApp(cfg, fsStorage)
@@ -187,6 +259,11 @@ func badReq(c *gin.Context, message string) {
 	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": message})
 }
 
+// quotaExceeded the upload does not fit in the user's quota
+func quotaExceeded(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": storage.ErrorQuotaExceeded.Error()})
+}
+
 func internalError(c *gin.Context, message string) {
 	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": message})
 }
@@ -211,5 +288,8 @@ func (app *App) validateMQTTToken(token string) (string, error) {
 	}
 
 	userID := common.SanitizeUid(strings.TrimPrefix(claims.Profile.UserID, "auth0|"))
+	if err = app.checkDevice(userID, claims.DeviceID); err != nil {
+		return "", err
+	}
 	return userID, nil
 }
@@ -2,19 +2,32 @@ package app
 
 import (
 	"crypto/rand"
-	"errors"
 	"math/big"
 	"sync"
 	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/db"
 )
 
+const (
+	codeValidity = time.Minute * 5
+	// maxCodeAttempts wrong codes a client can try in the codeAttemptWindow
+	maxCodeAttempts   = 10
+	codeAttemptWindow = time.Minute * 15
+)
+
+type pendingCode struct {
+	uid     string
+	expires time.Time
+}
+
 type inMemoryCodeConnector struct {
-	dict         map[string]string
-	uids         map[string]string
-	lock         sync.Mutex
-	codeValidity time.Duration
+	dict     map[string]pendingCode
+	uids     map[string]string
+	attempts map[string][]time.Time
+	lock     sync.Mutex
+	now      func() time.Time
 }
 
 // CodeConnector matches a code to users
@@ -22,39 +35,70 @@ type CodeConnector interface {
 	//NewCode generates one time code for a user
 	NewCode(uid string) (code string, err error)
 
-	//ConsumeCode a code and returns the uid if ofound
-	ConsumeCode(code string) (uid string, err error)
+	//ConsumeCode a code and returns the uid if found, source is the client address
+	ConsumeCode(code, source string) (uid string, err error)
 }
 
 // NewCodeConnector constructor
 func NewCodeConnector() CodeConnector {
 	return &inMemoryCodeConnector{
-		dict:         make(map[string]string),
-		uids:         make(map[string]string),
-		codeValidity: time.Minute * 5,
+		dict:     make(map[string]pendingCode),
+		uids:     make(map[string]string),
+		attempts: make(map[string][]time.Time),
+		now:      time.Now,
 	}
 
 }
 
+// NewDBCodeConnector the codes are kept in a database, shared by all instances
+func NewDBCodeConnector(cfg *db.Config) (CodeConnector, error) {
+	store, err := db.NewCodeStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store.Validity = codeValidity
+	store.MaxAttempts = maxCodeAttempts
+	store.AttemptWindow = codeAttemptWindow
+	return &dbCodeConnector{store: store}, nil
+}
+
+// expire drops the expired codes and old attempts
+func (conn *inMemoryCodeConnector) expire(now time.Time) {
+	for code, p := range conn.dict {
+		if !now.Before(p.expires) {
+			delete(conn.dict, code)
+			delete(conn.uids, p.uid)
+		}
+	}
+	for source, attempts := range conn.attempts {
+		recent := attempts[:0]
+		for _, t := range attempts {
+			if now.Sub(t) < codeAttemptWindow {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(conn.attempts, source)
+		} else {
+			conn.attempts[source] = recent
+		}
+	}
+}
+
 func (conn *inMemoryCodeConnector) NewCode(uid string) (string, error) {
 	code, err := newUserCode()
 	if err != nil {
 		return "", err
 	}
 	conn.lock.Lock()
-	conn.dict[code] = uid
+	defer conn.lock.Unlock()
+	now := conn.now()
+	conn.expire(now)
 	if oldcode, ok := conn.uids[uid]; ok {
 		delete(conn.dict, oldcode)
 	}
+	conn.dict[code] = pendingCode{uid: uid, expires: now.Add(codeValidity)}
 	conn.uids[uid] = code
-	conn.lock.Unlock()
-	go func() {
-		<-time.After(conn.codeValidity)
-		if _, err := conn.ConsumeCode(code); err == nil {
-			log.Infof("removed unused code: %s for uid: %s ", code, uid)
-		}
-
-	}()
 	return code, nil
 }
 
@@ -85,13 +129,35 @@ func newUserCode() (code string, err error) {
 }
 
 // ConsumeCode return the userId matching the
-func (conn *inMemoryCodeConnector) ConsumeCode(code string) (string, error) {
+func (conn *inMemoryCodeConnector) ConsumeCode(code, source string) (string, error) {
 	conn.lock.Lock()
 	defer conn.lock.Unlock()
-	if uid, ok := conn.dict[code]; ok {
+	now := conn.now()
+	conn.expire(now)
+	if len(conn.attempts[source]) >= maxCodeAttempts {
+		return "", storage.ErrorTooManyAttempts
+	}
+	if p, ok := conn.dict[code]; ok {
 		delete(conn.dict, code)
-		delete(conn.uids, uid)
-		return uid, nil
+		delete(conn.uids, p.uid)
+		return p.uid, nil
+	}
+	conn.attempts[source] = append(conn.attempts[source], now)
+	return "", storage.ErrorCodeNotFound
+}
+
+type dbCodeConnector struct {
+	store *db.CodeStore
+}
+
+func (conn *dbCodeConnector) NewCode(uid string) (string, error) {
+	code, err := newUserCode()
+	if err != nil {
+		return "", err
 	}
-	return "", errors.New("code not found")
+	return code, conn.store.SaveCode(uid, code)
+}
+
+func (conn *dbCodeConnector) ConsumeCode(code, source string) (string, error) {
+	return conn.store.ConsumeCode(code, source)
 }
@@ -2,6 +2,10 @@ package app
 
 import (
 	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGenerateCode(t *testing.T) {
@@ -13,7 +17,7 @@ func TestGenerateCode(t *testing.T) {
 		t.Error(err)
 	}
 
-	uid, err := u.ConsumeCode(code)
+	uid, err := u.ConsumeCode(code, "127.0.0.1")
 	if err != nil {
 		t.Error(err)
 	}
@@ -23,3 +27,34 @@ func TestGenerateCode(t *testing.T) {
 	}
 
 }
+
+func TestCodeExpiryAndAttempts(t *testing.T) {
+	now := time.Now()
+	u := NewCodeConnector().(*inMemoryCodeConnector)
+	u.now = func() time.Time { return now }
+
+	code, err := u.NewCode("test")
+	assert.NoError(t, err)
+	now = now.Add(codeValidity)
+	_, err = u.ConsumeCode(code, "client")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+
+	code, err = u.NewCode("test")
+	assert.NoError(t, err)
+	for i := 1; i < maxCodeAttempts; i++ {
+		_, err = u.ConsumeCode("wrong", "client")
+		assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+	}
+	// the expired code counted as the first wrong one
+	_, err = u.ConsumeCode(code, "client")
+	assert.ErrorIs(t, err, storage.ErrorTooManyAttempts)
+
+	// other clients are not affected
+	uid, err := u.ConsumeCode(code, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, "test", uid)
+
+	now = now.Add(codeAttemptWindow)
+	_, err = u.ConsumeCode("wrong", "client")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+}
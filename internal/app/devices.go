@@ -0,0 +1,87 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+var errDeviceRevoked = errors.New("device revoked")
+
+// errInvalidDeviceToken the device token is malformed, expired or not signed by us
+var errInvalidDeviceToken = errors.New("invalid device token")
+
+// registerDevice records a newly paired device, pairing again clears the revocation
+func (app *App) registerDevice(uid string, claims *DeviceClaims, ip string) {
+	now := time.Now()
+	_, err := app.devices.ChangeDevice(uid, claims.DeviceID, true, func(device *model.Device) {
+		device.Description = claims.DeviceDesc
+		device.PairedAt = now
+		device.LastSeen = now
+		device.LastIP = ip
+		device.Revoked = false
+	})
+	if err != nil {
+		log.Warn("cannot register the device: ", err)
+	}
+}
+
+// checkDevice fails if the device was revoked, devices paired before the
+// registry existed are unknown and allowed
+func (app *App) checkDevice(uid, deviceID string) error {
+	if deviceID == "" {
+		return nil
+	}
+	device, err := app.devices.GetDevice(uid, deviceID)
+	if err == storage.ErrorDeviceNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if device.Revoked {
+		return errDeviceRevoked
+	}
+	return nil
+}
+
+// deviceSeen updates the last seen time and address, adds unknown devices
+func (app *App) deviceSeen(uid string, claims *DeviceClaims, ip string) {
+	if claims.DeviceID == "" {
+		return
+	}
+	_, err := app.devices.ChangeDevice(uid, claims.DeviceID, true, func(device *model.Device) {
+		if device.Description == "" {
+			device.Description = claims.DeviceDesc
+		}
+		device.LastSeen = time.Now()
+		device.LastIP = ip
+	})
+	if err != nil {
+		log.Warn("cannot update the device: ", err)
+	}
+}
+
+// deviceSynced updates the last sync time
+func (app *App) deviceSynced(uid, deviceID string) {
+	if deviceID == "" {
+		return
+	}
+	_, err := app.devices.ChangeDevice(uid, deviceID, false, func(device *model.Device) {
+		device.LastSync = time.Now()
+	})
+	if err != nil && err != storage.ErrorDeviceNotFound {
+		log.Warn("cannot update the device: ", err)
+	}
+}
+
+// revokeDevice rejects the tokens of the device
+func (app *App) revokeDevice(uid, deviceID string) error {
+	_, err := app.devices.ChangeDevice(uid, deviceID, true, func(device *model.Device) {
+		device.Revoked = true
+	})
+	return err
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	log "github.com/sirupsen/logrus"
+)
+
+// garbageCollector deletes unreferenced blobs
+type garbageCollector interface {
+	CollectGarbageAll(opts fs.GCOptions) ([]*fs.GCReport, error)
+}
+
+// runGC collects the garbage every interval until stop is closed
+func runGC(gc garbageCollector, interval time.Duration, keepHistory int, stop <-chan struct{}) {
+	log.Info("blob gc every: ", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := gc.CollectGarbageAll(fs.GCOptions{
+				KeepHistory: keepHistory,
+				GracePeriod: fs.DefaultGCGracePeriod,
+			})
+			if err != nil {
+				log.Error("gc: ", err)
+			}
+		}
+	}
+}
+
+// trashPurgeInterval how often the expired trash is looked for
+const trashPurgeInterval = time.Hour
+
+// trashPurger deletes the documents trashed long ago
+type trashPurger interface {
+	PurgeTrashAll(retention time.Duration) (int, error)
+}
+
+// runTrashPurge purges the trash hourly until stop is closed
+func runTrashPurge(trash trashPurger, retention time.Duration, stop <-chan struct{}) {
+	log.Info("trash retention: ", retention)
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := trash.PurgeTrashAll(retention); err != nil {
+				log.Error("trash: ", err)
+			}
+		}
+	}
+}
@@ -3,8 +3,11 @@ package app
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/png"
@@ -17,6 +20,7 @@ import (
 	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/app/hub"
+	"github.com/ddvk/rmfakecloud/internal/audit"
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/email"
@@ -47,10 +51,14 @@ func (app *App) getDeviceClaims(c *gin.Context) (*DeviceClaims, error) {
 	claims := &DeviceClaims{}
 	err = common.ClaimsFromToken(claims, token, app.cfg.JWTSecretKey)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", errInvalidDeviceToken, err)
 	}
 	if claims.UserID == "" {
-		return nil, fmt.Errorf("wrong token, missing userid")
+		return nil, fmt.Errorf("%w: missing userid", errInvalidDeviceToken)
+	}
+	uid := common.SanitizeUid(strings.TrimPrefix(claims.UserID, "auth0|"))
+	if err = app.checkDevice(uid, claims.DeviceID); err != nil {
+		return nil, fmt.Errorf("device %s: %w", claims.DeviceID, err)
 	}
 	return claims, nil
 }
@@ -85,12 +93,23 @@ func (app *App) newDevice(c *gin.Context) {
 	code := strings.ToLower(tokenRequest.Code)
 	log.Info("Got code ", code)
 
-	uid, err := app.codeConnector.ConsumeCode(code)
+	ip := c.ClientIP()
+	if !app.allow(c, ip) {
+		return
+	}
+	uid, err := app.codeConnector.ConsumeCode(code, ip)
+	if err == storage.ErrorTooManyAttempts {
+		log.Warn("too many wrong codes from: ", ip)
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		log.Warn(err)
+		app.limiter.Fail(ip, "")
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+	app.limiter.Succeed(ip, "")
 	log.Info("Request: ", tokenRequest, "Token for:", uid)
 
 	// generate the JWT token
@@ -109,6 +128,13 @@ func (app *App) newDevice(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	app.registerDevice(uid, claims, c.ClientIP())
+	recordAudit(c, audit.Entry{
+		User:    uid,
+		Action:  audit.ActionDevicePair,
+		Device:  tokenRequest.DeviceID,
+		Details: map[string]string{"description": tokenRequest.DeviceDesc},
+	})
 
 	c.String(http.StatusOK, tokenString)
 }
@@ -121,13 +147,39 @@ func (app *App) deleteDevice(c *gin.Context) {
 		return
 	}
 	log.Info("Logging out: ", deviceToken.UserID)
+	if deviceToken.DeviceID != "" {
+		uid := common.SanitizeUid(strings.TrimPrefix(deviceToken.UserID, "auth0|"))
+		if err = app.revokeDevice(uid, deviceToken.DeviceID); err != nil {
+			log.Warn("cannot revoke the device: ", err)
+		}
+		recordAudit(c, audit.Entry{User: uid, Action: audit.ActionDeviceUnpair, Device: deviceToken.DeviceID})
+	}
 	c.Status(http.StatusNoContent)
 }
 
+// allow rejects the request if the client has to wait after failed attempts
+func (app *App) allow(c *gin.Context, ip string) bool {
+	if wait, ok := app.limiter.Allow(ip, ""); !ok {
+		log.Warn("too many failed attempts from: ", ip)
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
 func (app *App) newUserToken(c *gin.Context) {
+	ip := c.ClientIP()
+	if !app.allow(c, ip) {
+		return
+	}
 	deviceToken, err := app.getDeviceClaims(c)
 	if err != nil {
 		log.Error(err)
+		// a revoked device keeps asking, only forged tokens are guesses
+		if errors.Is(err, errInvalidDeviceToken) {
+			app.limiter.Fail(ip, "")
+		}
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
@@ -144,6 +196,7 @@ func (app *App) newUserToken(c *gin.Context) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
+	app.deviceSeen(common.SanitizeUid(uid), deviceToken, c.ClientIP())
 
 	scopes := []string{"intgr", "screenshare", "docedit"}
 
@@ -157,7 +210,12 @@ func (app *App) newUserToken(c *gin.Context) {
 
 	if user.Sync15 {
 		log.Info("Using sync 1.5")
-		scopes = append(scopes, syncNew)
+		if user.Quota > 0 {
+			// the tablet shows the cloud limit messages
+			scopes = append(scopes, syncNewLimited)
+		} else {
+			scopes = append(scopes, syncNew)
+		}
 	} else {
 		scopes = append(scopes, syncDefault)
 	}
@@ -214,6 +272,7 @@ func (app *App) newUserToken(c *gin.Context) {
 		badReq(c, err.Error())
 		return
 	}
+	app.limiter.Succeed(ip, "")
 	c.String(http.StatusOK, tokenString)
 }
 
@@ -227,6 +286,19 @@ func userID(c *gin.Context) string {
 	return c.GetString(userIDKey)
 }
 
+// recordAudit fills in the device and the client of an audit entry
+func recordAudit(c *gin.Context, e audit.Entry) {
+	if e.User == "" {
+		e.User = userID(c)
+	}
+	if e.Device == "" {
+		e.Device = c.GetString(deviceIDKey)
+	}
+	e.Source = audit.SourceDevice
+	e.IP = c.ClientIP()
+	audit.Record(e)
+}
+
 func extFromContentType(contentType string) (string, error) {
 	switch contentType {
 
@@ -297,13 +369,17 @@ func (app *App) uploadDoc(c *gin.Context) {
 	fileName := m.FileName + ext
 	log.Info("Uploading: ", fileName)
 
-	err = saveUpload(app, syncVer, uid, deviceID, fileName, f)
-
+	doc, err := saveUpload(app, syncVer, uid, deviceID, fileName, f, file.Size)
+	if errors.Is(err, storage.ErrorQuotaExceeded) {
+		quotaExceeded(c)
+		return
+	}
 	if err != nil {
 		log.Error(handlerLog, err)
 		internalError(c, "can't upload")
 		return
 	}
+	recordAudit(c, audit.Entry{Action: audit.ActionDocumentCreate, Document: doc.ID, Details: map[string]string{"name": fileName}})
 	c.Status(http.StatusOK)
 }
 
@@ -368,30 +444,40 @@ func (app *App) uploadDocV2(c *gin.Context) {
 	fileName := m.FileName + ext
 	log.Info("Uploading: ", fileName)
 
-	err = saveUpload(app, syncVer, uid, deviceID, fileName, f)
+	doc, err := saveUpload(app, syncVer, uid, deviceID, fileName, f, c.Request.ContentLength)
+	if errors.Is(err, storage.ErrorQuotaExceeded) {
+		quotaExceeded(c)
+		return
+	}
 	if err != nil {
 		log.Error(handlerLog, err)
 		internalError(c, "can't upload")
 		return
 	}
+	recordAudit(c, audit.Entry{Action: audit.ActionDocumentCreate, Document: doc.ID, Details: map[string]string{"name": fileName}})
 
 	c.Status(http.StatusOK)
 }
 
-func saveUpload(app *App, syncVer common.SyncVersion, uid, deviceID, fileName string, f io.ReadCloser) error {
+func saveUpload(app *App, syncVer common.SyncVersion, uid, deviceID, fileName string, f io.Reader, size int64) (*storage.Document, error) {
+	f, err := app.quota.QuotaReader(uid, f, size)
+	if err != nil {
+		return nil, err
+	}
 	//HACK:
 	if syncVer == common.Sync15 {
 		log.Info("sync 15 upload")
-		_, err := app.blobStorer.CreateBlobDocument(uid, fileName, "", f)
+		d, err := app.blobStorer.CreateBlobDocument(uid, fileName, "", f)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		app.hub.NotifySync(uid, deviceID)
+		return d, nil
 	} else {
 		log.Info("sync 10 upload")
 		d, err := app.docStorer.CreateDocument(uid, fileName, "", f)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		ntf := hub.DocumentNotification{
 			Parent:  "",
@@ -401,8 +487,8 @@ func saveUpload(app *App, syncVer common.SyncVersion, uid, deviceID, fileName st
 			Version: 1,
 		}
 		app.hub.Notify(uid, deviceID, ntf, messages.DocAddedEvent)
+		return d, nil
 	}
-	return nil
 }
 
 type emailForm struct {
@@ -586,6 +672,7 @@ func (app *App) deleteDocument(c *gin.Context) {
 					Name:    doc.VissibleName,
 				}
 				app.hub.Notify(uid, deviceID, ntf, messages.DocDeletedEvent)
+				recordAudit(c, audit.Entry{Action: audit.ActionDocumentDelete, Document: doc.ID, Details: map[string]string{"name": doc.VissibleName}})
 			}
 		}
 		result = append(result, messages.StatusResponse{ID: r.ID, Success: ok})
@@ -610,12 +697,17 @@ func (app *App) updateStatus(c *gin.Context) {
 		message := ""
 
 		ok := false
+		// compared afterwards to tell a move from a rename
+		old, _ := app.metaStorer.GetMetadata(uid, doc.ID)
 		err := app.metaStorer.UpdateMetadata(uid, &doc)
 		if err != nil {
 			message = internalErrorMessage
 			log.Error(err)
 		} else {
 			ok = true
+			if e, changed := metadataAudit(old, &doc); changed {
+				recordAudit(c, e)
+			}
 
 			ntf := hub.DocumentNotification{
 				ID:      doc.ID,
@@ -649,6 +741,7 @@ func (app *App) syncComplete(c *gin.Context) {
 	uid := userID(c)
 	deviceID := c.GetString(deviceIDKey)
 
+	app.deviceSynced(uid, deviceID)
 	var res messages.SyncCompleted
 	res.ID = app.hub.NotifySync(uid, deviceID)
 	c.JSON(http.StatusOK, res)
@@ -666,6 +759,7 @@ func (app *App) syncCompleteV2(c *gin.Context) {
 		return
 	}
 	log.Info("got sync completed, gen: ", req.Generation)
+	app.deviceSynced(uid, deviceID)
 
 	notificationID := app.hub.NotifySync(uid, deviceID)
 
@@ -748,15 +842,42 @@ func (app *App) syncUpdateRootV3(c *gin.Context) {
 	}
 
 	uid := userID(c)
+	oldHash, oldGeneration := app.currentRoot(uid)
 	newgeneration, err := app.blobStorer.StoreBlob(uid, RootHash, bytes.NewBufferString(rootv3.Hash), rootv3.Generation)
 	if err != nil {
 		log.Error(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	recordAudit(c, audit.Entry{
+		Action: audit.ActionRootUpdate,
+		Details: map[string]string{
+			"oldHash":       oldHash,
+			"newHash":       rootv3.Hash,
+			"oldGeneration": strconv.FormatInt(oldGeneration, 10),
+			"newGeneration": strconv.FormatInt(newgeneration, 10),
+		},
+	})
+	if audit.Enabled() {
+		changes, err := app.rootChanges.RootChanges(uid, oldHash, rootv3.Hash)
+		if err != nil {
+			log.Warn("audit, root changes: ", err)
+		}
+		for _, e := range changes {
+			recordAudit(c, e)
+		}
+	}
+
+	go func() {
+		if err := app.search.UpdateSearchIndex(uid); err != nil {
+			log.Warn("search index: ", err)
+		}
+	}()
+
+	deviceID := c.GetString(deviceIDKey)
+	app.deviceSynced(uid, deviceID)
 
 	if rootv3.Broadcast {
-		deviceID := c.GetString(deviceIDKey)
 
 		log.Info("got sync completed, gen: ", newgeneration)
 
@@ -776,6 +897,15 @@ const RmFileHeader = "rm-filename"
 
 const RootHash = "root"
 
+// isBlobHash checks that the id is a hex encoded sha256
+func isBlobHash(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
 // crcJSON calculates and ands the crc32c header
 // TODO: fix it with a custom render or something
 func crcJSON(c *gin.Context, status int, msg any) {
@@ -792,6 +922,45 @@ func crcJSON(c *gin.Context, status int, msg any) {
 	c.Data(status, "application/json", b)
 }
 
+// currentRoot the root hash and generation before an update, for the audit log
+func (app *App) currentRoot(uid string) (string, int64) {
+	if !audit.Enabled() {
+		return "", 0
+	}
+	reader, generation, _, _, err := app.blobStorer.LoadBlob(uid, RootHash)
+	if err != nil {
+		return "", 0
+	}
+	defer reader.Close()
+	hash, err := io.ReadAll(reader)
+	if err != nil {
+		return "", 0
+	}
+	return string(hash), generation
+}
+
+// metadataAudit tells what a sync10 metadata update did, false for the page and bookmark changes
+func metadataAudit(old, doc *messages.RawMetadata) (audit.Entry, bool) {
+	e := audit.Entry{
+		Document: doc.ID,
+		Details:  map[string]string{"name": doc.VissibleName},
+	}
+	switch {
+	case old == nil:
+		e.Action = audit.ActionDocumentCreate
+	case old.Parent != doc.Parent:
+		e.Action = audit.ActionDocumentMove
+		e.Details["oldParent"] = old.Parent
+		e.Details["parent"] = doc.Parent
+	case old.VissibleName != doc.VissibleName:
+		e.Action = audit.ActionDocumentRename
+		e.Details["oldName"] = old.VissibleName
+	default:
+		return e, false
+	}
+	return e, true
+}
+
 func (app *App) syncGetRootV3(c *gin.Context) {
 	uid := userID(c)
 	reader, generation, _, _, err := app.blobStorer.LoadBlob(uid, RootHash)
@@ -872,11 +1041,19 @@ func (app *App) checkFilesPresence(c *gin.Context) {
 }
 
 func (app *App) checkMissingBlob(c *gin.Context) {
-	mhs := messages.MissingHashes{}
+	uid := userID(c)
 
-	// TODO
+	hashes, err := app.blobStorer.MissingBlobs(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(hashes) > 0 {
+		log.Warnf("%d blobs missing for %s", len(hashes), uid)
+	}
 
-	c.JSON(http.StatusOK, mhs)
+	c.JSON(http.StatusOK, messages.MissingHashes{Hashes: hashes})
 }
 
 func (app *App) blobStorageRead(c *gin.Context) {
@@ -906,9 +1083,29 @@ func (app *App) blobStorageWrite(c *gin.Context) {
 
 	fileName := c.GetHeader(RmFileHeader)
 	hash := c.GetHeader(common.GCPHashHeader)
-	log.Debugf("TODO: check/save etc. write file '%s', hash '%s'", fileName, hash)
+	log.Debugf("write file '%s', hash '%s'", fileName, hash)
+
+	if !isBlobHash(blobID) {
+		log.Warn("not a blob hash: ", blobID)
+		badReq(c, "invalid blob id")
+		return
+	}
 
-	_, err := app.blobStorer.StoreBlob(uid, blobID, c.Request.Body, 0)
+	// indexes and metadata can go a little over the quota, so that documents can still be moved or deleted
+	body, err := app.quota.QuotaReader(uid, c.Request.Body, c.Request.ContentLength)
+	if err == nil {
+		body = fs.NewVerifyingReader(body, blobID, common.CRC32CFromHashHeader(hash))
+		_, err = app.blobStorer.StoreBlob(uid, blobID, body, 0)
+	}
+	if errors.Is(err, storage.ErrorQuotaExceeded) {
+		quotaExceeded(c)
+		return
+	}
+	if errors.Is(err, fs.ErrorHashMismatch) {
+		log.Warn(err)
+		badReq(c, err.Error())
+		return
+	}
 	if err != nil {
 		log.Error(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
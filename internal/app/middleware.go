@@ -47,6 +47,12 @@ func (app *App) authMiddleware() gin.HandlerFunc {
 		}
 
 		uid := common.SanitizeUid(strings.TrimPrefix(claims.Profile.UserID, "auth0|"))
+		if err = app.checkDevice(uid, claims.DeviceID); err != nil {
+			log.Warn(authLog, "device: ", claims.DeviceID, " ", err)
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
 		c.Set(userIDKey, uid)
 		c.Set(deviceIDKey, claims.DeviceID)
 		log.Infof("%s UserId: %s deviceId: %s newSync: %t", authLog, uid, claims.DeviceID, isSync15)
@@ -6,6 +6,7 @@ import (
 	"runtime"
 
 	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -51,6 +52,8 @@ func (app *App) registerRoutes(router *gin.Engine) {
 		sysmb := ms.Sys / mb
 		c.String(http.StatusOK, "Working, %d clients, gn: %d, mem: %dkb sys: %dmb", count, gnum, live, sysmb)
 	})
+	router.GET("/metrics", metrics.Handler(app.cfg.MetricsToken))
+
 	// register  a new device
 	router.POST("/token/json/2/device/new", app.newDevice)
 
@@ -0,0 +1,236 @@
+// Package audit keeps an append only log of the user visible actions
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// actions
+const (
+	ActionLogin             = "login"
+	ActionLoginFailed       = "login.failed"
+	ActionDevicePair        = "device.pair"
+	ActionDeviceUnpair      = "device.unpair"
+	ActionDeviceRename      = "device.rename"
+	ActionDocumentCreate    = "document.create"
+	ActionDocumentMove      = "document.move"
+	ActionDocumentRename    = "document.rename"
+	ActionDocumentDelete    = "document.delete"
+	ActionDocumentRestore   = "document.restore"
+	ActionTrashEmpty        = "trash.empty"
+	ActionRootUpdate        = "root.update"
+	ActionRootRestore       = "root.restore"
+	ActionIntegrationCreate = "integration.create"
+	ActionIntegrationUpdate = "integration.update"
+	ActionIntegrationDelete = "integration.delete"
+	ActionUserCreate        = "user.create"
+	ActionUserUpdate        = "user.update"
+	ActionUserDelete        = "user.delete"
+	ActionUserResetTOTP     = "user.reset2fa"
+	ActionUserMigrate       = "user.migrate"
+	ActionLibraryExport     = "library.export"
+	ActionLibraryImport     = "library.import"
+	ActionTokenCreate       = "token.create"
+	ActionTokenDelete       = "token.delete"
+)
+
+// sources
+const (
+	SourceDevice = "device"
+	SourceWeb    = "web"
+)
+
+const (
+	// DefaultLimit entries returned by a query
+	DefaultLimit = 100
+	// MaxLimit most entries returned by a query
+	MaxLimit = 1000
+)
+
+// Entry an audited action
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	User     string            `json:"user"`
+	Action   string            `json:"action"`
+	Source   string            `json:"source,omitempty"`
+	Device   string            `json:"device,omitempty"`
+	IP       string            `json:"ip,omitempty"`
+	Document string            `json:"document,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// Filter selects the entries of a query
+type Filter struct {
+	User string
+	// Action matches exactly or as a prefix, eg document matches document.delete
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func (f *Filter) match(e *Entry) bool {
+	if f.User != "" && e.User != f.User {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action && !strings.HasPrefix(e.Action, f.Action+".") {
+		return false
+	}
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+var (
+	mu   sync.Mutex
+	path string
+	file *os.File
+)
+
+// Open starts appending to the log at path
+func Open(p string) error {
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = endLine(p, f); err != nil {
+		f.Close()
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+	}
+	path = p
+	file = f
+	return nil
+}
+
+// endLine ends a line torn by a crash, the next entry starts on its own line
+func endLine(p string, f *os.File) error {
+	r, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err = r.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = f.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// Close stops logging
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	err := file.Close()
+	file = nil
+	path = ""
+	return err
+}
+
+// Enabled if the log is open
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return file != nil
+}
+
+// Record appends an entry, does nothing when the log is not open
+func Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(&e)
+	if err != nil {
+		log.Warn("audit: ", err)
+		return
+	}
+	line = append(line, '\n')
+
+	mu.Lock()
+	defer mu.Unlock()
+	if file == nil {
+		return
+	}
+	// a single write per entry, O_APPEND keeps the lines whole
+	if _, err = file.Write(line); err != nil {
+		log.Error("audit: ", err)
+	}
+}
+
+// Query returns the matching entries, newest first
+func Query(filter Filter) ([]Entry, error) {
+	mu.Lock()
+	p := path
+	mu.Unlock()
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	if filter.Limit > MaxLimit {
+		filter.Limit = MaxLimit
+	}
+
+	entries := []Entry{}
+	if p == "" {
+		return entries, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// a torn line after a crash, skip it
+			continue
+		}
+		if !filter.match(&e) {
+			continue
+		}
+		entries = append(entries, e)
+		// keep only the newest ones in memory
+		if len(entries) > 2*filter.Limit {
+			entries = append(entries[:0], entries[len(entries)-filter.Limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// appended in order, the newest are at the end
+	slices.Reverse(entries)
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
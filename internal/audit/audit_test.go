@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	// not open yet, nothing happens
+	Record(Entry{User: "alice", Action: ActionLogin})
+
+	p := filepath.Join(t.TempDir(), "audit.log")
+	assert.NoError(t, Open(p))
+	defer Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	Record(Entry{Time: start, User: "alice", Action: ActionLogin, Source: SourceWeb})
+	Record(Entry{Time: start.Add(time.Minute), User: "alice", Action: ActionDocumentDelete, Document: "doc1", Device: "rm2"})
+	Record(Entry{Time: start.Add(2 * time.Minute), User: "bob", Action: ActionDocumentRename, Details: map[string]string{"name": "new"}})
+
+	// a line torn by a crash is skipped, the log is opened again after the restart
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	f.WriteString("{\"time\":")
+	f.Close()
+	assert.NoError(t, Open(p))
+	Record(Entry{Time: start.Add(3 * time.Minute), User: "alice", Action: ActionRootUpdate})
+
+	all, err := Query(Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, all, 4, "only the torn line is lost")
+	assert.Equal(t, ActionRootUpdate, all[0].Action, "newest first")
+
+	docs, _ := Query(Filter{Action: "document"})
+	assert.Len(t, docs, 2)
+	alice, _ := Query(Filter{User: "alice", Action: ActionDocumentDelete})
+	assert.Len(t, alice, 1)
+	assert.Equal(t, "rm2", alice[0].Device)
+	ranged, _ := Query(Filter{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)})
+	assert.Len(t, ranged, 1)
+	assert.Equal(t, "doc1", ranged[0].Document)
+	limited, _ := Query(Filter{Limit: 1})
+	assert.Len(t, limited, 1)
+	// no prefix match inside a word
+	none, _ := Query(Filter{Action: "doc"})
+	assert.Empty(t, none)
+}
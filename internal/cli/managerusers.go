@@ -3,6 +3,8 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/model"
@@ -18,6 +20,13 @@ func (cli *Cli) ListUsers(args []string) {
 	}
 	for _, u := range users {
 		fmt.Print(u.ID)
+		if u.Quota > 0 {
+			used, err := cli.storage.StorageUsage(u.ID)
+			if err != nil {
+				log.Warn(err)
+			}
+			fmt.Printf("\t%d/%d MB", used/1024/1024, u.Quota/1024/1024)
+		}
 		if u.IsAdmin {
 			fmt.Println("\tadmin")
 		} else {
@@ -34,6 +43,8 @@ func (cli *Cli) SetUser(args []string) {
 	pass := userParam.String("p", "", "password")
 	admin := userParam.Bool("a", false, "isadmmin")
 	sync15 := userParam.Bool("s", false, "should the user use the new sync")
+	quota := userParam.Int64("q", -1, "storage quota in MB, 0 unlimited")
+	resetTOTP := userParam.Bool("reset-2fa", false, "disable the two-factor authentication")
 
 	userParam.Parse(args)
 	if *username == "" {
@@ -61,6 +72,12 @@ func (cli *Cli) SetUser(args []string) {
 	}
 	usr.IsAdmin = *admin
 	usr.Sync15 = *sync15
+	if *quota >= 0 {
+		usr.Quota = *quota * 1024 * 1024
+	}
+	if *resetTOTP {
+		usr.ResetTOTP()
+	}
 
 	err = cli.storage.UpdateUser(usr)
 	if err != nil {
@@ -69,6 +86,338 @@ func (cli *Cli) SetUser(args []string) {
 	log.Info("Updated/created the user")
 }
 
+// MigrateUsers copies the yaml profiles into the configured user database
+func (cli *Cli) MigrateUsers(args []string) {
+	migrateParam := flag.NewFlagSet("migrateusers", flag.ExitOnError)
+	dryRun := migrateParam.Bool("n", false, "dry run, only list the users")
+
+	migrateParam.Parse(args)
+
+	if cli.storage.Cfg.UserDBConfig == nil {
+		log.Fatal("no user database configured, set USER_STORAGE to sqlite or postgres")
+	}
+
+	users, err := fs.NewProfileStore(cli.storage.Cfg).GetUsers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dst := cli.storage.UserStore()
+	for _, u := range users {
+		fmt.Println(u.ID)
+		if *dryRun {
+			continue
+		}
+		if err = dst.UpdateUser(u); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *dryRun {
+		fmt.Println("dry run, would migrate:", len(users), "users")
+	} else {
+		fmt.Println("migrated:", len(users), "users")
+	}
+}
+
+// CollectGarbage deletes the unreachable sync15 blobs
+func (cli *Cli) CollectGarbage(args []string) {
+	gcParam := flag.NewFlagSet("gc", flag.ExitOnError)
+	username := gcParam.String("u", "", "only this user (default: all)")
+	dryRun := gcParam.Bool("n", false, "dry run, only report")
+	keep := gcParam.Int("k", 0, "keep the blobs of the last N roots in the history")
+	grace := gcParam.Duration("g", fs.DefaultGCGracePeriod, "don't delete blobs newer than")
+	verbose := gcParam.Bool("v", false, "list the deleted blobs")
+
+	gcParam.Parse(args)
+
+	opts := fs.GCOptions{
+		DryRun:      *dryRun,
+		KeepHistory: *keep,
+		GracePeriod: *grace,
+	}
+
+	var reports []*fs.GCReport
+	if *username != "" {
+		report, err := cli.storage.CollectGarbage(*username, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports = append(reports, report)
+	} else {
+		var err error
+		reports, err = cli.storage.CollectGarbageAll(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var total int64
+	for _, r := range reports {
+		fmt.Printf("%s\treachable: %d\trecent: %d\tswept: %d\t%d bytes\n", r.UID, r.Reachable, r.Recent, len(r.Swept), r.SweptBytes)
+		if *verbose {
+			for _, b := range r.Swept {
+				fmt.Println("\t", b)
+			}
+		}
+		total += r.SweptBytes
+	}
+	if *dryRun {
+		fmt.Println("dry run, would free:", total, "bytes")
+	} else {
+		fmt.Println("freed:", total, "bytes")
+	}
+}
+
+// DedupBlobs links the identical blobs of all users to a single shared copy
+func (cli *Cli) DedupBlobs(args []string) {
+	dedupParam := flag.NewFlagSet("dedup", flag.ExitOnError)
+	dryRun := dedupParam.Bool("n", false, "dry run, only report")
+
+	dedupParam.Parse(args)
+
+	if cli.storage.Cfg.S3Config != nil {
+		log.Fatal("the shared blobs are only for the fs blob storage")
+	}
+	report, err := cli.storage.DedupBlobs(*dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("blobs: %d\tlinked: %d\tshared: %d\tskipped: %d\n", report.Files, report.Linked, report.Added, report.Skipped)
+	if *dryRun {
+		fmt.Println("dry run, would save:", report.SavedBytes, "bytes")
+	} else {
+		fmt.Println("saved:", report.SavedBytes, "bytes")
+	}
+	if !cli.storage.Cfg.BlobDedup {
+		fmt.Println("BLOB_DEDUP is not set, the new blobs won't be shared")
+	}
+}
+
+// CompressBlobs compresses the blobs stored before the compression was enabled
+func (cli *Cli) CompressBlobs(args []string) {
+	compressParam := flag.NewFlagSet("compress", flag.ExitOnError)
+	dryRun := compressParam.Bool("n", false, "dry run, only measure")
+
+	compressParam.Parse(args)
+
+	report, err := cli.storage.CompressBlobs(*dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("blobs: %d\tcompressed: %d\talready: %d\n", report.Files, report.Compressed, report.Already)
+	fmt.Printf("%d -> %d bytes\n", report.Before, report.After)
+	if *dryRun {
+		fmt.Println("dry run, would save:", report.Before-report.After, "bytes")
+	} else {
+		fmt.Println("saved:", report.Before-report.After, "bytes")
+	}
+	if cli.storage.Cfg.BlobCompression == "" {
+		fmt.Println("BLOB_COMPRESSION is not set, the new blobs won't be compressed")
+	}
+}
+
+// EncryptData encrypts the existing data dir in place, rewraps the data keys after a master key change
+// and rotates the data keys
+func (cli *Cli) EncryptData(args []string) {
+	encryptParam := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	dryRun := encryptParam.Bool("n", false, "dry run, only count")
+	rotate := encryptParam.Bool("rotate", false, "new data keys for all users, everything is encrypted again")
+
+	encryptParam.Parse(args)
+
+	if cli.storage.Cfg.EncryptionKey == nil {
+		log.Fatal("ENCRYPTION_KEY is not set")
+	}
+	report, err := cli.storage.EncryptDataDir(fs.EncryptOptions{RotateDataKeys: *rotate, DryRun: *dryRun})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("users: %d\tkeys rewrapped: %d\tprofiles: %d\n", report.Users, report.Rewrapped, report.Profiles)
+	if *dryRun {
+		fmt.Printf("files: %d\twould encrypt: %d\tshared blobs to remove: %d\n", report.Files, report.Encrypted, report.Shared)
+		return
+	}
+	fmt.Printf("files: %d\tencrypted: %d\tshared blobs removed: %d\n", report.Files, report.Encrypted, report.Shared)
+	if len(cli.storage.Cfg.EncryptionOldKeys) > 0 {
+		fmt.Println("all data keys are wrapped with the current master key, ENCRYPTION_OLD_KEYS can be removed")
+	}
+}
+
+// History lists, compares and restores the sync15 root generations
+func (cli *Cli) History(args []string) {
+	historyParam := flag.NewFlagSet("history", flag.ExitOnError)
+	username := historyParam.String("u", "", "username")
+	diff := historyParam.Int64("diff", 0, "compare this generation")
+	to := historyParam.Int64("to", 0, "with this one (default: current)")
+	restore := historyParam.Int64("restore", 0, "restore this generation")
+
+	historyParam.Parse(args)
+	if *username == "" {
+		historyParam.PrintDefaults()
+		return
+	}
+
+	switch {
+	case *restore > 0:
+		gen, err := cli.storage.RestoreGeneration(*username, *restore)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("restored, new generation:", gen)
+		// the hub is in the server process, the connected devices can't be notified from here
+		fmt.Println("the connected devices are not notified, they get it on their next sync")
+		fmt.Println("restore from the web UI to notify them right away")
+	case *diff > 0:
+		if *to == 0 {
+			_, gen, err := cli.storage.BlobStorage(*username).GetRootIndex()
+			if err != nil {
+				log.Fatal(err)
+			}
+			*to = gen
+		}
+		d, err := cli.storage.DiffGenerations(*username, *diff, *to)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d -> %d\n", d.From, d.To)
+		for _, c := range d.Added {
+			fmt.Println("+", c.ID, c.Name)
+		}
+		for _, c := range d.Removed {
+			fmt.Println("-", c.ID, c.Name)
+		}
+		for _, c := range d.Modified {
+			fmt.Println("~", c.ID, c.Name)
+		}
+	default:
+		generations, err := cli.storage.ListGenerations(*username)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, g := range generations {
+			fmt.Printf("%d\t%s\t", g.Generation, g.Date.Local().Format(time.RFC3339))
+			if g.Available {
+				fmt.Printf("%d docs", g.Documents)
+			} else {
+				fmt.Print("unavailable")
+			}
+			if g.Current {
+				fmt.Print("\tcurrent")
+			}
+			fmt.Println()
+		}
+	}
+}
+
+// MigrateSync15 copies the sync10 documents of a user into the sync15 blob tree
+func (cli *Cli) MigrateSync15(args []string) {
+	migrateParam := flag.NewFlagSet("migratesync15", flag.ExitOnError)
+	username := migrateParam.String("u", "", "username")
+	dryRun := migrateParam.Bool("n", false, "dry run, only report")
+	enable := migrateParam.Bool("enable", false, "switch the user to sync15 afterwards")
+
+	migrateParam.Parse(args)
+	if *username == "" {
+		migrateParam.PrintDefaults()
+		return
+	}
+
+	usr, err := cli.storage.GetUser(*username)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := cli.storage.MigrateToSync15(usr.ID, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("%s\t%s\t%s\n", p.ID, p.Name, p.Reason)
+	}
+	if *dryRun {
+		fmt.Printf("dry run, would migrate: %d documents, %d folders\n", report.Documents, report.Folders)
+	} else {
+		fmt.Printf("migrated: %d documents, %d folders\n", report.Documents, report.Folders)
+	}
+	fmt.Println("already in sync15:", report.Existing, "problems:", len(report.Problems))
+
+	if *enable && !*dryRun && !usr.Sync15 {
+		usr.Sync15 = true
+		if err = cli.storage.UpdateUser(usr); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("switched to sync15, the devices have to sync again")
+	}
+}
+
+// ExportLibrary writes all the documents of a user to a zip
+func (cli *Cli) ExportLibrary(args []string) {
+	exportParam := flag.NewFlagSet("exportlibrary", flag.ExitOnError)
+	username := exportParam.String("u", "", "username")
+	output := exportParam.String("o", "", "output file (default: <user>-library.zip)")
+
+	exportParam.Parse(args)
+	if *username == "" {
+		exportParam.PrintDefaults()
+		return
+	}
+	if *output == "" {
+		*output = *username + "-library.zip"
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cli.storage.ExportLibrary(*username, f); err != nil {
+		f.Close()
+		os.Remove(*output)
+		log.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("exported to:", *output)
+}
+
+// ImportLibrary adds the documents of an exported library to a user
+func (cli *Cli) ImportLibrary(args []string) {
+	importParam := flag.NewFlagSet("importlibrary", flag.ExitOnError)
+	username := importParam.String("u", "", "username")
+	input := importParam.String("i", "", "the exported zip")
+
+	importParam.Parse(args)
+	if *username == "" || *input == "" {
+		importParam.PrintDefaults()
+		return
+	}
+
+	usr, err := cli.storage.GetUser(*username)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !usr.Sync15 {
+		log.Fatal("the user is not on sync15, run migratesync15 first")
+	}
+	f, err := os.Open(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := cli.storage.ImportLibrary(usr.ID, f, stat.Size())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("%s\t%s\t%s\n", p.ID, p.Name, p.Reason)
+	}
+	fmt.Printf("imported: %d documents, %d folders\n", report.Documents, report.Folders)
+	fmt.Println("already there:", report.Existing, "problems:", len(report.Problems))
+}
+
 // Cli cli interface
 type Cli struct {
 	storage *fs.FileSystemStorage
@@ -76,11 +425,8 @@ type Cli struct {
 
 // New creates
 func New(cfg *config.Config) *Cli {
-	storage := &fs.FileSystemStorage{
-		Cfg: cfg,
-	}
 	return &Cli{
-		storage: storage,
+		storage: fs.NewStorage(cfg),
 	}
 
 }
@@ -95,6 +441,24 @@ func (cli *Cli) Handle(args []string) bool {
 			cli.SetUser(otherarg)
 		case "listusers":
 			cli.ListUsers(otherarg)
+		case "migrateusers":
+			cli.MigrateUsers(otherarg)
+		case "gc":
+			cli.CollectGarbage(otherarg)
+		case "dedup":
+			cli.DedupBlobs(otherarg)
+		case "compress":
+			cli.CompressBlobs(otherarg)
+		case "encrypt":
+			cli.EncryptData(otherarg)
+		case "history":
+			cli.History(otherarg)
+		case "migratesync15":
+			cli.MigrateSync15(otherarg)
+		case "exportlibrary":
+			cli.ExportLibrary(otherarg)
+		case "importlibrary":
+			cli.ImportLibrary(otherarg)
 		case "rmuser":
 		default:
 			log.Warn("unknown command: ", cmd)
@@ -109,5 +473,14 @@ func Usage() string {
 	return `Commands:
 	setuser		create users / reset passwords
 	listusers	list available users
+	migrateusers	copy the user profiles into the user database (-n dry run)
+	gc		delete unreferenced sync15 blobs (-n dry run)
+	dedup		share the identical sync15 blobs of all users (-n dry run)
+	compress	compress the stored sync15 blobs (-n dry run, measures the savings)
+	encrypt		encrypt the data dir, rewrap or rotate the keys (-n dry run, -rotate new data keys)
+	history		list, compare and restore sync15 generations
+	migratesync15	copy the sync10 documents of a user to sync15 (-n dry run)
+	exportlibrary	write all the documents of a user to a zip
+	importlibrary	add the documents of an exported zip to a user
 `
 }
@@ -110,3 +110,13 @@ const GCPHashHeader = "x-goog-hash"
 func AddHashHeader(c *gin.Context, hash string) {
 	c.Header(GCPHashHeader, hash)
 }
+
+// CRC32CFromHashHeader extracts the crc32c from x-goog-hash: crc32c=<base64>,md5=<base64>
+func CRC32CFromHashHeader(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		if crc, ok := strings.CutPrefix(strings.TrimSpace(part), "crc32c="); ok {
+			return crc
+		}
+	}
+	return ""
+}
@@ -6,13 +6,20 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/mail"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ddvk/rmfakecloud/internal/email"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/ddvk/rmfakecloud/internal/storage/crypt"
+	"github.com/ddvk/rmfakecloud/internal/storage/db"
+	"github.com/ddvk/rmfakecloud/internal/storage/s3"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -31,6 +38,17 @@ const (
 	// DefaultHost fake url
 	DefaultHost = "local.appspot.com"
 
+	// DefaultLockoutAfter failed logins before the account is locked
+	DefaultLockoutAfter = 10
+	// DefaultAccountLockoutAfter failed logins from all addresses before the account is locked for everyone
+	DefaultAccountLockoutAfter = 100
+	// DefaultLockoutDuration how long the account stays locked
+	DefaultLockoutDuration = 15 * time.Minute
+	// DefaultAuditLog the audit log in the data dir
+	DefaultAuditLog = "audit.log"
+	// BlobCompressionZstd compress the blobs with zstd
+	BlobCompressionZstd = "zstd"
+
 	// EnvLogLevel environment variable for the log level
 	EnvLogLevel = "LOGLEVEL"
 	// EnvLogFormat type of log format
@@ -77,10 +95,65 @@ const (
 	EnvLogFile     = "RM_LOGFILE"
 	envHTTPSCookie = "RM_HTTPS_COOKIE"
 	envTrustProxy  = "RM_TRUST_PROXY"
+	// envTrustedProxies the proxy addresses allowed to set the client ip
+	envTrustedProxies = "RM_TRUSTED_PROXIES"
+	// envLockoutAfter lock the account after N failed logins
+	envLockoutAfter = "LOGIN_LOCKOUT_AFTER"
+	// envAccountLockoutAfter lock the account for everyone after N failed logins from any address
+	envAccountLockoutAfter = "LOGIN_ACCOUNT_LOCKOUT_AFTER"
+	// envLockoutDuration how long an account stays locked
+	envLockoutDuration = "LOGIN_LOCKOUT_DURATION"
+	// envMetricsToken bearer token for /metrics
+	envMetricsToken = "METRICS_TOKEN"
+	// envAuditLog the audit log file, off to disable
+	envAuditLog = "AUDIT_LOG"
 
 	envMQTTPort          = "MQTT_PORT"
 	envICEServers        = "ICE_SERVERS"
 	envHashSchemaVersion = "HASH_SCHEMA_VERSION"
+
+	// envBlobStorage where to keep the sync15 blobs: fs or s3
+	envBlobStorage   = "BLOB_STORAGE"
+	envS3Endpoint    = "S3_ENDPOINT"
+	envS3Region      = "S3_REGION"
+	envS3Bucket      = "S3_BUCKET"
+	envS3AccessKey   = "S3_ACCESS_KEY"
+	envS3SecretKey   = "S3_SECRET_KEY"
+	envS3Prefix      = "S3_PREFIX"
+	envS3VirtualHost = "S3_VIRTUAL_HOST"
+	// envBlobDedup share the identical blobs of all users
+	envBlobDedup = "BLOB_DEDUP"
+	// envBlobCompression compress the blobs at rest: none or zstd
+	envBlobCompression = "BLOB_COMPRESSION"
+	// envEncryptionKey master key encrypting the keys of the users, hex or base64
+	envEncryptionKey = "ENCRYPTION_KEY"
+	// envEncryptionOldKeys previous master keys, comma separated, to rotate
+	envEncryptionOldKeys = "ENCRYPTION_OLD_KEYS"
+
+	// envUserStorage where to keep the users: fs, sqlite or postgres
+	envUserStorage = "USER_STORAGE"
+	// envUserDB the sqlite file or the postgres connection string
+	envUserDB = "USER_DB"
+	// envCodeStorage where to keep the device pairing codes: memory or db
+	envCodeStorage = "CODE_STORAGE"
+
+	// envOIDCIssuer enables the OpenID Connect login of the web UI
+	envOIDCIssuer       = "OIDC_ISSUER"
+	envOIDCClientID     = "OIDC_CLIENT_ID"
+	envOIDCClientSecret = "OIDC_CLIENT_SECRET"
+	envOIDCRedirectURL  = "OIDC_REDIRECT_URL"
+	envOIDCScopes       = "OIDC_SCOPES"
+	envOIDCUserClaim    = "OIDC_USER_CLAIM"
+	envOIDCGroupsClaim  = "OIDC_GROUPS_CLAIM"
+	envOIDCAdminGroup   = "OIDC_ADMIN_GROUP"
+	envOIDCAutoCreate   = "OIDC_AUTO_CREATE"
+
+	// envGCInterval run the blob garbage collection periodically
+	envGCInterval = "GC_INTERVAL"
+	// envGCKeepHistory keep the blobs of the last N roots
+	envGCKeepHistory = "GC_KEEP_HISTORY"
+	// envTrashRetention purge the sync15 trash after
+	envTrashRetention = "TRASH_RETENTION"
 )
 
 // Config config
@@ -103,9 +176,40 @@ type Config struct {
 	HWRHost           string
 	HTTPSCookie       bool
 	TrustProxy        bool
+	// TrustedProxies networks allowed to set the client ip when TrustProxy, empty all
+	TrustedProxies    []string
+	LockoutAfter      int
+	// AccountLockoutAfter failures from all the addresses lock the account
+	AccountLockoutAfter int
+	LockoutDuration   time.Duration
+	// MetricsToken protects /metrics if set
+	MetricsToken      string
+	// AuditLog json lines file of the user actions, empty disabled
+	AuditLog          string
 	MQTTPort          string
 	ICEServers        []interface{}
 	HashSchemaVersion string
+	// S3Config blobs are stored in s3 if set
+	S3Config          *s3.Config
+	// BlobDedup identical blobs are hard linked to a single shared copy
+	BlobDedup         bool
+	// BlobCompression the blobs are compressed at rest if set, only zstd
+	BlobCompression   string
+	// EncryptionKey the data of the users is encrypted at rest if set
+	EncryptionKey     []byte
+	// EncryptionOldKeys previous master keys, only to decrypt
+	EncryptionOldKeys [][]byte
+	// GCInterval blob garbage collection interval, 0 disabled
+	GCInterval        time.Duration
+	GCKeepHistory     int
+	// TrashRetention trashed documents are purged after, 0 never
+	TrashRetention    time.Duration
+	// UserDBConfig users are stored in a database if set
+	UserDBConfig      *db.Config
+	// OIDC the web UI login with an identity provider if set
+	OIDC              *oidc.Config
+	// CodeDBConfig pairing codes are stored in a database if set
+	CodeDBConfig      *db.Config
 }
 
 // Verify verify
@@ -259,6 +363,203 @@ func FromEnv() *Config {
 		log.Fatalf("%s must be either '3' or '4', got: %s", envHashSchemaVersion, hashSchemaVersion)
 	}
 
+	var s3Cfg *s3.Config
+	switch blobStorage := os.Getenv(envBlobStorage); blobStorage {
+	case "", "fs":
+	case "s3":
+		virtualHost, _ := strconv.ParseBool(os.Getenv(envS3VirtualHost))
+		s3Cfg = &s3.Config{
+			Endpoint:    os.Getenv(envS3Endpoint),
+			Region:      os.Getenv(envS3Region),
+			Bucket:      os.Getenv(envS3Bucket),
+			AccessKey:   os.Getenv(envS3AccessKey),
+			SecretKey:   os.Getenv(envS3SecretKey),
+			Prefix:      os.Getenv(envS3Prefix),
+			VirtualHost: virtualHost,
+		}
+		if s3Cfg.Endpoint == "" || s3Cfg.Bucket == "" {
+			log.Fatalf("%s and %s are required for s3 blob storage", envS3Endpoint, envS3Bucket)
+		}
+	default:
+		log.Fatalf("%s must be either 'fs' or 's3', got: %s", envBlobStorage, blobStorage)
+	}
+
+	blobDedup, _ := strconv.ParseBool(os.Getenv(envBlobDedup))
+	if blobDedup && s3Cfg != nil {
+		log.Warnf("%s is not supported with s3, ignored", envBlobDedup)
+		blobDedup = false
+	}
+	blobCompression := os.Getenv(envBlobCompression)
+	switch blobCompression {
+	case "", "none":
+		blobCompression = ""
+	case BlobCompressionZstd:
+		if s3Cfg != nil {
+			log.Warnf("%s is not supported with s3, ignored", envBlobCompression)
+			blobCompression = ""
+		}
+	default:
+		log.Fatalf("%s must be either 'none' or 'zstd', got: %s", envBlobCompression, blobCompression)
+	}
+
+	var encryptionKey []byte
+	var encryptionOldKeys [][]byte
+	if k := os.Getenv(envEncryptionKey); k != "" {
+		encryptionKey, err = crypt.ParseKey(k)
+		if err != nil {
+			log.Fatalf("%s: %v", envEncryptionKey, err)
+		}
+		for _, k := range strings.Split(os.Getenv(envEncryptionOldKeys), ",") {
+			if strings.TrimSpace(k) == "" {
+				continue
+			}
+			old, err := crypt.ParseKey(k)
+			if err != nil {
+				log.Fatalf("%s: %v", envEncryptionOldKeys, err)
+			}
+			encryptionOldKeys = append(encryptionOldKeys, old)
+		}
+		if blobDedup {
+			log.Warnf("%s is not supported with encryption, ignored", envBlobDedup)
+			blobDedup = false
+		}
+		if s3Cfg != nil {
+			// the s3 backend would keep the blobs in plaintext
+			log.Fatalf("%s is not supported with the s3 blob storage", envEncryptionKey)
+		}
+	}
+
+	var userDBCfg *db.Config
+	switch userStorage := os.Getenv(envUserStorage); userStorage {
+	case "", "fs":
+	case db.DriverSqlite:
+		userDBCfg = &db.Config{
+			Driver: db.DriverSqlite,
+			DSN:    os.Getenv(envUserDB),
+		}
+		if userDBCfg.DSN == "" {
+			userDBCfg.DSN = filepath.Join(dataDir, "users.db")
+		}
+	case db.DriverPostgres:
+		userDBCfg = &db.Config{
+			Driver: db.DriverPostgres,
+			DSN:    os.Getenv(envUserDB),
+		}
+		if userDBCfg.DSN == "" {
+			log.Fatalf("%s is required for postgres user storage", envUserDB)
+		}
+	default:
+		log.Fatalf("%s must be either 'fs', 'sqlite' or 'postgres', got: %s", envUserStorage, userStorage)
+	}
+
+	var codeDBCfg *db.Config
+	switch codeStorage := os.Getenv(envCodeStorage); codeStorage {
+	case "", "memory":
+	case "db":
+		codeDBCfg = userDBCfg
+		if codeDBCfg == nil {
+			codeDBCfg = &db.Config{
+				Driver: db.DriverSqlite,
+				DSN:    filepath.Join(dataDir, "users.db"),
+			}
+		}
+	default:
+		log.Fatalf("%s must be either 'memory' or 'db', got: %s", envCodeStorage, codeStorage)
+	}
+
+	var oidcCfg *oidc.Config
+	if issuer := os.Getenv(envOIDCIssuer); issuer != "" {
+		autoCreate, _ := strconv.ParseBool(os.Getenv(envOIDCAutoCreate))
+		oidcCfg = &oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv(envOIDCClientID),
+			ClientSecret: os.Getenv(envOIDCClientSecret),
+			RedirectURL:  os.Getenv(envOIDCRedirectURL),
+			Scopes:       strings.Fields(os.Getenv(envOIDCScopes)),
+			UserClaim:    os.Getenv(envOIDCUserClaim),
+			GroupsClaim:  os.Getenv(envOIDCGroupsClaim),
+			AdminGroup:   os.Getenv(envOIDCAdminGroup),
+			AutoCreate:   autoCreate,
+		}
+		if oidcCfg.ClientID == "" {
+			log.Fatalf("%s is required for the OpenID Connect login", envOIDCClientID)
+		}
+		if oidcCfg.RedirectURL == "" {
+			oidcCfg.RedirectURL = strings.TrimSuffix(uploadURL, "/") + "/ui/api/oidc/callback"
+		}
+		if len(oidcCfg.Scopes) == 0 {
+			oidcCfg.Scopes = []string{"openid", "profile", "email"}
+		}
+		if oidcCfg.UserClaim == "" {
+			oidcCfg.UserClaim = "email"
+		}
+		if oidcCfg.GroupsClaim == "" {
+			oidcCfg.GroupsClaim = "groups"
+		}
+	}
+
+	var trustedProxies []string
+	if proxies := os.Getenv(envTrustedProxies); trustProxy && proxies != "" {
+		trustedProxies = strings.FieldsFunc(proxies, func(r rune) bool { return r == ',' || r == ' ' })
+		for _, p := range trustedProxies {
+			if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+				log.Fatalf("%s: %s is not an ip address or a network", envTrustedProxies, p)
+			}
+		}
+	}
+
+	lockoutAfter := DefaultLockoutAfter
+	if after := os.Getenv(envLockoutAfter); after != "" {
+		lockoutAfter, err = strconv.Atoi(after)
+		if err != nil || lockoutAfter < 0 {
+			log.Fatalf("%s must be a positive number", envLockoutAfter)
+		}
+	}
+	accountLockoutAfter := DefaultAccountLockoutAfter
+	if after := os.Getenv(envAccountLockoutAfter); after != "" {
+		accountLockoutAfter, err = strconv.Atoi(after)
+		if err != nil || accountLockoutAfter < 0 {
+			log.Fatalf("%s must be a positive number", envAccountLockoutAfter)
+		}
+	}
+	lockoutDuration := DefaultLockoutDuration
+	if duration := os.Getenv(envLockoutDuration); duration != "" {
+		lockoutDuration, err = time.ParseDuration(duration)
+		if err != nil {
+			log.Fatalf("%s cannot be parsed, eg 15m: %v", envLockoutDuration, err)
+		}
+	}
+
+	auditLog := os.Getenv(envAuditLog)
+	switch auditLog {
+	case "":
+		auditLog = filepath.Join(dataDir, DefaultAuditLog)
+	case "off":
+		auditLog = ""
+	}
+
+	var gcInterval time.Duration
+	if interval := os.Getenv(envGCInterval); interval != "" {
+		gcInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("%s cannot be parsed, eg 24h: %v", envGCInterval, err)
+		}
+	}
+	var trashRetention time.Duration
+	if retention := os.Getenv(envTrashRetention); retention != "" {
+		trashRetention, err = time.ParseDuration(retention)
+		if err != nil || trashRetention < 0 {
+			log.Fatalf("%s must be a duration, eg 720h", envTrashRetention)
+		}
+	}
+	var gcKeepHistory int
+	if keep := os.Getenv(envGCKeepHistory); keep != "" {
+		gcKeepHistory, err = strconv.Atoi(keep)
+		if err != nil || gcKeepHistory < 0 {
+			log.Fatalf("%s must be a positive number", envGCKeepHistory)
+		}
+	}
+
 	cfg := Config{
 		Port:              port,
 		StorageURL:        uploadURL,
@@ -275,9 +576,26 @@ func FromEnv() *Config {
 		HWRHost:           os.Getenv(envHwrHost),
 		HTTPSCookie:       httpsCookie,
 		TrustProxy:        trustProxy,
+		TrustedProxies:    trustedProxies,
+		LockoutAfter:      lockoutAfter,
+		AccountLockoutAfter: accountLockoutAfter,
+		LockoutDuration:   lockoutDuration,
+		MetricsToken:      os.Getenv(envMetricsToken),
+		AuditLog:          auditLog,
 		MQTTPort:          mqttPort,
 		ICEServers:        iceServers,
 		HashSchemaVersion: hashSchemaVersion,
+		S3Config:          s3Cfg,
+		BlobDedup:         blobDedup,
+		BlobCompression:   blobCompression,
+		EncryptionKey:     encryptionKey,
+		EncryptionOldKeys: encryptionOldKeys,
+		GCInterval:        gcInterval,
+		GCKeepHistory:     gcKeepHistory,
+		TrashRetention:    trashRetention,
+		UserDBConfig:      userDBCfg,
+		CodeDBConfig:      codeDBCfg,
+		OIDC:              oidcCfg,
 	}
 	return &cfg
 }
@@ -302,8 +620,49 @@ General:
 	%s	Write logs to file
 	%s Send auth cookie only via https
 	%s	Trust the proxy for X-Forwarded-For/X-Real-IP (set only if behind a proxy)
+	%s	Proxy addresses/networks, comma separated (default: any)
+	%s	Lock the account for an address after N failed logins from it, 0 never (default: %d)
+	%s	Lock the account for everyone after N failed logins from any address, 0 never (default: %d)
+	%s	How long the account stays locked (default: %s)
+	%s	Bearer token for the prometheus /metrics (default: no token)
+	%s		Audit log of the user actions, "off" to disable (default: $DATADIR/%s)
 	%s	Hash tree schema version: "3" or "4" (default: 3)
 
+Blob storage (sync15):
+	%s	Where to store the blobs: "fs" or "s3" (default: fs)
+	%s	S3 endpoint url, eg http://minio:9000
+	%s	S3 region (default: us-east-1)
+	%s	S3 bucket
+	%s	S3 access key
+	%s	S3 secret key
+	%s	Optional prefix for all keys
+	%s	Use virtual host style (bucket.host) addressing (default: path style)
+	%s	Keep one copy of the blobs shared by several users, fs only (default: false)
+	%s	Compress the blobs at rest: "none" or "zstd", fs only (default: none)
+	%s	Delete unreferenced blobs periodically, eg 24h (default: disabled)
+	%s	Keep the blobs of the last N roots (default: 0)
+	%s	Purge the trashed documents after, eg 720h (default: never)
+
+Encryption at rest:
+	%s	Master key, 32 bytes hex or base64, eg from: openssl rand -hex 32 (default: disabled)
+	%s	Previous master keys, comma separated, until the data is rewrapped with: encrypt
+
+User storage:
+	%s	Where to store the users: "fs", "sqlite" or "postgres" (default: fs)
+	%s		Sqlite file (default: $DATADIR/users.db) or postgres connection string
+	%s	Where to keep the device pairing codes: "memory" or "db" (default: memory)
+
+OpenID Connect login (web UI):
+	%s	Issuer url, enables the login, eg https://auth.example.com/realms/home
+	%s	Client id
+	%s	Client secret (optional, PKCE is always used)
+	%s	Redirect url (default: $STORAGE_URL/ui/api/oidc/callback)
+	%s	Scopes (default: openid profile email)
+	%s	Claim matched with the user id (default: email)
+	%s	Claim with the groups (default: groups)
+	%s	Members of this group are admins (default: the admin flag of the user)
+	%s	Create unknown users (default: false)
+
 MQTT (for screenshare):
 	%s	MQTT TCP port (default: 8883)
 	%s	ICE servers for WebRTC (JSON array format)
@@ -339,8 +698,49 @@ myScript hwr (needs a developer account):
 		EnvLogFile,
 		envHTTPSCookie,
 		envTrustProxy,
+		envTrustedProxies,
+		envLockoutAfter,
+		DefaultLockoutAfter,
+		envAccountLockoutAfter,
+		DefaultAccountLockoutAfter,
+		envLockoutDuration,
+		DefaultLockoutDuration,
+		envMetricsToken,
+		envAuditLog,
+		DefaultAuditLog,
 		envHashSchemaVersion,
 
+		envBlobStorage,
+		envS3Endpoint,
+		envS3Region,
+		envS3Bucket,
+		envS3AccessKey,
+		envS3SecretKey,
+		envS3Prefix,
+		envS3VirtualHost,
+		envBlobDedup,
+		envBlobCompression,
+		envGCInterval,
+		envGCKeepHistory,
+		envTrashRetention,
+
+		envEncryptionKey,
+		envEncryptionOldKeys,
+
+		envUserStorage,
+		envUserDB,
+		envCodeStorage,
+
+		envOIDCIssuer,
+		envOIDCClientID,
+		envOIDCClientSecret,
+		envOIDCRedirectURL,
+		envOIDCScopes,
+		envOIDCUserClaim,
+		envOIDCGroupsClaim,
+		envOIDCAdminGroup,
+		envOIDCAutoCreate,
+
 		envMQTTPort,
 		envICEServers,
 
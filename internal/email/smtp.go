@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ddvk/rmfakecloud/internal/metrics"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -110,6 +111,9 @@ func utf8encode(s string) string {
 
 // Send sends the email
 func (b *Builder) Send(cfg *SMTPConfig) (err error) {
+	defer func() {
+		metrics.EmailSends.WithLabelValues(metrics.Result(err)).Inc()
+	}()
 	if cfg == nil {
 		return errors.New("no smtp config")
 	}
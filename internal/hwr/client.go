@@ -11,6 +11,7 @@ import (
 	"net/http"
 
 	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
 )
 
 const (
@@ -48,6 +49,9 @@ func DoLangOverride(originalData []byte, overrideLang string) ([]byte, error) {
 
 // SendRequest sends the request
 func (hwr *HWRClient) SendRequest(data []byte) (body []byte, err error) {
+	defer func() {
+		metrics.HWRCalls.WithLabelValues(metrics.Result(err)).Inc()
+	}()
 	if hwr.Cfg.HWRLangOverride != "" {
 		overrideLang := hwr.Cfg.HWRLangOverride
 		modifiedData, err := DoLangOverride(data, overrideLang)
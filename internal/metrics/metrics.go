@@ -0,0 +1,170 @@
+// Package metrics exposes the server metrics in the prometheus format
+package metrics
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "rmfakecloud"
+
+// results of the external calls
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// Registry holds all the metrics of the server
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// RootUpdates sync15 root index changes
+	RootUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_root_updates_total",
+		Help:      "Sync 1.5 root index updates.",
+	})
+	// GenerationConflicts root updates rejected because another device was faster
+	GenerationConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_generation_conflicts_total",
+		Help:      "Sync 1.5 root updates with a wrong generation.",
+	})
+	// BlobBytesRead bytes of sync15 blobs sent
+	BlobBytesRead = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_read_bytes_total",
+		Help:      "Bytes of sync 1.5 blobs read.",
+	})
+	// BlobBytesWritten bytes of sync15 blobs stored
+	BlobBytesWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_written_bytes_total",
+		Help:      "Bytes of sync 1.5 blobs written.",
+	})
+	// EmailSends sent emails by result
+	EmailSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "email_sends_total",
+		Help:      "Emails sent, by result.",
+	}, []string{"result"})
+	// HWRCalls handwriting recognition requests by result
+	HWRCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hwr_calls_total",
+		Help:      "Handwriting recognition requests, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		RootUpdates,
+		GenerationConflicts,
+		BlobBytesRead,
+		BlobBytesWritten,
+		EmailSends,
+		HWRCalls,
+	)
+}
+
+// Result the result label of an error
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultOK
+}
+
+// Gauge registers a value read on every scrape, eg the connected clients
+func Gauge(name, help string, value func() int) {
+	g := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, func() float64 {
+		return float64(value())
+	})
+	if err := Registry.Register(g); err != nil {
+		log.Warn("metrics: ", name, " ", err)
+	}
+}
+
+// Middleware counts the requests and their latency by route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		// the route template, not the path, to keep the ids out of the labels
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics, with a bearer token if set
+func Handler(token string) gin.HandlerFunc {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// CountingReader counts the bytes read into a counter
+type CountingReader struct {
+	io.ReadCloser
+	Counter prometheus.Counter
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.Counter.Add(float64(n))
+	return n, err
+}
+
+type countingReadSeeker struct {
+	*CountingReader
+	io.Seeker
+}
+
+// CountReads wraps the reader in a CountingReader, it stays seekable if it was
+func CountReads(r io.ReadCloser, counter prometheus.Counter) io.ReadCloser {
+	cr := &CountingReader{ReadCloser: r, Counter: counter}
+	if seeker, ok := r.(io.Seeker); ok {
+		return &countingReadSeeker{cr, seeker}
+	}
+	return cr
+}
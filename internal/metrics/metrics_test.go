@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/metrics", Handler("secret"))
+	router.GET("/doc/:id", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	Gauge("test_clients", "Test clients.", func() int { return 3 })
+
+	for _, id := range []string{"1", "2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/doc/"+id, nil))
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `rmfakecloud_http_requests_total{code="204",method="GET",route="/doc/:id"} 2`)
+	assert.Contains(t, body, `rmfakecloud_test_clients 3`)
+}
+
+func TestCountingReader(t *testing.T) {
+	before := testutil.ToFloat64(BlobBytesRead)
+	r := &CountingReader{ReadCloser: io.NopCloser(strings.NewReader("hello")), Counter: BlobBytesRead}
+	_, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, before+5, testutil.ToFloat64(BlobBytesRead))
+}
+
+func TestCountReadsSeekable(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("hello")
+	f.Seek(0, io.SeekStart)
+
+	r := CountReads(f, BlobBytesRead)
+	defer r.Close()
+	_, ok := r.(io.ReadSeekCloser)
+	assert.True(t, ok, "the pdf export seeks")
+	_, ok = CountReads(io.NopCloser(strings.NewReader("hello")), BlobBytesRead).(io.Seeker)
+	assert.False(t, ok)
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// access token scopes
+const (
+	// TokenScopeRead only GET requests
+	TokenScopeRead = "read"
+	// TokenScopeUpload only document uploads
+	TokenScopeUpload = "upload"
+	// TokenScopeAdmin everything the user can do
+	TokenScopeAdmin = "admin"
+)
+
+const accessTokenPrefix = "rmfc."
+
+// AccessToken a personal access token, only the hash of the secret is kept
+type AccessToken struct {
+	ID        string
+	Name      string
+	Scope     string
+	Hash      string
+	CreatedAt time.Time
+	// Device the token is bound to a paired device and rejected once it is revoked, empty for the user
+	Device string `yaml:",omitempty"`
+}
+
+// IsTokenScope checks if the scope exists
+func IsTokenScope(scope string) bool {
+	return scope == TokenScopeRead || scope == TokenScopeUpload || scope == TokenScopeAdmin
+}
+
+// IsAccessToken checks if the bearer token is an access token (not a jwt)
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, accessTokenPrefix)
+}
+
+// AccessTokenUser the user id in the access token
+func AccessTokenUser(token string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(token, accessTokenPrefix), ".")
+	if !IsAccessToken(token) || len(parts) != 2 {
+		return "", fmt.Errorf("wrong access token format")
+	}
+	uid, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("wrong access token format")
+	}
+	return string(uid), nil
+}
+
+func hashAccessToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// NewAccessToken adds a token to the user, bound to the device if not empty,
+// the returned token string can't be recovered later
+func (u *User) NewAccessToken(name, scope, device string) (*AccessToken, string, error) {
+	if !IsTokenScope(scope) {
+		return nil, "", fmt.Errorf("unknown scope: %s", scope)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	token := accessTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(u.ID)) + "." +
+		base64.RawURLEncoding.EncodeToString(secret)
+
+	t := AccessToken{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Scope:     scope,
+		Hash:      hashAccessToken(token),
+		CreatedAt: time.Now(),
+		Device:    device,
+	}
+	u.AccessTokens = append(u.AccessTokens, t)
+	return &t, token, nil
+}
+
+// CheckAccessToken the access token of the user matching the token string, nil if none
+func (u *User) CheckAccessToken(token string) *AccessToken {
+	hash := []byte(hashAccessToken(token))
+	for i := range u.AccessTokens {
+		if subtle.ConstantTimeCompare(hash, []byte(u.AccessTokens[i].Hash)) == 1 {
+			return &u.AccessTokens[i]
+		}
+	}
+	return nil
+}
+
+// RemoveAccessToken revokes a token, false if not found
+func (u *User) RemoveAccessToken(id string) bool {
+	for i, t := range u.AccessTokens {
+		if t.ID == id {
+			u.AccessTokens = append(u.AccessTokens[:i], u.AccessTokens[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Device a paired tablet or app
+type Device struct {
+	ID string
+	// Description sent by the device when pairing, eg remarkable
+	Description string
+	// Name set by the user
+	Name     string
+	PairedAt time.Time
+	// LastSeen the last token renewal
+	LastSeen time.Time
+	LastIP   string
+	LastSync time.Time
+	// Revoked the tokens of the device are rejected
+	Revoked bool
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// totp parameters, the defaults of the authenticator apps (RFC 6238)
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew accepted steps before and after the current one (clock drift)
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+// ErrorTOTPEnabled the second factor is already set up
+var ErrorTOTPEnabled = errors.New("two-factor authentication already enabled")
+
+// ErrorWrongCode the one time code is not valid
+var ErrorWrongCode = errors.New("wrong code")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPURI the otpauth provisioning uri, shown as a qr code by the authenticator setup
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// totpCode the code of a time step
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// NewTOTPSecret starts the enrolment, the second factor is enabled once a code is confirmed
+func (u *User) NewTOTPSecret() (string, error) {
+	if u.TOTPEnabled {
+		return "", ErrorTOTPEnabled
+	}
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	u.TOTPSecret = totpEncoding.EncodeToString(key)
+	u.TOTPLastStep = 0
+	return u.TOTPSecret, nil
+}
+
+// checkTOTP validates a code of the authenticator, a code can't be used twice
+func (u *User) checkTOTP(code string, now time.Time) bool {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(u.TOTPSecret))
+	if err != nil || u.TOTPSecret == "" || len(code) != totpDigits {
+		return false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= u.TOTPLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			u.TOTPLastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// EnableTOTP confirms the enrolment with a code and returns new recovery codes
+func (u *User) EnableTOTP(code string, now time.Time) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, ErrorTOTPEnabled
+	}
+	if !u.checkTOTP(normalizeCode(code), now) {
+		return nil, ErrorWrongCode
+	}
+	codes, err := u.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	u.TOTPEnabled = true
+	return codes, nil
+}
+
+// NewRecoveryCodes replaces the recovery codes, only the hashes are kept
+func (u *User) NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	u.RecoveryCodes = hashes
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+// normalizeCode removes the separators and spaces users type
+func normalizeCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// CheckSecondFactor validates a code of the authenticator or uses up a recovery code
+func (u *User) CheckSecondFactor(code string, now time.Time) bool {
+	if !u.TOTPEnabled {
+		return false
+	}
+	code = normalizeCode(code)
+	if u.checkTOTP(code, now) {
+		return true
+	}
+	hash := []byte(hashRecoveryCode(code))
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare(hash, []byte(h)) == 1 {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ResetTOTP disables the second factor
+func (u *User) ResetTOTP() {
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = nil
+}
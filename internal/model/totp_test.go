@@ -0,0 +1,45 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vector, truncated to 6 digits
+	assert.Equal(t, "287082", totpCode([]byte("12345678901234567890"), 59/totpPeriod))
+	assert.Equal(t, "005924", totpCode([]byte("12345678901234567890"), 1234567890/totpPeriod))
+}
+
+func TestSecondFactor(t *testing.T) {
+	u := &User{ID: "test"}
+	now := time.Unix(1700000000, 0)
+	secret, err := u.NewTOTPSecret()
+	assert.NoError(t, err)
+	key, _ := totpEncoding.DecodeString(secret)
+
+	_, err = u.EnableTOTP("000000", now)
+	assert.ErrorIs(t, err, ErrorWrongCode)
+	assert.False(t, u.TOTPEnabled)
+
+	step := now.Unix() / totpPeriod
+	codes, err := u.EnableTOTP(totpCode(key, step), now)
+	assert.NoError(t, err)
+	assert.True(t, u.TOTPEnabled)
+	assert.Len(t, codes, recoveryCodeCount)
+	_, err = u.NewTOTPSecret()
+	assert.ErrorIs(t, err, ErrorTOTPEnabled)
+
+	assert.False(t, u.CheckSecondFactor(totpCode(key, step), now), "replayed code")
+	assert.True(t, u.CheckSecondFactor(totpCode(key, step+1), now), "clock drift")
+	assert.False(t, u.CheckSecondFactor(totpCode(key, step+3), now))
+
+	assert.True(t, u.CheckSecondFactor(codes[0], now))
+	assert.False(t, u.CheckSecondFactor(codes[0], now), "recovery codes are used once")
+	assert.Len(t, u.RecoveryCodes, recoveryCodeCount-1)
+
+	u.ResetTOTP()
+	assert.False(t, u.CheckSecondFactor(codes[1], now))
+}
@@ -55,6 +55,16 @@ type User struct {
 	AdditionalScopes []string
 	// Integrations stores the list of "Integrations" as shown on the tablet.
 	Integrations []IntegrationConfig
+	// Quota the maximum storage in bytes, 0 is unlimited.
+	Quota int64
+	// AccessTokens the personal access tokens for scripts, hashed.
+	AccessTokens []AccessToken `yaml:"accesstokens,omitempty"`
+	// TOTPSecret the base32 secret of the authenticator app, used once TOTPEnabled.
+	TOTPSecret   string `yaml:"totpsecret,omitempty"`
+	TOTPEnabled  bool   `yaml:"totpenabled,omitempty"`
+	TOTPLastStep int64  `yaml:"totplaststep,omitempty"`
+	// RecoveryCodes hashed one time codes for a lost authenticator.
+	RecoveryCodes []string `yaml:"recoverycodes,omitempty"`
 }
 
 // IntegrationConfig config for various integrations
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/listeners"
@@ -162,6 +163,14 @@ func (rm *RoomManager) RoomExists(roomName string) bool {
 	return exists
 }
 
+// RoomCount the open screenshare rooms
+func (rm *RoomManager) RoomCount() int {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	return len(rm.rooms)
+}
+
 func NewBroker(port string, tlsConfig *tls.Config, validateToken func(token string) (string, error), iceServers []interface{}) *Broker {
 	roomManager := NewRoomManager()
 	return &Broker{
@@ -301,6 +310,19 @@ func (b *Broker) Start() error {
 	return nil
 }
 
+// Sessions the connected clients
+func (b *Broker) Sessions() int {
+	if b.server == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&b.server.Info.ClientsConnected))
+}
+
+// Rooms the open screenshare rooms
+func (b *Broker) Rooms() int {
+	return b.authHook.roomManager.RoomCount()
+}
+
 func (b *Broker) Stop() error {
 	if b.server != nil {
 		return b.server.Close()
@@ -0,0 +1,160 @@
+// Package oidc the OpenID Connect login of the web UI (authorization code with PKCE)
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const emailClaim = "email"
+
+// Config the identity provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UserClaim the claim matched with the user id, the email must be verified
+	UserClaim string
+	// GroupsClaim the claim with the groups of the user
+	GroupsClaim string
+	// AdminGroup members are admins, empty keeps the admin flag of the user
+	AdminGroup string
+	// AutoCreate adds unknown users
+	AutoCreate bool
+}
+
+// Identity the claims of a logged in user
+type Identity struct {
+	Subject string
+	UserID  string
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+// InGroup checks the group membership
+func (i *Identity) InGroup(group string) bool {
+	return slices.Contains(i.Groups, group)
+}
+
+// Provider an OIDC relying party, the issuer is discovered on first use
+type Provider struct {
+	cfg *Config
+
+	lock     sync.Mutex
+	verifier *gooidc.IDTokenVerifier
+	oauth    *oauth2.Config
+}
+
+// New creates the provider
+func New(cfg *Config) *Provider {
+	return &Provider{cfg: cfg}
+}
+
+// GenerateVerifier a new PKCE code verifier
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+	provider, err := gooidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	scopes := p.cfg.Scopes
+	if !slices.Contains(scopes, gooidc.ScopeOpenID) {
+		scopes = append([]string{gooidc.ScopeOpenID}, scopes...)
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+// AuthCodeURL the url of the identity provider to send the browser to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange redeems the code and verifies the id token
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	oauth, idVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc code exchange: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: no id_token in the token response")
+	}
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("oidc id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("oidc: wrong nonce")
+	}
+
+	var claims map[string]any
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	identity := &Identity{
+		Subject: idToken.Subject,
+		Groups:  stringList(claims[p.cfg.GroupsClaim]),
+	}
+	identity.UserID, _ = claims[p.cfg.UserClaim].(string)
+	identity.Name, _ = claims["name"].(string)
+	if identity.UserID == "" {
+		return nil, fmt.Errorf("oidc: the id token has no %s claim", p.cfg.UserClaim)
+	}
+	// most providers let the users set any address, only a verified one identifies them
+	if verified, _ := claims["email_verified"].(bool); verified {
+		identity.Email, _ = claims["email"].(string)
+	} else if p.cfg.UserClaim == emailClaim {
+		return nil, fmt.Errorf("oidc: the email of %s is not verified", identity.UserID)
+	}
+	return identity, nil
+}
+
+// stringList a claim with a list of strings or a single string
+func stringList(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
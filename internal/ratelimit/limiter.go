@@ -0,0 +1,260 @@
+// Package ratelimit slows down password and code guessing
+package ratelimit
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaults of the backoff
+const (
+	// DefaultFreeAttempts failures before the backoff starts
+	DefaultFreeAttempts = 3
+	DefaultBaseDelay    = time.Second
+	DefaultMaxDelay     = 5 * time.Minute
+	// DefaultWindow failures older than this are forgotten
+	DefaultWindow = time.Hour
+)
+
+// kinds of keys
+const (
+	KindIP      = "ip"
+	KindAccount = "account"
+)
+
+// Config the limits
+type Config struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Window       time.Duration
+	// LockoutAfter failures lock the account for the client ip, 0 never
+	LockoutAfter int
+	// AccountLockoutAfter failures from all the ips lock the account for everyone, 0 never
+	AccountLockoutAfter int
+	LockoutDuration     time.Duration
+}
+
+// Block a blocked ip or account
+type Block struct {
+	Kind string
+	Key  string
+	// IP the client of an account block
+	IP       string
+	Failures int
+	Until    time.Time
+	// Locked the account reached LockoutAfter
+	Locked bool
+}
+
+type entry struct {
+	failures     int
+	last         time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+// Limiter counts the failures per client ip and per account and client ip,
+// both wait exponentially longer after the free attempts. A client guessing
+// the password only locks the account for itself, only the failures from
+// all the ips together lock the account for the owner too.
+// Safe for concurrent use.
+type Limiter struct {
+	cfg       Config
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter, zero values take the defaults
+func New(cfg Config) *Limiter {
+	if cfg.FreeAttempts <= 0 {
+		cfg.FreeAttempts = DefaultFreeAttempts
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = DefaultBaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultMaxDelay
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	if cfg.Window < cfg.LockoutDuration {
+		cfg.Window = cfg.LockoutDuration
+	}
+	return &Limiter{
+		cfg:     cfg,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+func entryKey(kind, key string) string {
+	return kind + ":" + key
+}
+
+// accountKey the entry of an account tried from an ip
+func accountKey(account, ip string) string {
+	return entryKey(KindAccount, account) + "\x00" + ip
+}
+
+// accountTotalKey the entry of an account tried from all the ips
+func accountTotalKey(account string) string {
+	return accountKey(account, "")
+}
+
+// keys the entries of a request, an empty ip or account is skipped
+func keys(ip, account string) []string {
+	k := make([]string, 0, 3)
+	if ip != "" {
+		k = append(k, entryKey(KindIP, ip))
+	}
+	if account != "" {
+		k = append(k, accountKey(account, ip))
+		if ip != "" {
+			k = append(k, accountTotalKey(account))
+		}
+	}
+	return k
+}
+
+// Allow checks if the ip and the account may try again, otherwise how long to wait
+func (l *Limiter) Allow(ip, account string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var wait time.Duration
+	for _, k := range keys(ip, account) {
+		if e, ok := l.entries[k]; ok && e.blockedUntil.After(now) {
+			if d := e.blockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, wait == 0
+}
+
+// Fail records a failed attempt
+func (l *Limiter) Fail(ip, account string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	for _, k := range keys(ip, account) {
+		e, ok := l.entries[k]
+		if !ok || now.Sub(e.last) > l.cfg.Window {
+			e = &entry{}
+			l.entries[k] = e
+		}
+		e.failures++
+		e.last = now
+		if ip != "" && k == accountTotalKey(account) {
+			// no backoff, the owner would wait for the others
+			if l.cfg.AccountLockoutAfter > 0 && e.failures >= l.cfg.AccountLockoutAfter {
+				e.locked = true
+				e.blockedUntil = now.Add(l.cfg.LockoutDuration)
+			}
+			continue
+		}
+		if k == accountKey(account, ip) && l.cfg.LockoutAfter > 0 && e.failures >= l.cfg.LockoutAfter {
+			e.locked = true
+			e.blockedUntil = now.Add(l.cfg.LockoutDuration)
+			continue
+		}
+		if n := e.failures - l.cfg.FreeAttempts; n > 0 {
+			e.blockedUntil = now.Add(l.delay(n))
+		}
+	}
+}
+
+// delay the exponential backoff after n failures
+func (l *Limiter) delay(n int) time.Duration {
+	d := l.cfg.BaseDelay
+	for i := 1; i < n && d < l.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.cfg.MaxDelay {
+		d = l.cfg.MaxDelay
+	}
+	return d
+}
+
+// Succeed forgets the failures of the ip and the account for the ip,
+// the failures from all the ips are kept until they expire
+func (l *Limiter) Succeed(ip, account string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys(ip, account) {
+		if ip != "" && k == accountTotalKey(account) {
+			continue
+		}
+		delete(l.entries, k)
+	}
+}
+
+// Unlock lifts the block of an ip or account (from all the ips), false if it wasn't blocked
+func (l *Limiter) Unlock(kind, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if kind == KindAccount {
+		found := false
+		prefix := accountKey(key, "")
+		for k := range l.entries {
+			if strings.HasPrefix(k, prefix) {
+				delete(l.entries, k)
+				found = true
+			}
+		}
+		return found
+	}
+	k := entryKey(kind, key)
+	_, ok := l.entries[k]
+	delete(l.entries, k)
+	return ok
+}
+
+// Blocked the ips and accounts which have to wait, locked accounts first
+func (l *Limiter) Blocked() []Block {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	blocks := make([]Block, 0)
+	for k, e := range l.entries {
+		if !e.blockedUntil.After(now) {
+			continue
+		}
+		kind, key, _ := strings.Cut(k, ":")
+		key, ip, _ := strings.Cut(key, "\x00")
+		blocks = append(blocks, Block{Kind: kind, Key: key, IP: ip, Failures: e.failures, Until: e.blockedUntil, Locked: e.locked})
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Locked != blocks[j].Locked {
+			return blocks[i].Locked
+		}
+		if blocks[i].Kind != blocks[j].Kind {
+			return blocks[i].Kind < blocks[j].Kind
+		}
+		if blocks[i].Key != blocks[j].Key {
+			return blocks[i].Key < blocks[j].Key
+		}
+		return blocks[i].IP < blocks[j].IP
+	})
+	return blocks
+}
+
+// sweep drops the forgotten entries, at most once per window
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.cfg.Window {
+		return
+	}
+	l.lastSweep = now
+	for k, e := range l.entries {
+		if now.Sub(e.last) > l.cfg.Window && !e.blockedUntil.After(now) {
+			delete(l.entries, k)
+		}
+	}
+}
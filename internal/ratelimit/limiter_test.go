@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(Config{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second, LockoutAfter: 6, LockoutDuration: time.Hour})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		l.Fail("1.2.3.4", "alice")
+	}
+	_, ok := l.Allow("1.2.3.4", "alice")
+	assert.True(t, ok, "free attempts")
+
+	// 1s, 2s, 4s, 4s
+	for _, want := range []time.Duration{1, 2, 4, 4} {
+		l.Fail("1.2.3.4", "")
+		wait, ok := l.Allow("1.2.3.4", "bob")
+		assert.False(t, ok)
+		assert.Equal(t, want*time.Second, wait)
+	}
+	_, ok = l.Allow("5.6.7.8", "bob")
+	assert.True(t, ok, "other ip")
+
+	// the account is locked only for the guessing ip, not for the owner
+	for i := 0; i < 6; i++ {
+		l.Fail("10.0.0.1", "alice")
+	}
+	wait, ok := l.Allow("10.0.0.1", "alice")
+	assert.False(t, ok)
+	assert.Equal(t, time.Hour, wait)
+	_, ok = l.Allow("5.6.7.8", "alice")
+	assert.True(t, ok, "other ip")
+
+	blocked := l.Blocked()
+	if assert.Len(t, blocked, 3) {
+		assert.Equal(t, Block{Kind: KindAccount, Key: "alice", IP: "10.0.0.1", Failures: 6, Until: now.Add(time.Hour), Locked: true}, blocked[0])
+		assert.Equal(t, KindIP, blocked[1].Kind)
+	}
+
+	assert.True(t, l.Unlock(KindAccount, "alice"))
+	assert.True(t, l.Unlock(KindIP, "10.0.0.1"))
+	_, ok = l.Allow("10.0.0.1", "alice")
+	assert.True(t, ok)
+
+	now = now.Add(5 * time.Second)
+	_, ok = l.Allow("1.2.3.4", "")
+	assert.True(t, ok, "the backoff expired")
+	l.Succeed("1.2.3.4", "")
+	l.Fail("1.2.3.4", "")
+	_, ok = l.Allow("1.2.3.4", "")
+	assert.True(t, ok, "a success resets the counter")
+}
+
+func TestLimiterAccountLockout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(Config{LockoutAfter: 3, AccountLockoutAfter: 20, LockoutDuration: time.Hour})
+	l.now = func() time.Time { return now }
+
+	// a few tries from each of many ips
+	for i := 0; i < 10; i++ {
+		ip := "10.0.0." + string(rune('0'+i))
+		_, ok := l.Allow(ip, "alice")
+		assert.True(t, ok)
+		l.Fail(ip, "alice")
+		l.Fail(ip, "alice")
+	}
+	wait, ok := l.Allow("5.6.7.8", "alice")
+	assert.False(t, ok, "locked for a new ip too")
+	assert.Equal(t, time.Hour, wait)
+	_, ok = l.Allow("5.6.7.8", "bob")
+	assert.True(t, ok)
+
+	blocked := l.Blocked()
+	if assert.Len(t, blocked, 1) {
+		assert.Equal(t, Block{Kind: KindAccount, Key: "alice", Failures: 20, Until: now.Add(time.Hour), Locked: true}, blocked[0])
+	}
+
+	assert.True(t, l.Unlock(KindAccount, "alice"))
+	_, ok = l.Allow("5.6.7.8", "alice")
+	assert.True(t, ok)
+}
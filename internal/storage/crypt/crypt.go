@@ -0,0 +1,427 @@
+// Package crypt encrypts the data at rest, with per user data keys wrapped by a server master key
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeySize aes-256
+const KeySize = 32
+
+const (
+	magicSize = 8
+	// HeaderSize magic, key id and nonce prefix
+	HeaderSize  = magicSize + 4 + 8
+	chunkSize   = 64 << 10
+	tagSize     = 16
+	lastChunk   = 1 << 31
+	fieldPrefix = "enc:"
+)
+
+// magic starts an encrypted file, it is followed by the id of the data key
+// and a random nonce prefix. The content is sealed in chunks of 64KiB with aes-gcm,
+// the nonce of a chunk is the prefix and its index, the last one flagged,
+// so chunks can't be reordered or the file truncated
+var magic = []byte("rmfcenc1")
+
+var (
+	// ErrorNoKey the key the data was encrypted with is not known
+	ErrorNoKey = errors.New("encryption key not found")
+	// ErrorCorrupted the data was modified or truncated
+	ErrorCorrupted = errors.New("encrypted data corrupted")
+)
+
+// ParseKey a 32 byte key, hex or base64 encoded
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the key has to be %d bytes, hex or base64 encoded", KeySize)
+}
+
+// NewKey a random key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// KeyID identifies a key without revealing it
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(append([]byte("rmfakecloud key id "), key...))
+	return binary.BigEndian.Uint32(sum[:])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap encrypts a data key with the master key
+func Wrap(master, key []byte) (string, error) {
+	gcm, err := newGCM(master)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, key, nil)), nil
+}
+
+// Unwrap decrypts a data key with the master key
+func Unwrap(master []byte, wrapped string) ([]byte, error) {
+	gcm, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, ErrorCorrupted
+	}
+	key, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrorCorrupted
+	}
+	return key, nil
+}
+
+// Keyring the data keys of a user, new data is encrypted with the current one
+type Keyring struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewKeyring a keyring encrypting with current and decrypting with all the keys
+func NewKeyring(current []byte, others ...[]byte) *Keyring {
+	k := &Keyring{current: KeyID(current), keys: map[uint32][]byte{}}
+	k.keys[k.current] = current
+	for _, o := range others {
+		k.keys[KeyID(o)] = o
+	}
+	return k
+}
+
+// Current the id and the key new data is encrypted with
+func (k *Keyring) Current() (uint32, []byte) {
+	return k.current, k.keys[k.current]
+}
+
+// Key a key by id
+func (k *Keyring) Key(id uint32) ([]byte, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// IsEncrypted if the data starts with the header of an encrypted file
+func IsEncrypted(header []byte) bool {
+	return len(header) >= HeaderSize && bytes.Equal(header[:magicSize], magic)
+}
+
+// HeaderKeyID the id of the key the file was encrypted with
+func HeaderKeyID(header []byte) uint32 {
+	return binary.BigEndian.Uint32(header[magicSize:])
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	if last {
+		index |= lastChunk
+	}
+	binary.BigEndian.PutUint32(nonce[8:], index)
+	return nonce
+}
+
+type writer struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	header []byte
+	buf    []byte
+	out    []byte
+	index  uint32
+	closed bool
+}
+
+// NewWriter encrypts everything written to w with the current key of the keyring.
+// Close writes the last chunk, it doesn't close w
+func NewWriter(w io.Writer, keys *Keyring) (io.WriteCloser, error) {
+	id, key := keys.Current()
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, HeaderSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[magicSize:], id)
+	if _, err = rand.Read(header[magicSize+4:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{
+		w:      w,
+		gcm:    gcm,
+		header: header,
+		buf:    make([]byte, 0, chunkSize),
+		out:    make([]byte, 0, chunkSize+tagSize),
+	}, nil
+}
+
+func (e *writer) seal(last bool) error {
+	if e.index&lastChunk != 0 {
+		return errors.New("encrypted file too big")
+	}
+	e.out = e.gcm.Seal(e.out[:0], chunkNonce(e.header[magicSize+4:], e.index, last), e.buf, e.header)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+func (e *writer) Write(p []byte) (n int, err error) {
+	if e.closed {
+		return 0, errors.New("write after close")
+	}
+	for len(p) > 0 {
+		// a full chunk is only sealed when more follows, the last one is sealed by Close
+		if len(e.buf) == chunkSize {
+			if err = e.seal(false); err != nil {
+				return
+			}
+		}
+		c := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return
+}
+
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+// Reader decrypts an encrypted file, it can seek
+type Reader struct {
+	r      io.ReaderAt
+	gcm    cipher.AEAD
+	header []byte
+	// the size of the ciphertext after the header
+	encSize int64
+	size    int64
+	chunks  int64
+	offset  int64
+
+	// the last decrypted chunk
+	chunk      []byte
+	chunkIndex int64
+	buf        []byte
+}
+
+// NewReader decrypts the size bytes of r, with the key of the keyring it was encrypted with
+func NewReader(r io.ReaderAt, size int64, keys *Keyring) (*Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil || !IsEncrypted(header) {
+		return nil, ErrorCorrupted
+	}
+	key, ok := keys.Key(HeaderKeyID(header))
+	if !ok {
+		return nil, ErrorNoKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	encSize := size - HeaderSize
+	chunks := encSize / (chunkSize + tagSize)
+	rest := encSize % (chunkSize + tagSize)
+	if rest > 0 {
+		chunks++
+	}
+	// there is always a last chunk, even an empty one
+	if chunks == 0 || (rest > 0 && rest < tagSize) {
+		return nil, ErrorCorrupted
+	}
+	return &Reader{
+		r:          r,
+		gcm:        gcm,
+		header:     header,
+		encSize:    encSize,
+		size:       encSize - chunks*tagSize,
+		chunks:     chunks,
+		chunkIndex: -1,
+		buf:        make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+// Size the size of the plaintext
+func (d *Reader) Size() int64 {
+	return d.size
+}
+
+func (d *Reader) loadChunk(index int64) error {
+	if index == d.chunkIndex {
+		return nil
+	}
+	start := index * (chunkSize + tagSize)
+	end := min(start+chunkSize+tagSize, d.encSize)
+	ct := d.buf[:end-start]
+	if _, err := d.r.ReadAt(ct, HeaderSize+start); err != nil && !(err == io.EOF && index == d.chunks-1) {
+		return err
+	}
+	nonce := chunkNonce(d.header[magicSize+4:], uint32(index), index == d.chunks-1)
+	chunk, err := d.gcm.Open(d.chunk[:0], nonce, ct, d.header)
+	if err != nil {
+		d.chunkIndex = -1
+		return ErrorCorrupted
+	}
+	d.chunk = chunk
+	d.chunkIndex = index
+	return nil
+}
+
+// ReadAt reads the plaintext at off
+func (d *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	for len(p) > 0 {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		index := off / chunkSize
+		if err = d.loadChunk(index); err != nil {
+			return
+		}
+		c := copy(p, d.chunk[off-index*chunkSize:])
+		p = p[c:]
+		off += int64(c)
+		n += c
+	}
+	return n, nil
+}
+
+func (d *Reader) Read(p []byte) (n int, err error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	if remaining := d.size - d.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err = d.ReadAt(p, d.offset)
+	d.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+// Seek sets the offset of the next Read
+func (d *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+// EncryptString encrypts a short value, like a password, with the current key.
+// The result is printable and starts with "enc:"
+func EncryptString(keys *Keyring, s string) (string, error) {
+	if s == "" || IsEncryptedString(s) {
+		return s, nil
+	}
+	id, key := keys.Current()
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, 4+gcm.NonceSize(), 4+gcm.NonceSize()+len(s)+tagSize)
+	binary.BigEndian.PutUint32(out, id)
+	if _, err = rand.Read(out[4:]); err != nil {
+		return "", err
+	}
+	out = gcm.Seal(out, out[4:], []byte(s), out[:4])
+	return fieldPrefix + base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// DecryptString decrypts a value of EncryptString, others are returned as they are
+func DecryptString(keys *Keyring, s string) (string, error) {
+	if !IsEncryptedString(s) {
+		return s, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s[len(fieldPrefix):])
+	if err != nil || len(data) < 4+12+tagSize {
+		return "", ErrorCorrupted
+	}
+	if keys == nil {
+		return "", ErrorNoKey
+	}
+	key, ok := keys.Key(binary.BigEndian.Uint32(data))
+	if !ok {
+		return "", ErrorNoKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	plain, err := gcm.Open(nil, data[4:4+gcm.NonceSize()], data[4+gcm.NonceSize():], data[:4])
+	if err != nil {
+		return "", ErrorCorrupted
+	}
+	return string(plain), nil
+}
+
+// IsEncryptedString if s is a value of EncryptString
+func IsEncryptedString(s string) bool {
+	return strings.HasPrefix(s, fieldPrefix)
+}
+
+// StringKeyID the id of the key s was encrypted with, 0 if it isn't
+func StringKeyID(s string) uint32 {
+	if !IsEncryptedString(s) {
+		return 0
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s[len(fieldPrefix):])
+	if err != nil || len(data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
@@ -0,0 +1,104 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encrypt(t *testing.T, keys *Keyring, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, keys)
+	assert.NoError(t, err)
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestStream(t *testing.T) {
+	key, _ := NewKey()
+	keys := NewKeyring(key)
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+		data := make([]byte, size)
+		rand.Read(data)
+		enc := encrypt(t, keys, data)
+		assert.True(t, IsEncrypted(enc))
+		assert.Equal(t, KeyID(key), HeaderKeyID(enc))
+
+		r, err := NewReader(bytes.NewReader(enc), int64(len(enc)), keys)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), r.Size())
+		out, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, data, out)
+
+		if size > 2*chunkSize {
+			part := make([]byte, 10)
+			_, err = r.ReadAt(part, chunkSize-5)
+			assert.NoError(t, err)
+			assert.Equal(t, data[chunkSize-5:chunkSize+5], part)
+		}
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	key, _ := NewKey()
+	keys := NewKeyring(key)
+	data := make([]byte, 2*chunkSize)
+	enc := encrypt(t, keys, data)
+
+	// truncated at a chunk boundary
+	truncated := enc[:HeaderSize+chunkSize+tagSize]
+	r, err := NewReader(bytes.NewReader(truncated), int64(len(truncated)), keys)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrorCorrupted)
+
+	enc[HeaderSize+5] ^= 1
+	r, _ = NewReader(bytes.NewReader(enc), int64(len(enc)), keys)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrorCorrupted)
+
+	other, _ := NewKey()
+	_, err = NewReader(bytes.NewReader(enc), int64(len(enc)), NewKeyring(other))
+	assert.ErrorIs(t, err, ErrorNoKey)
+}
+
+func TestWrapAndStrings(t *testing.T) {
+	master, _ := NewKey()
+	key, _ := NewKey()
+	wrapped, err := Wrap(master, key)
+	assert.NoError(t, err)
+	unwrapped, err := Unwrap(master, wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, key, unwrapped)
+
+	other, _ := NewKey()
+	_, err = Unwrap(other, wrapped)
+	assert.ErrorIs(t, err, ErrorCorrupted)
+
+	// rotated, the old key still decrypts
+	keys := NewKeyring(key)
+	s, err := EncryptString(keys, "secret")
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedString(s))
+	assert.Equal(t, KeyID(key), StringKeyID(s))
+	plain, err := DecryptString(NewKeyring(other, key), s)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", plain)
+
+	plain, err = DecryptString(nil, "not encrypted")
+	assert.NoError(t, err)
+	assert.Equal(t, "not encrypted", plain)
+
+	_, err = ParseKey("short")
+	assert.Error(t, err)
+	parsed, err := ParseKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	assert.NoError(t, err)
+	assert.Len(t, parsed, KeySize)
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+// CodeStore keeps the device pairing codes in the database, so they survive
+// restarts and can be used with any instance
+type CodeStore struct {
+	*DB
+	// Validity how long a code can be used
+	Validity time.Duration
+	// MaxAttempts wrong codes a client can try in the AttemptWindow
+	MaxAttempts   int
+	AttemptWindow time.Duration
+	now           func() time.Time
+}
+
+// NewCodeStore opens the code database
+func NewCodeStore(cfg *Config) (*CodeStore, error) {
+	d, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &CodeStore{
+		DB:            d,
+		Validity:      5 * time.Minute,
+		MaxAttempts:   10,
+		AttemptWindow: 15 * time.Minute,
+		now:           time.Now,
+	}, nil
+}
+
+// SaveCode stores the code of the user, replacing the previous one
+func (s *CodeStore) SaveCode(uid, code string) error {
+	expires := s.now().Add(s.Validity).Unix()
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.rebind(`DELETE FROM pairing_codes WHERE user_id = ? OR code = ?`), uid, code)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.rebind(`INSERT INTO pairing_codes (code, user_id, expires_at) VALUES (?, ?, ?)`), code, uid, expires)
+		return err
+	})
+}
+
+// ConsumeCode returns the user of the code and deletes it, the wrong
+// attempts are counted per source (client address)
+func (s *CodeStore) ConsumeCode(code, source string) (uid string, err error) {
+	now := s.now()
+	err = s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.rebind(`DELETE FROM pairing_codes WHERE expires_at <= ?`), now.Unix())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.rebind(`DELETE FROM pairing_attempts WHERE attempted_at <= ?`), now.Add(-s.AttemptWindow).Unix())
+		if err != nil {
+			return err
+		}
+
+		var attempts int
+		err = tx.QueryRow(s.rebind(`SELECT COUNT(*) FROM pairing_attempts WHERE source = ?`), source).Scan(&attempts)
+		if err != nil {
+			return err
+		}
+		if attempts >= s.MaxAttempts {
+			return storage.ErrorTooManyAttempts
+		}
+
+		err = tx.QueryRow(s.rebind(`SELECT user_id FROM pairing_codes WHERE code = ?`), code).Scan(&uid)
+		if err == sql.ErrNoRows {
+			_, err = tx.Exec(s.rebind(`INSERT INTO pairing_attempts (source, attempted_at) VALUES (?, ?)`), source, now.Unix())
+			if err != nil {
+				return err
+			}
+			// commit the attempt
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.rebind(`DELETE FROM pairing_codes WHERE code = ?`), code)
+		return err
+	})
+	if err == nil && uid == "" {
+		err = storage.ErrorCodeNotFound
+	}
+	return
+}
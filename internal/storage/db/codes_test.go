@@ -0,0 +1,59 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewCodeStore(&Config{Driver: DriverSqlite, DSN: filepath.Join(dir, "users.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.MaxAttempts = 3
+
+	assert.NoError(t, s.SaveCode("test", "abcdefgh"))
+	// a new code replaces the old one
+	assert.NoError(t, s.SaveCode("test", "hgfedcba"))
+	_, err = s.ConsumeCode("abcdefgh", "client")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+
+	uid, err := s.ConsumeCode("hgfedcba", "client")
+	assert.NoError(t, err)
+	assert.Equal(t, "test", uid)
+	_, err = s.ConsumeCode("hgfedcba", "client")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+
+	// expired
+	assert.NoError(t, s.SaveCode("test", "abcdefgh"))
+	now = now.Add(s.Validity)
+	_, err = s.ConsumeCode("abcdefgh", "other")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+
+	// the third wrong code, then even the right one is refused
+	_, err = s.ConsumeCode("wrong", "client")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+	assert.NoError(t, s.SaveCode("test", "abcdefgh"))
+	_, err = s.ConsumeCode("abcdefgh", "client")
+	assert.ErrorIs(t, err, storage.ErrorTooManyAttempts)
+	uid, err = s.ConsumeCode("abcdefgh", "another")
+	assert.NoError(t, err)
+	assert.Equal(t, "test", uid)
+
+	now = now.Add(s.AttemptWindow)
+	_, err = s.ConsumeCode("abcdefgh", "client")
+	assert.ErrorIs(t, err, storage.ErrorCodeNotFound)
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	// database drivers
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// supported drivers
+const (
+	DriverSqlite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Config database configuration
+type Config struct {
+	// Driver sqlite or postgres
+	Driver string
+	// DSN the sqlite file or the postgres connection string
+	DSN string
+}
+
+// DB a database connection with the schema up to date
+type DB struct {
+	*sql.DB
+	driver string
+}
+
+// migrations the schema changes, applied in order and recorded in schema_version
+var migrations = []string{
+	`CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		email TEXT NOT NULL DEFAULT '',
+		email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+		password TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		nickname TEXT NOT NULL DEFAULT '',
+		given_name TEXT NOT NULL DEFAULT '',
+		family_name TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL DEFAULT '',
+		updated_at TEXT NOT NULL DEFAULT '',
+		is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+		sync15 BOOLEAN NOT NULL DEFAULT FALSE,
+		quota BIGINT NOT NULL DEFAULT 0
+	);
+	CREATE TABLE user_scopes (
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		scope TEXT NOT NULL,
+		PRIMARY KEY (user_id, position)
+	);
+	CREATE TABLE integrations (
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		id TEXT NOT NULL,
+		provider TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		username TEXT NOT NULL DEFAULT '',
+		password TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL DEFAULT '',
+		active_transfers BOOLEAN NOT NULL DEFAULT FALSE,
+		insecure BOOLEAN NOT NULL DEFAULT FALSE,
+		accesstoken TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		endpoint TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (user_id, position)
+	)`,
+	`CREATE TABLE pairing_codes (
+		code TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL UNIQUE,
+		expires_at BIGINT NOT NULL
+	);
+	CREATE TABLE pairing_attempts (
+		source TEXT NOT NULL,
+		attempted_at BIGINT NOT NULL
+	);
+	CREATE INDEX pairing_attempts_source ON pairing_attempts (source, attempted_at)`,
+	`CREATE TABLE devices (
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		id TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		paired_at TEXT NOT NULL DEFAULT '',
+		last_seen TEXT NOT NULL DEFAULT '',
+		last_ip TEXT NOT NULL DEFAULT '',
+		last_sync TEXT NOT NULL DEFAULT '',
+		revoked BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (user_id, id)
+	)`,
+	`CREATE TABLE access_tokens (
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		id TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		scope TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		created_at TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (user_id, position)
+	)`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+	CREATE TABLE recovery_codes (
+		user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		PRIMARY KEY (user_id, position)
+	)`,
+	`ALTER TABLE access_tokens ADD COLUMN device TEXT NOT NULL DEFAULT ''`,
+}
+
+// Open opens the database and brings the schema up to date
+func Open(cfg *Config) (*DB, error) {
+	dsn := cfg.DSN
+	switch cfg.Driver {
+	case DriverSqlite:
+		// one writer at a time, wait instead of failing with SQLITE_BUSY
+		if strings.Contains(dsn, "?") {
+			dsn += "&"
+		} else {
+			dsn += "?"
+		}
+		dsn += "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	case DriverPostgres:
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
+	}
+
+	sqlDB, err := sql.Open(cfg.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Driver == DriverSqlite {
+		sqlDB.SetMaxOpenConns(1)
+	}
+	d := &DB{DB: sqlDB, driver: cfg.Driver}
+	if err = d.migrate(); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// rebind replaces the ? placeholders with $n for postgres
+func (d *DB) rebind(query string) string {
+	if d.driver != DriverPostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func (d *DB) migrate() error {
+	_, err := d.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = d.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		log.Infof("database: migrating to version %d", i+1)
+		err = d.inTx(func(tx *sql.Tx) error {
+			for _, stmt := range strings.Split(migrations[i], ";") {
+				if strings.TrimSpace(stmt) == "" {
+					continue
+				}
+				if _, err := tx.Exec(stmt); err != nil {
+					return fmt.Errorf("migration %d: %w", i+1, err)
+				}
+			}
+			_, err := tx.Exec(d.rebind(`INSERT INTO schema_version (version) VALUES (?)`), i+1)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx runs f in a transaction
+func (d *DB) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+const deviceColumns = `id, description, name, paired_at, last_seen, last_ip, last_sync, revoked`
+
+func scanDevice(row scanner) (*model.Device, error) {
+	d := &model.Device{}
+	var pairedAt, lastSeen, lastSync string
+	err := row.Scan(&d.ID, &d.Description, &d.Name, &pairedAt, &lastSeen, &d.LastIP, &lastSync, &d.Revoked)
+	if err != nil {
+		return nil, err
+	}
+	d.PairedAt = parseTime(pairedAt)
+	d.LastSeen = parseTime(lastSeen)
+	d.LastSync = parseTime(lastSync)
+	return d, nil
+}
+
+// GetDevices lists the devices of the user
+func (s *UserStore) GetDevices(uid string) ([]*model.Device, error) {
+	rows, err := s.Query(s.rebind(`SELECT `+deviceColumns+` FROM devices WHERE user_id = ? ORDER BY paired_at, id`), uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	devices := make([]*model.Device, 0)
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// GetDevice gets a device of the user
+func (s *UserStore) GetDevice(uid, deviceID string) (*model.Device, error) {
+	d, err := scanDevice(s.QueryRow(s.rebind(`SELECT `+deviceColumns+` FROM devices WHERE user_id = ? AND id = ?`), uid, deviceID))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrorDeviceNotFound
+	}
+	return d, err
+}
+
+// UpdateDevice adds or replaces a device of the user
+func (s *UserStore) UpdateDevice(uid string, d *model.Device) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return s.writeDevice(tx, uid, d)
+	})
+}
+
+// ChangeDevice changes a device of the user in a transaction, the row is locked on postgres,
+// sqlite has a single connection
+func (s *UserStore) ChangeDevice(uid, deviceID string, create bool, change func(*model.Device)) (*model.Device, error) {
+	query := `SELECT ` + deviceColumns + ` FROM devices WHERE user_id = ? AND id = ?`
+	if s.driver == DriverPostgres {
+		query += ` FOR UPDATE`
+	}
+	var device *model.Device
+	err := s.inTx(func(tx *sql.Tx) error {
+		d, err := scanDevice(tx.QueryRow(s.rebind(query), uid, deviceID))
+		if err == sql.ErrNoRows {
+			if !create {
+				return storage.ErrorDeviceNotFound
+			}
+			d, err = &model.Device{ID: deviceID}, nil
+		}
+		if err != nil {
+			return err
+		}
+		change(d)
+		device = d
+		return s.writeDevice(tx, uid, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (s *UserStore) writeDevice(tx *sql.Tx, uid string, d *model.Device) error {
+	result, err := tx.Exec(s.rebind(`UPDATE devices SET description = ?, name = ?, paired_at = ?, last_seen = ?,
+		last_ip = ?, last_sync = ?, revoked = ? WHERE user_id = ? AND id = ?`),
+		d.Description, d.Name, formatTime(d.PairedAt), formatTime(d.LastSeen), d.LastIP, formatTime(d.LastSync),
+		d.Revoked, uid, d.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec(s.rebind(`INSERT INTO devices (user_id, `+deviceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		uid, d.ID, d.Description, d.Name, formatTime(d.PairedAt), formatTime(d.LastSeen), d.LastIP,
+		formatTime(d.LastSync), d.Revoked)
+	return err
+}
@@ -0,0 +1,302 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+)
+
+// ErrorNotFound the user does not exist
+var ErrorNotFound = storage.ErrorUserNotFound
+
+// ErrorExists the user is already registered
+var ErrorExists = errors.New("user already exists")
+
+// UserStore keeps the users in a sql database, implements storage.UserStorer
+type UserStore struct {
+	*DB
+}
+
+// NewUserStore opens the user database
+func NewUserStore(cfg *Config) (*UserStore, error) {
+	d, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &UserStore{DB: d}, nil
+}
+
+const userColumns = `id, email, email_verified, password, name, nickname, given_name, family_name,
+	created_at, updated_at, is_admin, sync15, quota, totp_secret, totp_enabled, totp_last_step`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+func scanUser(row scanner) (*model.User, error) {
+	u := &model.User{}
+	var createdAt, updatedAt string
+	err := row.Scan(&u.ID, &u.Email, &u.EmailVerified, &u.Password, &u.Name, &u.Nickname, &u.GivenName, &u.FamilyName,
+		&createdAt, &updatedAt, &u.IsAdmin, &u.Sync15, &u.Quota, &u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep)
+	if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = parseTime(createdAt)
+	u.UpdatedAt = parseTime(updatedAt)
+	return u, nil
+}
+
+// detailQuery selects the rows of one user, or all when uid is empty
+func (s *UserStore) detailQuery(query, uid string) (*sql.Rows, error) {
+	if uid == "" {
+		return s.Query(query + ` ORDER BY user_id, position`)
+	}
+	return s.Query(s.rebind(query+` WHERE user_id = ? ORDER BY user_id, position`), uid)
+}
+
+// loadDetails loads the scopes, integrations, access tokens and recovery codes of the users
+func (s *UserStore) loadDetails(users map[string]*model.User, onlyUID string) error {
+	rows, err := s.detailQuery(`SELECT user_id, scope FROM user_scopes`, onlyUID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid, scope string
+		if err = rows.Scan(&uid, &scope); err != nil {
+			return err
+		}
+		if u, ok := users[uid]; ok {
+			u.AdditionalScopes = append(u.AdditionalScopes, scope)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.detailQuery(`SELECT user_id, id, provider, name, username, password, address, active_transfers,
+		insecure, accesstoken, path, endpoint FROM integrations`, onlyUID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid string
+		var i model.IntegrationConfig
+		err = rows.Scan(&uid, &i.ID, &i.Provider, &i.Name, &i.Username, &i.Password, &i.Address, &i.ActiveTransfers,
+			&i.Insecure, &i.Accesstoken, &i.Path, &i.Endpoint)
+		if err != nil {
+			return err
+		}
+		if u, ok := users[uid]; ok {
+			u.Integrations = append(u.Integrations, i)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.detailQuery(`SELECT user_id, id, name, scope, hash, created_at, device FROM access_tokens`, onlyUID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid, createdAt string
+		var t model.AccessToken
+		if err = rows.Scan(&uid, &t.ID, &t.Name, &t.Scope, &t.Hash, &createdAt, &t.Device); err != nil {
+			return err
+		}
+		t.CreatedAt = parseTime(createdAt)
+		if u, ok := users[uid]; ok {
+			u.AccessTokens = append(u.AccessTokens, t)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.detailQuery(`SELECT user_id, hash FROM recovery_codes`, onlyUID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid, hash string
+		if err = rows.Scan(&uid, &hash); err != nil {
+			return err
+		}
+		if u, ok := users[uid]; ok {
+			u.RecoveryCodes = append(u.RecoveryCodes, hash)
+		}
+	}
+	return rows.Err()
+}
+
+// GetUser gets a user
+func (s *UserStore) GetUser(uid string) (*model.User, error) {
+	if uid == "" {
+		return nil, errors.New("empty user")
+	}
+	u, err := scanUser(s.QueryRow(s.rebind(`SELECT `+userColumns+` FROM users WHERE id = ?`), uid))
+	if err == sql.ErrNoRows {
+		return nil, ErrorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.loadDetails(map[string]*model.User{uid: u}, uid); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetUsers gets all the users
+func (s *UserStore) GetUsers() ([]*model.User, error) {
+	rows, err := s.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*model.User, 0)
+	byID := make(map[string]*model.User)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+		byID[u.ID] = u
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = s.loadDetails(byID, ""); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// detailTables the tables with rows per user
+var detailTables = []string{"user_scopes", "integrations", "access_tokens", "recovery_codes"}
+
+// saveDetails replaces the scopes, integrations, access tokens and recovery codes of the user
+func (s *UserStore) saveDetails(tx *sql.Tx, u *model.User) error {
+	for _, table := range detailTables {
+		if _, err := tx.Exec(s.rebind(`DELETE FROM `+table+` WHERE user_id = ?`), u.ID); err != nil {
+			return err
+		}
+	}
+	for pos, scope := range u.AdditionalScopes {
+		_, err := tx.Exec(s.rebind(`INSERT INTO user_scopes (user_id, position, scope) VALUES (?, ?, ?)`), u.ID, pos, scope)
+		if err != nil {
+			return err
+		}
+	}
+	for pos, t := range u.AccessTokens {
+		_, err := tx.Exec(s.rebind(`INSERT INTO access_tokens (user_id, position, id, name, scope, hash, created_at, device)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`), u.ID, pos, t.ID, t.Name, t.Scope, t.Hash, formatTime(t.CreatedAt), t.Device)
+		if err != nil {
+			return err
+		}
+	}
+	for pos, hash := range u.RecoveryCodes {
+		_, err := tx.Exec(s.rebind(`INSERT INTO recovery_codes (user_id, position, hash) VALUES (?, ?, ?)`), u.ID, pos, hash)
+		if err != nil {
+			return err
+		}
+	}
+	for pos, i := range u.Integrations {
+		_, err := tx.Exec(s.rebind(`INSERT INTO integrations (user_id, position, id, provider, name, username, password,
+			address, active_transfers, insecure, accesstoken, path, endpoint) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			u.ID, pos, i.ID, i.Provider, i.Name, i.Username, i.Password, i.Address, i.ActiveTransfers, i.Insecure,
+			i.Accesstoken, i.Path, i.Endpoint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func userValues(u *model.User) []any {
+	return []any{u.ID, u.Email, u.EmailVerified, u.Password, u.Name, u.Nickname, u.GivenName, u.FamilyName,
+		formatTime(u.CreatedAt), formatTime(u.UpdatedAt), u.IsAdmin, u.Sync15, u.Quota, u.TOTPSecret, u.TOTPEnabled,
+		u.TOTPLastStep}
+}
+
+const insertUser = `INSERT INTO users (` + userColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// RegisterUser adds a new user
+func (s *UserStore) RegisterUser(u *model.User) error {
+	if u.ID == "" {
+		return errors.New("empty id")
+	}
+	return s.inTx(func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRow(s.rebind(`SELECT COUNT(*) FROM users WHERE id = ?`), u.ID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrorExists
+		}
+		if _, err = tx.Exec(s.rebind(insertUser), userValues(u)...); err != nil {
+			return err
+		}
+		return s.saveDetails(tx, u)
+	})
+}
+
+// UpdateUser saves the user, it is added if it doesn't exist
+func (s *UserStore) UpdateUser(u *model.User) error {
+	if u.ID == "" {
+		return errors.New("empty id")
+	}
+	return s.inTx(func(tx *sql.Tx) error {
+		values := userValues(u)
+		result, err := tx.Exec(s.rebind(`UPDATE users SET email = ?, email_verified = ?, password = ?, name = ?,
+			nickname = ?, given_name = ?, family_name = ?, created_at = ?, updated_at = ?, is_admin = ?, sync15 = ?,
+			quota = ?, totp_secret = ?, totp_enabled = ?, totp_last_step = ? WHERE id = ?`), append(values[1:], u.ID)...)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			if _, err = tx.Exec(s.rebind(insertUser), values...); err != nil {
+				return err
+			}
+		}
+		return s.saveDetails(tx, u)
+	})
+}
+
+// RemoveUser deletes the user
+func (s *UserStore) RemoveUser(uid string) error {
+	if uid == "" {
+		return errors.New("empty id")
+	}
+	// delete the details explicitly, the cascade depends on the foreign_keys pragma
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, table := range append(detailTables, "devices") {
+			if _, err := tx.Exec(s.rebind(`DELETE FROM `+table+` WHERE user_id = ?`), uid); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(s.rebind(`DELETE FROM users WHERE id = ?`), uid)
+		return err
+	})
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{Driver: DriverSqlite, DSN: filepath.Join(dir, "users.db")}
+	s, err := NewUserStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &model.User{
+		ID:               "test",
+		Email:            "test@example.com",
+		Password:         "hash",
+		CreatedAt:        time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		IsAdmin:          true,
+		Quota:            1024,
+		AdditionalScopes: []string{"intgr", "screenshare"},
+		Integrations: []model.IntegrationConfig{
+			{ID: "1", Provider: "webdav", Name: "dav", Address: "http://dav", Insecure: true},
+			{ID: "2", Provider: "localfs", Name: "local", Path: "/tmp"},
+		},
+		TOTPSecret:    "JBSWY3DPEHPK3PXP",
+		TOTPEnabled:   true,
+		TOTPLastStep:  56666666,
+		RecoveryCodes: []string{"a", "b"},
+	}
+	_, token, err := u.NewAccessToken("script", model.TokenScopeRead, "tablet")
+	assert.NoError(t, err)
+	assert.NoError(t, s.RegisterUser(u))
+	assert.ErrorIs(t, s.RegisterUser(u), ErrorExists)
+
+	got, err := s.GetUser("test")
+	assert.NoError(t, err)
+	// stored in utc without the monotonic clock
+	assert.True(t, u.AccessTokens[0].CreatedAt.Equal(got.AccessTokens[0].CreatedAt))
+	u.AccessTokens[0].CreatedAt = got.AccessTokens[0].CreatedAt
+	assert.Equal(t, u, got)
+	assert.NotNil(t, got.CheckAccessToken(token))
+
+	_, err = s.GetUser("other")
+	assert.ErrorIs(t, err, ErrorNotFound)
+
+	// update replaces the details and adds unknown users
+	u.AdditionalScopes = nil
+	u.Integrations = u.Integrations[1:]
+	u.Sync15 = true
+	assert.NoError(t, s.UpdateUser(u))
+	assert.NoError(t, s.UpdateUser(&model.User{ID: "other"}))
+
+	users, err := s.GetUsers()
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, "other", users[0].ID)
+		assert.Equal(t, u, users[1])
+	}
+
+	// reopening doesn't run the migrations again
+	s.Close()
+	s, err = NewUserStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	assert.NoError(t, s.UpdateDevice("test", &model.Device{ID: "rm1", Description: "remarkable", LastIP: "10.0.0.2"}))
+	assert.NoError(t, s.UpdateDevice("test", &model.Device{ID: "rm1", Description: "remarkable", Revoked: true}))
+	device, err := s.GetDevice("test", "rm1")
+	assert.NoError(t, err)
+	assert.True(t, device.Revoked)
+	assert.Empty(t, device.LastIP)
+	_, err = s.GetDevice("test", "rm2")
+	assert.ErrorIs(t, err, storage.ErrorDeviceNotFound)
+
+	device, err = s.ChangeDevice("test", "rm1", false, func(d *model.Device) { d.LastIP = "10.0.0.3" })
+	assert.NoError(t, err)
+	assert.True(t, device.Revoked, "only the changed fields")
+	_, err = s.ChangeDevice("test", "rm2", false, func(d *model.Device) {})
+	assert.ErrorIs(t, err, storage.ErrorDeviceNotFound)
+	_, err = s.ChangeDevice("test", "rm2", true, func(d *model.Device) { d.Description = "desktop" })
+	assert.NoError(t, err)
+	device, err = s.GetDevice("test", "rm2")
+	assert.NoError(t, err)
+	assert.Equal(t, "desktop", device.Description)
+
+	assert.NoError(t, s.RemoveUser("test"))
+	devices, err := s.GetDevices("test")
+	assert.NoError(t, err)
+	assert.Empty(t, devices)
+	_, err = s.GetUser("test")
+	assert.ErrorIs(t, err, ErrorNotFound)
+	users, err = s.GetUsers()
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestRebind(t *testing.T) {
+	d := &DB{driver: DriverPostgres}
+	assert.Equal(t, "SELECT a FROM b WHERE c = $1 AND d = $2", d.rebind("SELECT a FROM b WHERE c = ? AND d = ?"))
+}
@@ -0,0 +1,243 @@
+package exporter
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"golang.org/x/image/vector"
+)
+
+// page image formats
+const (
+	ImageSVG = "svg"
+	ImagePNG = "png"
+)
+
+// ErrorNoSuchPage the requested page is not in the document
+var ErrorNoSuchPage = errors.New("no such page")
+
+// IsImageFormat checks if the export type is rendered per page
+func IsImageFormat(format string) bool {
+	return format == ImageSVG || format == ImagePNG
+}
+
+// ImageContentType the mime type of a rendered page
+func ImageContentType(format string) string {
+	if format == ImageSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// palette of the brush colors, the firmware 3.x colors included
+var palette = map[rm.BrushColor]color.NRGBA{
+	rm.Black: {0, 0, 0, 255},
+	rm.Grey:  {125, 125, 125, 255},
+	rm.White: {255, 255, 255, 255},
+	3:        {251, 247, 25, 255},  // yellow
+	4:        {0, 255, 0, 255},     // green
+	5:        {255, 192, 203, 255}, // pink
+	6:        {78, 105, 201, 255},  // blue
+	7:        {179, 62, 57, 255},   // red
+}
+
+type strokeStyle struct {
+	color   color.NRGBA
+	width   float64
+	opacity float64
+}
+
+// lineStyle the color, width and opacity of a line, false for erasers
+func lineStyle(line *rm.Line) (strokeStyle, bool) {
+	style := strokeStyle{
+		color:   palette[rm.Black],
+		opacity: 1,
+	}
+	if c, ok := palette[line.BrushColor]; ok {
+		style.color = c
+	}
+
+	var width float64
+	for _, p := range line.Points {
+		width += float64(p.Width)
+	}
+	if len(line.Points) > 0 {
+		width /= float64(len(line.Points))
+	}
+	style.width = width
+	if width <= 0 {
+		style.width = float64(line.BrushSize) * 2
+	}
+
+	switch line.BrushType {
+	case rm.Eraser, rm.EraseArea:
+		return style, false
+	case rm.Highlighter, rm.HighlighterV5:
+		if line.BrushColor == rm.Black {
+			style.color = palette[3]
+		}
+		style.opacity = 0.4
+		if width <= 0 {
+			style.width = 30
+		}
+	case rm.TiltPencil, rm.TiltPencilV5, rm.SharpPencil, rm.SharpPencilV5:
+		style.opacity = 0.8
+	case rm.Brush, rm.BrushV5:
+		style.opacity = 0.9
+	}
+	return style, true
+}
+
+func pageLines(page *rm.Rm) []*rm.Line {
+	lines := make([]*rm.Line, 0)
+	if page == nil {
+		return lines
+	}
+	for l := range page.Layers {
+		for i := range page.Layers[l].Lines {
+			line := &page.Layers[l].Lines[i]
+			if len(line.Points) > 0 {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// RenderSVG draws the strokes of a page as svg
+func RenderSVG(page *rm.Rm, w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		DeviceWidth, DeviceHeight, DeviceWidth, DeviceHeight)
+	fmt.Fprintf(&sb, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+	for _, line := range pageLines(page) {
+		style, ok := lineStyle(line)
+		if !ok {
+			continue
+		}
+		points := make([]string, 0, len(line.Points))
+		for _, p := range line.Points {
+			points = append(points, fmt.Sprintf("%.2f,%.2f", p.X, p.Y))
+		}
+		if len(points) == 1 {
+			points = append(points, points[0])
+		}
+		fmt.Fprintf(&sb, `<polyline fill="none" stroke="#%02x%02x%02x" stroke-width="%.2f" stroke-opacity="%.2f" stroke-linecap="round" stroke-linejoin="round" points="%s"/>`+"\n",
+			style.color.R, style.color.G, style.color.B, style.width, style.opacity, strings.Join(points, " "))
+	}
+	sb.WriteString("</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+const capSegments = 12
+
+// strokePath adds a thick line to the rasterizer as quads around the segments
+// and discs on the points, all with the same orientation so they don't cancel out
+func strokePath(z *vector.Rasterizer, pts [][2]float64, width float64) {
+	r := width / 2
+	for _, p := range pts {
+		z.MoveTo(float32(p[0]+r), float32(p[1]))
+		for i := 1; i < capSegments; i++ {
+			a := -2 * math.Pi * float64(i) / capSegments
+			z.LineTo(float32(p[0]+r*math.Cos(a)), float32(p[1]+r*math.Sin(a)))
+		}
+		z.ClosePath()
+	}
+	for i := 1; i < len(pts); i++ {
+		p, q := pts[i-1], pts[i]
+		dx, dy := q[0]-p[0], q[1]-p[1]
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*r, dx/length*r
+		z.MoveTo(float32(p[0]+nx), float32(p[1]+ny))
+		z.LineTo(float32(q[0]+nx), float32(q[1]+ny))
+		z.LineTo(float32(q[0]-nx), float32(q[1]-ny))
+		z.LineTo(float32(p[0]-nx), float32(p[1]-ny))
+		z.ClosePath()
+	}
+}
+
+// RenderPNG rasterizes the strokes of a page at the device resolution
+func RenderPNG(page *rm.Rm, w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, DeviceWidth, DeviceHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for _, line := range pageLines(page) {
+		style, ok := lineStyle(line)
+		if !ok {
+			continue
+		}
+		// rasterize only the bounding box of the line
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range line.Points {
+			minX, minY = math.Min(minX, float64(p.X)), math.Min(minY, float64(p.Y))
+			maxX, maxY = math.Max(maxX, float64(p.X)), math.Max(maxY, float64(p.Y))
+		}
+		pad := style.width/2 + 1
+		bounds := image.Rect(int(minX-pad), int(minY-pad), int(maxX+pad)+1, int(maxY+pad)+1).Intersect(img.Bounds())
+		if bounds.Empty() {
+			continue
+		}
+
+		pts := make([][2]float64, len(line.Points))
+		for i, p := range line.Points {
+			pts[i] = [2]float64{float64(p.X) - float64(bounds.Min.X), float64(p.Y) - float64(bounds.Min.Y)}
+		}
+		z := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+		strokePath(z, pts, style.width)
+
+		c := style.color
+		c.A = uint8(math.Round(style.opacity * 255))
+		z.Draw(img, bounds, image.NewUniform(c), image.Point{})
+	}
+	return png.Encode(w, img)
+}
+
+func renderPage(page *rm.Rm, format string, w io.Writer) error {
+	switch format {
+	case ImageSVG:
+		return RenderSVG(page, w)
+	case ImagePNG:
+		return RenderPNG(page, w)
+	}
+	return fmt.Errorf("unsupported image format: %s", format)
+}
+
+// RenderPages renders a page (starting from 1) of a notebook as an image,
+// or all the pages as a zip of images when page is 0
+func RenderPages(a *MyArchive, format string, page int, output io.Writer) error {
+	if !IsImageFormat(format) {
+		return fmt.Errorf("unsupported image format: %s", format)
+	}
+	if page < 0 || page > len(a.Pages) {
+		return ErrorNoSuchPage
+	}
+	if page > 0 {
+		return renderPage(a.Pages[page-1].Data, format, output)
+	}
+
+	w := zip.NewWriter(output)
+	for i, p := range a.Pages {
+		f, err := w.Create(fmt.Sprintf("%d.%s", i+1, format))
+		if err != nil {
+			return err
+		}
+		if err = renderPage(p.Data, format, f); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
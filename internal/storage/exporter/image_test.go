@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/juruen/rmapi/archive"
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPage() *rm.Rm {
+	line := rm.Line{
+		BrushType:  rm.FinelinerV5,
+		BrushColor: rm.Black,
+		BrushSize:  rm.Medium,
+		Points: []rm.Point{
+			{X: 100, Y: 100, Width: 4},
+			{X: 300, Y: 100, Width: 4},
+		},
+	}
+	eraser := rm.Line{
+		BrushType: rm.EraseArea,
+		Points:    []rm.Point{{X: 500, Y: 500}},
+	}
+	return &rm.Rm{Layers: []rm.Layer{{Lines: []rm.Line{line, eraser}}}}
+}
+
+func TestRenderPages(t *testing.T) {
+	a := &MyArchive{}
+	a.Pages = []archive.Page{{Data: testPage()}, {}}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, RenderPages(a, ImageSVG, 1, buf))
+	assert.Contains(t, buf.String(), `points="100.00,100.00 300.00,100.00"`)
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("<polyline")))
+
+	buf.Reset()
+	assert.NoError(t, RenderPages(a, ImagePNG, 1, buf))
+	img, err := png.Decode(buf)
+	if assert.NoError(t, err) {
+		r, _, _, _ := img.At(200, 100).RGBA()
+		assert.Zero(t, r, "on the line")
+		r, _, _, _ = img.At(200, 120).RGBA()
+		assert.Equal(t, uint32(0xffff), r, "background")
+	}
+
+	buf.Reset()
+	assert.NoError(t, RenderPages(a, ImagePNG, 0, buf))
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if assert.NoError(t, err) && assert.Len(t, z.File, 2) {
+		assert.Equal(t, "2.png", z.File[1].Name)
+	}
+
+	assert.ErrorIs(t, RenderPages(a, ImageSVG, 3, buf), ErrorNoSuchPage)
+}
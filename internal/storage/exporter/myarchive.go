@@ -1,9 +1,15 @@
 package exporter
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"io"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/juruen/rmapi/archive"
+	"github.com/juruen/rmapi/encoding/rm"
 	"github.com/juruen/rmapi/log"
 )
 
@@ -16,6 +22,8 @@ func init() {
 type MyArchive struct {
 	archive.Zip
 	PayloadReader io.ReadSeekCloser
+	// Texts the typed text of the v6 pages, by page index
+	Texts map[int]*TextBlock
 }
 
 func (f *MyArchive) Close() {
@@ -23,3 +31,145 @@ func (f *MyArchive) Close() {
 		f.PayloadReader.Close()
 	}
 }
+
+type contentPages struct {
+	Pages  []string `json:"pages"`
+	CPages struct {
+		Pages []struct {
+			ID      string `json:"id"`
+			Deleted struct {
+				Value int `json:"value"`
+			} `json:"deleted"`
+		} `json:"pages"`
+	} `json:"cPages"`
+}
+
+// ContentPageIDs the page ids of a .content file, formatVersion 1 (pages) or 2 (cPages)
+func ContentPageIDs(content []byte) ([]string, error) {
+	var c contentPages
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Pages) > 0 {
+		return c.Pages, nil
+	}
+	ids := make([]string, 0, len(c.CPages.Pages))
+	for _, p := range c.CPages.Pages {
+		if p.Deleted.Value == 0 {
+			ids = append(ids, p.ID)
+		}
+	}
+	return ids, nil
+}
+
+// ReadPage parses a page in the v3, v5 or v6 format
+func ReadPage(b []byte) (*rm.Rm, *TextBlock, error) {
+	if IsRmV6(b) {
+		return ReadRmV6(b)
+	}
+	page := rm.New()
+	if err := page.UnmarshalBinary(b); err != nil {
+		return nil, nil, err
+	}
+	return page, nil, nil
+}
+
+// SetPage sets the page at index, growing the pages as needed
+func (f *MyArchive) SetPage(index int, data *rm.Rm, text *TextBlock) {
+	for len(f.Pages) <= index {
+		f.Pages = append(f.Pages, archive.Page{Pagedata: "Blank"})
+	}
+	f.Pages[index].Data = data
+	if text != nil {
+		if f.Texts == nil {
+			f.Texts = make(map[int]*TextBlock)
+		}
+		f.Texts[index] = text
+	}
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Read reads a document zip, unlike archive.Zip.Read the pages can be in any format
+func (f *MyArchive) Read(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	pageFiles := make(map[string]*zip.File)
+	for _, file := range zr.File {
+		name := path.Base(file.Name)
+		ext := path.Ext(name)
+		switch ext {
+		case ".content":
+			b, err := readZipEntry(file)
+			if err != nil {
+				return err
+			}
+			if err = json.Unmarshal(b, &f.Content); err != nil {
+				return err
+			}
+			ids, err := ContentPageIDs(b)
+			if err != nil {
+				return err
+			}
+			f.Content.Pages = ids
+			f.UUID = strings.TrimSuffix(name, ext)
+		case ".pdf", ".epub":
+			if f.Payload, err = readZipEntry(file); err != nil {
+				return err
+			}
+		case ".rm":
+			pageFiles[strings.TrimSuffix(name, ext)] = file
+		}
+	}
+
+	pageCount := len(f.Content.Pages)
+	if pageCount == 0 {
+		pageCount = f.Content.PageCount
+	}
+	for i := 0; i < pageCount; i++ {
+		f.SetPage(i, nil, nil)
+	}
+	for i, id := range f.Content.Pages {
+		file, ok := pageFiles[id]
+		if !ok {
+			continue
+		}
+		delete(pageFiles, id)
+		b, err := readZipEntry(file)
+		if err != nil {
+			return err
+		}
+		data, text, err := ReadPage(b)
+		if err != nil {
+			return err
+		}
+		f.SetPage(i, data, text)
+	}
+	// old archives name the pages by index
+	for name, file := range pageFiles {
+		index, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		b, err := readZipEntry(file)
+		if err != nil {
+			return err
+		}
+		data, text, err := ReadPage(b)
+		if err != nil {
+			return err
+		}
+		f.SetPage(index, data, text)
+	}
+	return nil
+}
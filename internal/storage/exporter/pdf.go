@@ -22,6 +22,9 @@ const (
 
 var rmPageSize = creator.PageSize{445, 594}
 
+// typedTextSize the font size of the typed text in device pixels
+const typedTextSize = 32
+
 type PdfGenerator struct {
 	options   PdfGeneratorOptions
 	pdfReader *pdf.PdfReader
@@ -162,11 +165,28 @@ func (p *PdfGenerator) Generate(zip *MyArchive, output io.Writer, options PdfGen
 		//hack: wrap the page content in a context to prevent transformation matrix misalignment
 		wrapper := []string{"q", pageContentStreams, "Q", drawingOperations}
 		page.SetContentStreams(wrapper, core.NewFlateEncoder())
+
+		if text, ok := zip.Texts[i]; ok && text.Text != "" {
+			if err = p.drawText(c, text, scale); err != nil {
+				return err
+			}
+		}
 	}
 
 	return c.Write(output)
 }
 
+// drawText draws the typed text of a v6 page
+func (p *PdfGenerator) drawText(c *creator.Creator, text *TextBlock, scale float64) error {
+	para := c.NewParagraph(text.Text)
+	para.SetFontSize(typedTextSize * scale)
+	para.SetPos(text.X*scale, text.Y*scale)
+	if text.Width > 0 {
+		para.SetWidth(text.Width * scale)
+	}
+	return c.Draw(para)
+}
+
 func (p *PdfGenerator) initBackgroundPages(r io.ReadSeeker) error {
 	if r != nil {
 		pdfReader, err := pdf.NewPdfReader(r)
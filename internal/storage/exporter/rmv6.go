@@ -0,0 +1,869 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	log "github.com/sirupsen/logrus"
+)
+
+// v6 .rm files (firmware 3.x) are a sequence of tagged blocks, see
+// https://github.com/ricklupton/rmscene for the details of the format
+const (
+	rmV6Header     = "reMarkable .lines file, version=6"
+	rmV6HeaderSize = 43
+)
+
+// block types
+const (
+	rmBlockTreeNode  = 0x02
+	rmBlockGlyphItem = 0x03
+	rmBlockGroupItem = 0x04
+	rmBlockLineItem  = 0x05
+	rmBlockRootText  = 0x07
+)
+
+// scene item types
+const (
+	rmItemGlyph = 0x01
+	rmItemGroup = 0x02
+	rmItemLine  = 0x03
+)
+
+// tag types
+const (
+	rmTagByte1   = 0x1
+	rmTagByte4   = 0x4
+	rmTagByte8   = 0x8
+	rmTagLength4 = 0xC
+	rmTagID      = 0xF
+)
+
+var errRmV6Short = errors.New("rm v6: unexpected end of data")
+
+// IsRmV6 checks the header of a page
+func IsRmV6(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(rmV6Header))
+}
+
+type rmBlock struct {
+	Type    byte
+	Version byte
+	Data    []byte
+}
+
+// readRmBlocks splits a v6 page into blocks
+func readRmBlocks(b []byte) ([]rmBlock, error) {
+	if !IsRmV6(b) || len(b) < rmV6HeaderSize {
+		return nil, errors.New("not a v6 .rm file")
+	}
+	r := &rmReader{b: b, pos: rmV6HeaderSize}
+	blocks := make([]rmBlock, 0)
+	for r.remaining() > 0 {
+		length, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		// unknown, min version, current version, type
+		header, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.bytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, rmBlock{Type: header[3], Version: header[2], Data: data})
+	}
+	return blocks, nil
+}
+
+type rmReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *rmReader) remaining() int {
+	return len(r.b) - r.pos
+}
+
+func (r *rmReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, errRmV6Short
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *rmReader) uint8() (byte, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *rmReader) uint16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (r *rmReader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *rmReader) float32() (float32, error) {
+	v, err := r.uint32()
+	return math.Float32frombits(v), err
+}
+
+func (r *rmReader) float64() (float64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (r *rmReader) varuint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, errRmV6Short
+	}
+	r.pos += n
+	return v, nil
+}
+
+// tag reads a tag and checks its index and type
+func (r *rmReader) tag(index uint64, tagType byte) error {
+	v, err := r.varuint()
+	if err != nil {
+		return err
+	}
+	if v>>4 != index || byte(v&0xF) != tagType {
+		return fmt.Errorf("rm v6: expected tag %d/%x, got %d/%x", index, tagType, v>>4, v&0xF)
+	}
+	return nil
+}
+
+// peekTag checks if the next tag has the index and type
+func (r *rmReader) peekTag(index uint64, tagType byte) bool {
+	pos := r.pos
+	err := r.tag(index, tagType)
+	r.pos = pos
+	return err == nil
+}
+
+// subblock a reader limited to the tagged subblock
+func (r *rmReader) subblock(index uint64) (*rmReader, error) {
+	if err := r.tag(index, rmTagLength4); err != nil {
+		return nil, err
+	}
+	length, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.bytes(int(length))
+	if err != nil {
+		return nil, err
+	}
+	return &rmReader{b: b}, nil
+}
+
+// crdtID identifies an item of a crdt sequence: the author and a counter
+type crdtID struct {
+	Part1 byte
+	Part2 uint64
+}
+
+var (
+	// crdtEnd the start or the end of a sequence, as a left or right neighbour
+	crdtEnd = crdtID{}
+	// rmRootGroup the group of the layers
+	rmRootGroup = crdtID{0, 1}
+)
+
+func (id crdtID) less(other crdtID) bool {
+	if id.Part1 != other.Part1 {
+		return id.Part1 < other.Part1
+	}
+	return id.Part2 < other.Part2
+}
+
+// crdtItem the position of an item in a crdt sequence,
+// it goes between the items Left and Right
+type crdtItem struct {
+	ID    crdtID
+	Left  crdtID
+	Right crdtID
+}
+
+// crdtNode a node of the ordering graph, an id or the start or end of the sequence
+type crdtNode struct {
+	id   crdtID
+	edge byte
+}
+
+const (
+	crdtStartNode = 1
+	crdtEndNode   = 2
+)
+
+// sortCRDT the indexes of the items in sequence order. An item comes after its left
+// and before its right neighbour, the ties are broken by id like rmscene does
+func sortCRDT(items []crdtItem) ([]int, error) {
+	index := make(map[crdtID]int, len(items))
+	after := make(map[crdtNode][]crdtNode)
+	deps := make(map[crdtNode]int)
+	edge := func(before, next crdtNode) {
+		after[before] = append(after[before], next)
+		deps[next]++
+		if _, ok := deps[before]; !ok {
+			deps[before] = 0
+		}
+	}
+	for i, item := range items {
+		if _, ok := index[item.ID]; ok {
+			continue
+		}
+		index[item.ID] = i
+		left, right := crdtNode{id: item.Left}, crdtNode{id: item.Right}
+		if item.Left == crdtEnd {
+			left = crdtNode{edge: crdtStartNode}
+		}
+		if item.Right == crdtEnd {
+			right = crdtNode{edge: crdtEndNode}
+		}
+		self := crdtNode{id: item.ID}
+		edge(left, self)
+		edge(self, right)
+	}
+
+	ready := make([]crdtNode, 0)
+	for n, count := range deps {
+		if count == 0 {
+			ready = append(ready, n)
+		}
+	}
+	order := make([]int, 0, len(index))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i].id.less(ready[j].id) })
+		next := make([]crdtNode, 0)
+		for _, n := range ready {
+			if i, ok := index[n.id]; ok && n.edge == 0 {
+				order = append(order, i)
+			}
+			for _, m := range after[n] {
+				deps[m]--
+				if deps[m] == 0 {
+					next = append(next, m)
+				}
+			}
+		}
+		ready = next
+	}
+	if len(order) != len(index) {
+		return nil, errors.New("rm v6: the crdt sequence has a cycle")
+	}
+	return order, nil
+}
+
+// id reads a crdt id
+func (r *rmReader) id(index uint64) (id crdtID, err error) {
+	if err = r.tag(index, rmTagID); err != nil {
+		return
+	}
+	if id.Part1, err = r.uint8(); err != nil {
+		return
+	}
+	id.Part2, err = r.varuint()
+	return
+}
+
+// crdtItem reads the ids of a sequence item, starting at index
+func (r *rmReader) crdtItem(index uint64) (item crdtItem, err error) {
+	if item.ID, err = r.id(index); err != nil {
+		return
+	}
+	if item.Left, err = r.id(index + 1); err != nil {
+		return
+	}
+	item.Right, err = r.id(index + 2)
+	return
+}
+
+func (r *rmReader) int(index uint64) (uint32, error) {
+	if err := r.tag(index, rmTagByte4); err != nil {
+		return 0, err
+	}
+	return r.uint32()
+}
+
+func (r *rmReader) taggedFloat32(index uint64) (float32, error) {
+	if err := r.tag(index, rmTagByte4); err != nil {
+		return 0, err
+	}
+	return r.float32()
+}
+
+func (r *rmReader) taggedFloat64(index uint64) (float64, error) {
+	if err := r.tag(index, rmTagByte8); err != nil {
+		return 0, err
+	}
+	return r.float64()
+}
+
+func (r *rmReader) string() (string, error) {
+	length, err := r.varuint()
+	if err != nil {
+		return "", err
+	}
+	// is ascii
+	if _, err = r.uint8(); err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// TextBlock the typed text of a page, in device coordinates
+type TextBlock struct {
+	X     float64
+	Y     float64
+	Width float64
+	Text  string
+}
+
+// rmChar a character of a text, its ids are the ones of its item plus its position.
+// The deleted characters and the formatting changes are kept empty, the others can refer to them
+type rmChar struct {
+	crdtItem
+	Text string
+}
+
+// readTextItem the characters of a text item
+func readTextItem(item *rmReader) ([]rmChar, error) {
+	ids, err := item.crdtItem(2)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := item.int(5)
+	if err != nil {
+		return nil, err
+	}
+	var chars []string
+	if deleted > 0 {
+		chars = make([]string, deleted)
+	} else if item.peekTag(6, rmTagLength4) {
+		value, err := item.subblock(6)
+		if err != nil {
+			return nil, err
+		}
+		s, err := value.string()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range s {
+			chars = append(chars, string(c))
+		}
+		if s == "" {
+			// a formatting change
+			chars = []string{""}
+		}
+	}
+
+	expanded := make([]rmChar, 0, len(chars))
+	for i, c := range chars {
+		ch := rmChar{crdtItem: crdtItem{ID: ids.ID, Left: ids.Left, Right: ids.Right}, Text: c}
+		ch.ID.Part2 += uint64(i)
+		if i > 0 {
+			ch.Left = expanded[i-1].ID
+		}
+		if i < len(chars)-1 {
+			ch.Right = crdtID{ch.ID.Part1, ch.ID.Part2 + 1}
+		}
+		expanded = append(expanded, ch)
+	}
+	return expanded, nil
+}
+
+// readRootText the text of a root text block, the characters are put in the order
+// of the crdt sequence, deleted ones and formatting changes are skipped
+func readRootText(data []byte) (*TextBlock, error) {
+	r := &rmReader{b: data}
+	if _, err := r.id(1); err != nil {
+		return nil, err
+	}
+	sb, err := r.subblock(2)
+	if err != nil {
+		return nil, err
+	}
+	if sb, err = sb.subblock(1); err != nil {
+		return nil, err
+	}
+	if sb, err = sb.subblock(1); err != nil {
+		return nil, err
+	}
+	count, err := sb.varuint()
+	if err != nil {
+		return nil, err
+	}
+
+	chars := make([]rmChar, 0)
+	for i := uint64(0); i < count; i++ {
+		item, err := sb.subblock(0)
+		if err != nil {
+			return nil, err
+		}
+		c, err := readTextItem(item)
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, c...)
+	}
+	items := make([]crdtItem, len(chars))
+	for i, c := range chars {
+		items[i] = c.crdtItem
+	}
+	order, err := sortCRDT(items)
+	if err != nil {
+		return nil, err
+	}
+	var text strings.Builder
+	for _, i := range order {
+		text.WriteString(chars[i].Text)
+	}
+	block := &TextBlock{Text: text.String()}
+
+	// the formatting is not used
+	if !r.peekTag(3, rmTagLength4) {
+		return block, nil
+	}
+	pos, err := r.subblock(3)
+	if err != nil {
+		return nil, err
+	}
+	if block.X, err = pos.float64(); err != nil {
+		return nil, err
+	}
+	if block.Y, err = pos.float64(); err != nil {
+		return nil, err
+	}
+	block.X += DeviceWidth / 2
+	width, err := r.taggedFloat32(4)
+	if err != nil {
+		return nil, err
+	}
+	block.Width = float64(width)
+	return block, nil
+}
+
+// rmSceneItem the header of a scene item block, the item is in the group Parent
+type rmSceneItem struct {
+	crdtItem
+	Parent crdtID
+}
+
+// readSceneItem the header and the value of a scene item block, the value is nil if the item was deleted
+func readSceneItem(data []byte, itemType byte) (header rmSceneItem, value *rmReader, err error) {
+	r := &rmReader{b: data}
+	if header.Parent, err = r.id(1); err != nil {
+		return
+	}
+	if header.crdtItem, err = r.crdtItem(2); err != nil {
+		return
+	}
+	if _, err = r.int(5); err != nil {
+		return
+	}
+	if !r.peekTag(6, rmTagLength4) {
+		return
+	}
+	if value, err = r.subblock(6); err != nil {
+		return
+	}
+	t, err := value.uint8()
+	if err != nil {
+		return header, nil, err
+	}
+	if t != itemType {
+		return header, nil, fmt.Errorf("rm v6: expected item type %x, got %x", itemType, t)
+	}
+	return header, value, nil
+}
+
+// readTreeNode the visibility of a group, it is a last writer wins value like the label, they are last writer wins values
+func readTreeNode(data []byte) (id crdtID, visible bool, err error) {
+	r := &rmReader{b: data}
+	if id, err = r.id(1); err != nil {
+		return
+	}
+	lww, err := r.subblock(2)
+	if err != nil {
+		return
+	}
+	if _, err = lww.id(1); err != nil {
+		return
+	}
+	value, err := lww.subblock(2)
+	if err != nil {
+		return
+	}
+	if _, err = value.string(); err != nil {
+		return
+	}
+	if lww, err = r.subblock(3); err != nil {
+		return
+	}
+	if _, err = lww.id(1); err != nil {
+		return
+	}
+	if err = lww.tag(2, rmTagByte1); err != nil {
+		return
+	}
+	v, err := lww.uint8()
+	return id, v != 0, err
+}
+
+// readGroupItem places a group in its parent, child is the group
+func readGroupItem(data []byte) (header rmSceneItem, child *crdtID, err error) {
+	header, r, err := readSceneItem(data, rmItemGroup)
+	if r == nil || err != nil {
+		return header, nil, err
+	}
+	id, err := r.id(2)
+	if err != nil {
+		return header, nil, err
+	}
+	return header, &id, nil
+}
+
+// readLine a stroke, the points have the version 1 (floats) or 2 (packed) layout
+func readLine(data []byte, version byte) (crdtID, *rm.Line, error) {
+	header, r, err := readSceneItem(data, rmItemLine)
+	if r == nil || err != nil {
+		return header.Parent, nil, err
+	}
+	line, err := readLineValue(r, version)
+	return header.Parent, line, err
+}
+
+func readLineValue(r *rmReader, version byte) (*rm.Line, error) {
+	tool, err := r.int(1)
+	if err != nil {
+		return nil, err
+	}
+	color, err := r.int(2)
+	if err != nil {
+		return nil, err
+	}
+	thickness, err := r.taggedFloat64(3)
+	if err != nil {
+		return nil, err
+	}
+	// starting length
+	if _, err = r.taggedFloat32(4); err != nil {
+		return nil, err
+	}
+	pointsData, err := r.subblock(5)
+	if err != nil {
+		return nil, err
+	}
+
+	line := &rm.Line{
+		BrushType:  rm.BrushType(tool),
+		BrushColor: rm.BrushColor(color),
+		BrushSize:  rm.BrushSize(thickness),
+	}
+	for pointsData.remaining() > 0 {
+		var p rm.Point
+		if p.X, err = pointsData.float32(); err != nil {
+			return nil, err
+		}
+		if p.Y, err = pointsData.float32(); err != nil {
+			return nil, err
+		}
+		if version == 1 {
+			var v [4]float32
+			for i := range v {
+				if v[i], err = pointsData.float32(); err != nil {
+					return nil, err
+				}
+			}
+			p.Speed, p.Direction, p.Width, p.Pressure = v[0], v[1], v[2], v[3]
+		} else {
+			speed, err := pointsData.uint16()
+			if err != nil {
+				return nil, err
+			}
+			width, err := pointsData.uint16()
+			if err != nil {
+				return nil, err
+			}
+			packed, err := pointsData.bytes(2)
+			if err != nil {
+				return nil, err
+			}
+			p.Speed = float32(speed) / 4
+			p.Width = float32(width) / 4
+			p.Direction = float32(packed[0]) * 2 * math.Pi / 255
+			p.Pressure = float32(packed[1]) / 255
+		}
+		p.X += DeviceWidth / 2
+		line.Points = append(line.Points, p)
+	}
+	return line, nil
+}
+
+// readGlyphRange a highlight of the pdf text, one highlighter line per rectangle
+func readGlyphRange(data []byte) (crdtID, []rm.Line, error) {
+	header, r, err := readSceneItem(data, rmItemGlyph)
+	if r == nil || err != nil {
+		return header.Parent, nil, err
+	}
+	lines, err := readGlyphValue(r)
+	return header.Parent, lines, err
+}
+
+func readGlyphValue(r *rmReader) ([]rm.Line, error) {
+	var err error
+	if r.peekTag(2, rmTagByte4) {
+		// start
+		if _, err = r.int(2); err != nil {
+			return nil, err
+		}
+	}
+	// length
+	if _, err = r.int(3); err != nil {
+		return nil, err
+	}
+	color, err := r.int(4)
+	if err != nil {
+		return nil, err
+	}
+	// the highlighted text
+	if _, err = r.subblock(5); err != nil {
+		return nil, err
+	}
+	rects, err := r.subblock(6)
+	if err != nil {
+		return nil, err
+	}
+	count, err := rects.varuint()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]rm.Line, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var v [4]float64
+		for j := range v {
+			if v[j], err = rects.float64(); err != nil {
+				return nil, err
+			}
+		}
+		// a stroke through the middle of the rectangle
+		x, y, w, h := float32(v[0]+DeviceWidth/2), float32(v[1]+v[3]/2), float32(v[2]), float32(v[3])
+		lines = append(lines, rm.Line{
+			BrushType:  rm.HighlighterV5,
+			BrushColor: rm.BrushColor(color),
+			Points: []rm.Point{
+				{X: x, Y: y, Width: h},
+				{X: x + w, Y: y, Width: h},
+			},
+		})
+	}
+	return lines, nil
+}
+
+// rmGroupLines the lines of a group, in the order of the file
+type rmGroupLines struct {
+	Group crdtID
+	Lines []rm.Line
+}
+
+// rmSceneTree the groups of a page, the layers are the groups in the root group
+type rmSceneTree struct {
+	// Parents group -> the group it is in
+	Parents map[crdtID]crdtID
+	Hidden  map[crdtID]bool
+	// Layers the group items of the root group
+	Layers   []crdtItem
+	LayerIDs map[crdtID]crdtID
+}
+
+// layerOf the layer of a group, false if it is hidden or was removed from the tree
+func (t *rmSceneTree) layerOf(group crdtID) (crdtID, bool) {
+	for range len(t.Parents) + 1 {
+		if t.Hidden[group] {
+			return group, false
+		}
+		if group == rmRootGroup {
+			return group, true
+		}
+		parent, ok := t.Parents[group]
+		if !ok {
+			return group, false
+		}
+		if parent == rmRootGroup {
+			return group, true
+		}
+		group = parent
+	}
+	return group, false
+}
+
+// layers groups the lines by layer, in the order of the layers on the tablet.
+// The lines in hidden or removed groups are left out
+func (t *rmSceneTree) layers(groups []rmGroupLines) ([]rm.Layer, error) {
+	if len(t.Parents) == 0 {
+		// no scene tree, a single layer
+		layer := rm.Layer{}
+		for _, g := range groups {
+			layer.Lines = append(layer.Lines, g.Lines...)
+		}
+		return []rm.Layer{layer}, nil
+	}
+
+	order, err := sortCRDT(t.Layers)
+	if err != nil {
+		return nil, err
+	}
+	// the lines put in the root group itself come first
+	index := map[crdtID]int{rmRootGroup: 0}
+	layers := []rm.Layer{{}}
+	for _, i := range order {
+		group, ok := t.LayerIDs[t.Layers[i].ID]
+		if !ok || t.Hidden[group] {
+			continue
+		}
+		index[group] = len(layers)
+		layers = append(layers, rm.Layer{})
+	}
+	for _, g := range groups {
+		layer, ok := t.layerOf(g.Group)
+		if !ok {
+			continue
+		}
+		if i, ok := index[layer]; ok {
+			layers[i].Lines = append(layers[i].Lines, g.Lines...)
+		}
+	}
+	if len(layers[0].Lines) == 0 && len(layers) > 1 {
+		layers = layers[1:]
+	}
+	return layers, nil
+}
+
+// ReadRmV6 reads the strokes, highlights and typed text of a v6 page, one layer per visible layer
+// of the tablet. The blocks that are unknown or can't be read are skipped
+func ReadRmV6(b []byte) (*rm.Rm, *TextBlock, error) {
+	blocks, err := readRmBlocks(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var text *TextBlock
+	tree := &rmSceneTree{
+		Parents:  make(map[crdtID]crdtID),
+		Hidden:   make(map[crdtID]bool),
+		LayerIDs: make(map[crdtID]crdtID),
+	}
+	groups := make([]rmGroupLines, 0)
+	for _, block := range blocks {
+		switch block.Type {
+		case rmBlockTreeNode:
+			id, visible, err := readTreeNode(block.Data)
+			if err != nil {
+				log.Warn("rm v6: skipping group: ", err)
+				continue
+			}
+			tree.Hidden[id] = !visible
+		case rmBlockGroupItem:
+			header, child, err := readGroupItem(block.Data)
+			if err != nil {
+				log.Warn("rm v6: skipping group: ", err)
+				continue
+			}
+			if header.Parent == rmRootGroup {
+				tree.Layers = append(tree.Layers, header.crdtItem)
+			}
+			if child == nil {
+				continue
+			}
+			tree.Parents[*child] = header.Parent
+			if header.Parent == rmRootGroup {
+				tree.LayerIDs[header.ID] = *child
+			}
+		case rmBlockLineItem:
+			group, line, err := readLine(block.Data, block.Version)
+			if err != nil {
+				log.Warn("rm v6: skipping line: ", err)
+				continue
+			}
+			if line != nil {
+				groups = append(groups, rmGroupLines{Group: group, Lines: []rm.Line{*line}})
+			}
+		case rmBlockGlyphItem:
+			group, lines, err := readGlyphRange(block.Data)
+			if err != nil {
+				log.Warn("rm v6: skipping highlight: ", err)
+				continue
+			}
+			groups = append(groups, rmGroupLines{Group: group, Lines: lines})
+		case rmBlockRootText:
+			t, err := readRootText(block.Data)
+			if err != nil {
+				log.Warn("rm v6: skipping text: ", err)
+				continue
+			}
+			if text == nil {
+				text = t
+			} else {
+				text.Text += "\n" + t.Text
+			}
+		}
+	}
+	layers, err := tree.layers(groups)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &rm.Rm{Version: rm.V5, Layers: layers}, text, nil
+}
+
+// RmText extracts the typed text of a v6 page
+func RmText(b []byte) (string, error) {
+	blocks, err := readRmBlocks(b)
+	if err != nil {
+		return "", err
+	}
+	texts := make([]string, 0)
+	for _, block := range blocks {
+		if block.Type != rmBlockRootText {
+			continue
+		}
+		text, err := readRootText(block.Data)
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// dumpPage a readable form of a parsed page
+func dumpPage(page *rm.Rm, text *TextBlock) string {
+	var sb strings.Builder
+	for i, layer := range page.Layers {
+		fmt.Fprintf(&sb, "layer %d\n", i+1)
+		for _, line := range layer.Lines {
+			fmt.Fprintf(&sb, "line brush=%d color=%d size=%.2f\n", line.BrushType, line.BrushColor, line.BrushSize)
+			for _, p := range line.Points {
+				fmt.Fprintf(&sb, "  %.2f,%.2f width=%.2f\n", p.X, p.Y, p.Width)
+			}
+		}
+	}
+	if text != nil {
+		fmt.Fprintf(&sb, "text x=%.2f y=%.2f width=%.2f\n%q\n", text.X, text.Y, text.Width, text.Text)
+	}
+	return sb.String()
+}
+
+func TestReadRmV6Golden(t *testing.T) {
+	samples, err := filepath.Glob("testdata/*.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, samples)
+	for _, sample := range samples {
+		t.Run(filepath.Base(sample), func(t *testing.T) {
+			b, err := os.ReadFile(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.True(t, IsRmV6(b))
+			page, text, err := ReadRmV6(b)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got := dumpPage(page, text)
+
+			golden := strings.TrimSuffix(sample, ".rm") + ".golden"
+			if *update {
+				if err = os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(want), got)
+		})
+	}
+}
+
+func TestRmText(t *testing.T) {
+	b, err := os.ReadFile("testdata/v6_text.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := RmText(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "Shopping list\nmilk, bread", text)
+
+	// edited, the items are stored out of order
+	b, err = os.ReadFile("testdata/v6_layers.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err = RmText(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello big world!", text)
+
+	_, _, err = ReadRmV6(b[:len(b)-3])
+	assert.Error(t, err)
+}
+
+func TestPdfV6(t *testing.T) {
+	b, err := os.ReadFile("testdata/v6_text.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, text, err := ReadPage(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := &MyArchive{}
+	a.SetPage(0, page, text)
+
+	buf := &bytes.Buffer{}
+	if !assert.NoError(t, RenderRmapi(a, buf)) {
+		return
+	}
+	pages, err := PdfText(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, pages, 1) {
+		assert.Contains(t, pages[0], "Shopping list")
+	}
+}
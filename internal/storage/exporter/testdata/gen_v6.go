@@ -0,0 +1,317 @@
+//go:build ignore
+
+// Generates the v6 .rm samples of this directory, the goldens are written by
+// go test -update. Run from the exporter package:
+//
+//	go run testdata/gen_v6.go testdata
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type w struct{ bytes.Buffer }
+
+func (b *w) varuint(v uint64)                { b.Write(binary.AppendUvarint(nil, v)) }
+func (b *w) tag(i uint64, t byte)            { b.varuint(i<<4 | uint64(t)) }
+func (b *w) u32(v uint32)                    { binary.Write(b, binary.LittleEndian, v) }
+func (b *w) u16(v uint16)                    { binary.Write(b, binary.LittleEndian, v) }
+func (b *w) f32(v float32)                   { binary.Write(b, binary.LittleEndian, math.Float32bits(v)) }
+func (b *w) f64(v float64)                   { binary.Write(b, binary.LittleEndian, math.Float64bits(v)) }
+func (b *w) id(i uint64, p1 byte, p2 uint64) { b.tag(i, 0xF); b.WriteByte(p1); b.varuint(p2) }
+func (b *w) int(i uint64, v uint32)          { b.tag(i, 0x4); b.u32(v) }
+func (b *w) float(i uint64, v float32)       { b.tag(i, 0x4); b.f32(v) }
+func (b *w) double(i uint64, v float64)      { b.tag(i, 0x8); b.f64(v) }
+func (b *w) boolean(i uint64, v bool) {
+	b.tag(i, 0x1)
+	if v {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+}
+func (b *w) sub(i uint64, f func(*w)) {
+	s := &w{}
+	f(s)
+	b.tag(i, 0xC)
+	b.u32(uint32(s.Len()))
+	b.Write(s.Bytes())
+}
+func (b *w) str(s string) { b.varuint(uint64(len(s))); b.WriteByte(1); b.WriteString(s) }
+
+type page struct{ w }
+
+func newPage() *page {
+	p := &page{}
+	h := "reMarkable .lines file, version=6"
+	p.WriteString(h + strings.Repeat(" ", 43-len(h)))
+	return p
+}
+
+func (p *page) block(typ, minV, curV byte, f func(*w)) {
+	d := &w{}
+	f(d)
+	p.u32(uint32(d.Len()))
+	p.Write([]byte{0, minV, curV, typ})
+	p.Write(d.Bytes())
+}
+
+// the blocks the firmware writes before the scene items
+func (p *page) preamble() {
+	p.block(0x09, 1, 1, func(b *w) { // author ids
+		b.varuint(1)
+		b.sub(0, func(b *w) {
+			b.varuint(16)
+			b.Write(bytes.Repeat([]byte{0xab}, 16))
+			b.u16(1)
+		})
+	})
+	p.block(0x00, 1, 1, func(b *w) { // migration info
+		b.id(1, 1, 1)
+		b.boolean(2, true)
+	})
+	p.block(0x0A, 0, 1, func(b *w) { // page info
+		b.int(1, 1)
+		b.int(2, 0)
+		b.int(3, 0)
+		b.int(4, 0)
+	})
+	p.block(0x01, 1, 1, func(b *w) { // scene tree
+		b.id(1, 0, 11)
+		b.int(2, 0)
+		b.boolean(3, true)
+		b.sub(4, func(b *w) { b.id(1, 0, 1) })
+	})
+	p.treeNode(1, "", true)
+	p.treeNode(11, "Layer 1", true)
+	p.group(1, 13, 0, 0, 11, false)
+}
+
+// treeNode the label and visibility of the group 0:id
+func (p *page) treeNode(id uint64, label string, visible bool) {
+	p.block(0x02, 1, 1, func(b *w) {
+		b.id(1, 0, id)
+		b.sub(2, func(b *w) { b.id(1, 0, 0); b.sub(2, func(b *w) { b.str(label) }) })
+		b.sub(3, func(b *w) { b.id(1, 0, 0); b.boolean(2, visible) })
+	})
+}
+
+// group puts the group 0:child in the group 0:parent, the item is 0:id between 0:left and 0:right
+func (p *page) group(parent, id, left, right, child uint64, deleted bool) {
+	p.block(0x04, 1, 1, func(b *w) {
+		b.id(1, 0, parent)
+		b.id(2, 0, id)
+		b.id(3, 0, left)
+		b.id(4, 0, right)
+		if deleted {
+			b.int(5, 1)
+			return
+		}
+		b.int(5, 0)
+		b.sub(6, func(b *w) { b.WriteByte(0x02); b.id(2, 0, child) })
+	})
+}
+
+type pt struct {
+	x, y  float32
+	width uint16
+}
+
+func (p *page) line(id uint64, version byte, tool, color uint32, thickness float64, pts []pt, deleted bool) {
+	p.lineIn(11, id, version, tool, color, thickness, pts, deleted)
+}
+
+// lineIn a line in the group 0:group
+func (p *page) lineIn(group, id uint64, version byte, tool, color uint32, thickness float64, pts []pt, deleted bool) {
+	p.block(0x05, 1, version, func(b *w) {
+		b.id(1, 0, group)
+		b.id(2, 1, id)
+		b.id(3, 0, 0)
+		b.id(4, 0, 0)
+		if deleted {
+			b.int(5, 1)
+			return
+		}
+		b.int(5, 0)
+		b.sub(6, func(b *w) {
+			b.WriteByte(0x03)
+			b.int(1, tool)
+			b.int(2, color)
+			b.double(3, thickness)
+			b.float(4, 0)
+			b.sub(5, func(b *w) {
+				for _, q := range pts {
+					b.f32(q.x)
+					b.f32(q.y)
+					if version == 1 {
+						b.f32(1)                    // speed
+						b.f32(0)                    // direction
+						b.f32(float32(q.width) / 4) // width
+						b.f32(0.5)                  // pressure
+					} else {
+						b.u16(4)
+						b.u16(q.width)
+						b.WriteByte(0)
+						b.WriteByte(128)
+					}
+				}
+			})
+			b.id(6, 0, 1)
+		})
+	})
+}
+
+// textItem the characters of s (or deleted ones) are 1:id, 1:id+1... and go between 1:left and 1:right, 0 is the start or end
+type textItem struct {
+	id, left, right uint64
+	s               string
+	deleted         uint32
+}
+
+// textID the id of a character, 0 is 0:0
+func (b *w) textID(i uint64, id uint64) {
+	if id == 0 {
+		b.id(i, 0, 0)
+		return
+	}
+	b.id(i, 1, id)
+}
+
+func (p *page) text(items []textItem, x, y float64, width float32) {
+	p.block(0x07, 0, 1, func(b *w) {
+		b.id(1, 0, 0)
+		b.sub(2, func(b *w) {
+			b.sub(1, func(b *w) {
+				b.sub(1, func(b *w) {
+					b.varuint(uint64(len(items)))
+					for _, item := range items {
+						b.sub(0, func(b *w) {
+							b.textID(2, item.id)
+							b.textID(3, item.left)
+							b.textID(4, item.right)
+							if item.deleted > 0 {
+								b.int(5, item.deleted)
+								return
+							}
+							b.int(5, 0)
+							b.sub(6, func(b *w) { b.str(item.s) })
+						})
+					}
+				})
+			})
+			b.sub(2, func(b *w) {
+				b.sub(1, func(b *w) {
+					b.varuint(1)
+					b.id(0, 0, 0)
+					b.sub(0, func(b *w) { b.WriteByte(17); b.sub(0, func(b *w) { b.WriteByte(1) }) })
+				})
+			})
+		})
+		b.sub(3, func(b *w) { b.f64(x); b.f64(y) })
+		b.float(4, width)
+	})
+}
+
+func (p *page) glyph(color uint32, text string, rects [][4]float64) {
+	p.block(0x03, 0, 1, func(b *w) {
+		b.id(1, 0, 11)
+		b.id(2, 1, 40)
+		b.id(3, 0, 0)
+		b.id(4, 0, 0)
+		b.int(5, 0)
+		b.sub(6, func(b *w) {
+			b.WriteByte(0x01)
+			b.int(2, 10)
+			b.int(3, uint32(len(text)))
+			b.int(4, color)
+			b.sub(5, func(b *w) { b.str(text) })
+			b.sub(6, func(b *w) {
+				b.varuint(uint64(len(rects)))
+				for _, r := range rects {
+					for _, v := range r {
+						b.f64(v)
+					}
+				}
+			})
+		})
+	})
+}
+
+func main() {
+	dir := os.Args[1]
+	save := func(name string, p *page) {
+		if err := os.WriteFile(filepath.Join(dir, name), p.Bytes(), 0644); err != nil {
+			panic(err)
+		}
+	}
+
+	p := newPage()
+	p.preamble()
+	p.line(20, 2, 17, 0, 2, []pt{{-300, 200, 8}, {-200, 210, 9}, {-100, 230, 10}}, false)
+	p.line(30, 2, 14, 1, 1.5, []pt{{0, 400, 12}, {50, 450, 12}}, false)
+	p.line(31, 2, 17, 0, 2, nil, true)
+	p.line(32, 2, 6, 0, 2, []pt{{10, 10, 40}}, false)
+	save("v6_strokes.rm", p)
+
+	p = newPage()
+	p.preamble()
+	p.line(20, 1, 15, 6, 2, []pt{{-10, 100, 8}, {10, 120, 8}}, false)
+	save("v6_points_v1.rm", p)
+
+	p = newPage()
+	p.preamble()
+	p.text([]textItem{
+		{id: 16, s: "Shopping list\n"},
+		{id: 30, left: 29, deleted: 4},
+		{id: 34, left: 33, s: "milk, "},
+		{id: 40, left: 39, s: "bread"},
+	}, -468, 234, 936)
+	p.line(20, 2, 15, 7, 2, []pt{{-400, 600, 8}, {-380, 610, 8}}, false)
+	save("v6_text.rm", p)
+
+	p = newPage()
+	p.preamble()
+	p.glyph(3, "important words", [][4]float64{{-500, 300, 400, 40}, {-500, 350, 200, 40}})
+	save("v6_highlight.rm", p)
+
+	// edited text, the items are not stored in the text order. Three layers
+	// stored out of order, a hidden one and a group inside the first one
+	p = newPage()
+	p.preamble()
+	p.text([]textItem{
+		{id: 26, left: 20, right: 21, s: "big "},
+		{id: 40, left: 18, right: 19, s: "l"},
+		{id: 16, s: "Helo "},
+		{id: 31, left: 30, deleted: 1},
+		{id: 21, left: 20, s: "world"},
+		{id: 30, left: 25, s: "!"},
+	}, -468, 100, 936)
+	p.treeNode(20, "Hidden", false)
+	p.treeNode(30, "Layer 3", true)
+	p.treeNode(40, "Group", true)
+	p.group(1, 32, 22, 0, 30, false)
+	p.group(1, 22, 13, 0, 20, false)
+	p.group(11, 42, 0, 0, 40, false)
+	p.group(11, 52, 42, 0, 50, true)
+	p.lineIn(30, 20, 2, 2, 0, 2, []pt{{0, 300, 8}, {0, 310, 8}}, false)
+	p.lineIn(20, 21, 2, 2, 0, 2, []pt{{0, 400, 8}, {0, 410, 8}}, false)
+	p.lineIn(40, 22, 2, 2, 0, 2, []pt{{0, 100, 8}, {0, 110, 8}}, false)
+	p.lineIn(50, 23, 2, 2, 0, 2, []pt{{0, 500, 8}, {0, 510, 8}}, false)
+	p.lineIn(11, 24, 2, 2, 0, 2, []pt{{0, 200, 8}, {0, 210, 8}}, false)
+	save("v6_layers.rm", p)
+
+	p = newPage()
+	p.preamble()
+	p.block(0x05, 1, 2, func(b *w) { // truncated line
+		b.id(1, 0, 11)
+		b.id(2, 1, 5)
+	})
+	p.block(0x42, 0, 1, func(b *w) { b.WriteString("from a newer firmware") })
+	p.line(20, 2, 2, 0, 2, []pt{{0, 100, 8}, {0, 200, 8}}, false)
+	save("v6_unknown_blocks.rm", p)
+}
@@ -0,0 +1,150 @@
+package exporter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/extractor"
+	pdf "github.com/unidoc/unipdf/v3/model"
+)
+
+// PdfText extracts the text of each page of a pdf
+func PdfText(r io.ReadSeeker) ([]string, error) {
+	reader, err := pdf.NewPdfReader(r)
+	if err != nil {
+		return nil, err
+	}
+	numPages, err := reader.GetNumPages()
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]string, numPages)
+	for i := 0; i < numPages; i++ {
+		page, err := reader.GetPage(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		ex, err := extractor.New(page)
+		if err != nil {
+			return nil, err
+		}
+		pages[i], err = ex.ExtractText()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Manifest []struct {
+		ID   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+func readZipFile(z *zip.Reader, name string) ([]byte, error) {
+	f, err := z.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// EpubText extracts the text of each chapter of an epub, in reading order
+func EpubText(r io.ReaderAt, size int64) ([]string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readZipFile(z, "META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var container epubContainer
+	if err = xml.Unmarshal(b, &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, nil
+	}
+	opf := container.Rootfiles[0].FullPath
+	b, err = readZipFile(z, opf)
+	if err != nil {
+		return nil, err
+	}
+	var pkg epubPackage
+	if err = xml.Unmarshal(b, &pkg); err != nil {
+		return nil, err
+	}
+
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+	chapters := make([]string, 0, len(pkg.Spine))
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		b, err = readZipFile(z, path.Join(path.Dir(opf), href))
+		if err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, htmlText(b))
+	}
+	return chapters, nil
+}
+
+// htmlText the character data of a (x)html document, without the head
+func htmlText(b []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var sb strings.Builder
+	skip := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "head", "script", "style":
+				skip++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "head", "script", "style":
+				skip--
+			case "p", "div", "br", "li", "h1", "h2", "h3", "h4", "h5", "h6":
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if skip == 0 {
+				sb.Write(t)
+			}
+		}
+	}
+	return sb.String()
+}
@@ -1,19 +1,23 @@
 package fs
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"slices"
 	"strconv"
 	"time"
 
+	"github.com/ddvk/rmfakecloud/internal/audit"
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -39,6 +43,9 @@ var ErrorNotFound = errors.New("not found")
 // ErrorWrongGeneration the geration did not match
 var ErrorWrongGeneration = errors.New("wrong generation")
 
+// ErrorHashMismatch the content does not match the blob id or the checksum
+var ErrorHashMismatch = errors.New("hash mismatch")
+
 // App file system document storage
 type App struct {
 	cfg *config.Config
@@ -89,10 +96,15 @@ func (app *App) uploadDocument(c *gin.Context) {
 	}
 	id := token.DocumentID
 	log.Debug("[storage] uploading documentId: ", id)
-	body := c.Request.Body
-	defer body.Close()
-
-	err = app.fs.StoreDocument(token.UserID, id, body)
+	defer c.Request.Body.Close()
+	body, err := app.fs.QuotaReader(token.UserID, c.Request.Body, c.Request.ContentLength)
+	if err == nil {
+		err = app.fs.StoreDocument(token.UserID, id, io.NopCloser(body))
+	}
+	if err == storage.ErrorQuotaExceeded {
+		c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		log.Error(err)
 		c.AbortWithStatus(http.StatusInternalServerError)
@@ -147,7 +159,7 @@ func (app *App) downloadBlob(c *gin.Context) {
 		return
 	}
 
-	if blobID == "" {
+	if blobID == "" || (blobID != rootBlob && !isContentHash(blobID)) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
@@ -191,7 +203,7 @@ func (app *App) uploadBlob(c *gin.Context) {
 	}
 	log.Info(exp, signature)
 
-	if blobID == "" {
+	if blobID == "" || (blobID != rootBlob && !isContentHash(blobID)) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
@@ -202,8 +214,24 @@ func (app *App) uploadBlob(c *gin.Context) {
 		return
 	}
 
-	body := c.Request.Body
-	defer body.Close()
+	defer c.Request.Body.Close()
+	var body io.Reader = c.Request.Body
+	if blobID != rootBlob {
+		body, err = app.fs.QuotaReader(uid, body, c.Request.ContentLength)
+		if err == storage.ErrorQuotaExceeded {
+			c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if blobID != rootBlob {
+		body = NewVerifyingReader(body, blobID, common.CRC32CFromHashHeader(c.GetHeader(common.GCPHashHeader)))
+	}
 
 	generation := int64(0)
 	gh := c.Request.Header.Get(generationMatchHeader)
@@ -216,12 +244,36 @@ func (app *App) uploadBlob(c *gin.Context) {
 		}
 	}
 
+	var oldHash, newHash string
+	var oldGen int64
+	if blobID == rootBlob && audit.Enabled() {
+		oldHash, oldGen, _ = app.fs.BlobStorage(uid).GetRootIndex()
+		// the root is just a hash
+		b, err := io.ReadAll(io.LimitReader(body, 1024))
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		newHash = string(b)
+		body = bytes.NewReader(b)
+	}
+
 	newgen, err := app.fs.StoreBlob(uid, blobID, body, generation)
 
 	if err == ErrorWrongGeneration {
 		c.AbortWithStatus(http.StatusPreconditionFailed)
 		return
 	}
+	if err == storage.ErrorQuotaExceeded {
+		c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrorHashMismatch) {
+		log.Warn(err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	if err != nil {
 		log.Error(err)
@@ -229,10 +281,43 @@ func (app *App) uploadBlob(c *gin.Context) {
 		return
 	}
 
+	if blobID == rootBlob {
+		audit.Record(audit.Entry{
+			User:   uid,
+			Action: audit.ActionRootUpdate,
+			Source: audit.SourceDevice,
+			IP:     c.ClientIP(),
+			Details: map[string]string{
+				"oldHash":       oldHash,
+				"newHash":       newHash,
+				"oldGeneration": strconv.FormatInt(oldGen, 10),
+				"newGeneration": strconv.FormatInt(newgen, 10),
+			},
+		})
+		if audit.Enabled() {
+			app.recordRootChanges(c, uid, oldHash, newHash)
+		}
+	}
+
 	c.Header(generationHeader, strconv.FormatInt(newgen, 10))
 	c.JSON(http.StatusOK, gin.H{})
 }
 
+// recordRootChanges records the documents changed by a root update
+func (app *App) recordRootChanges(c *gin.Context, uid, oldHash, newHash string) {
+	changes, err := app.fs.RootChanges(uid, oldHash, newHash)
+	if err != nil {
+		log.Warn("audit, root changes: ", err)
+		return
+	}
+	for _, e := range changes {
+		e.User = uid
+		e.Source = audit.SourceDevice
+		e.IP = c.ClientIP()
+		audit.Record(e)
+	}
+}
+
 // SignURLParams signs url params
 func SignURLParams(parts []string, key []byte) (string, error) {
 	h := hmac.New(sha256.New, key)
@@ -0,0 +1,300 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/danjacques/gofslock/fslock"
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// BlobBackend persists the raw sync15 blobs of all users
+type BlobBackend interface {
+	// LoadBlob opens a blob, for the root blob gen is the current generation
+	LoadBlob(uid, blobID string) (reader io.ReadCloser, gen int64, size int64, crc32c string, err error)
+	// StoreBlob writes a blob, for the root blob lastGen has to match the current generation
+	StoreBlob(uid, blobID string, stream io.Reader, lastGen int64) (gen int64, err error)
+	// StatBlob returns the size of a blob or ErrorNotFound
+	StatBlob(uid, blobID string) (size int64, err error)
+	// RootHistory the root modification log, oldest first
+	RootHistory(uid string) ([]*models.RootHistory, error)
+	// ListBlobs lists all blobs of a user, except the root and its history
+	ListBlobs(uid string) ([]BlobInfo, error)
+	// DeleteBlob removes a blob
+	DeleteBlob(uid, blobID string) error
+}
+
+// BlobInfo a stored blob
+type BlobInfo struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+}
+
+// tmpBlobPrefix incomplete uploads
+const tmpBlobPrefix = ".tmp-"
+
+// localBlobs stores the blobs as flat files in users/<uid>/sync
+type localBlobs struct {
+	fs *FileSystemStorage
+}
+
+// RootHistory reads the .root.history file
+func (b *localBlobs) RootHistory(uid string) ([]*models.RootHistory, error) {
+	historyPath := path.Join(b.fs.getUserBlobPath(uid), historyFile)
+	history, err := models.ReadRootHistory(historyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return history, err
+}
+
+// ListBlobs lists the files in the sync folder
+func (b *localBlobs) ListBlobs(uid string) ([]BlobInfo, error) {
+	entries, err := os.ReadDir(b.fs.getUserBlobPath(uid))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]BlobInfo, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || name == rootBlob || name == historyFile {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			// removed in the meantime
+			continue
+		}
+		blobs = append(blobs, BlobInfo{ID: name, Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	return blobs, nil
+}
+
+// DeleteBlob removes the file
+func (b *localBlobs) DeleteBlob(uid, blobid string) error {
+	blobPath := path.Join(b.fs.getUserBlobPath(uid), common.Sanitize(blobid))
+	err := os.Remove(blobPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// StatBlob the size of a blob
+func (b *localBlobs) StatBlob(uid, blobid string) (int64, error) {
+	blobPath := path.Join(b.fs.getUserBlobPath(uid), common.Sanitize(blobid))
+	fi, err := os.Stat(blobPath)
+	if err != nil || fi.IsDir() {
+		return 0, ErrorNotFound
+	}
+	return fi.Size(), nil
+}
+
+// LoadBlob Opens a blob by id
+func (b *localBlobs) LoadBlob(uid, blobid string) (reader io.ReadCloser, gen int64, size int64, hash string, err error) {
+	generation := int64(0)
+	blobPath := path.Join(b.fs.getUserBlobPath(uid), common.Sanitize(blobid))
+	log.Debugln("Fullpath:", blobPath)
+	if blobid == rootBlob {
+		historyPath := path.Join(b.fs.getUserBlobPath(uid), historyFile)
+		lock, err := fslock.Lock(historyPath)
+		if err != nil {
+			log.Error("cannot obtain lock")
+			return nil, 0, 0, "", err
+		}
+		defer lock.Unlock()
+
+		fi, err1 := os.Stat(historyPath)
+		if err1 == nil {
+			generation = generationFromFileSize(fi.Size())
+		}
+	}
+
+	fi, err := os.Stat(blobPath)
+	if err != nil || fi.IsDir() {
+		return nil, generation, 0, "", ErrorNotFound
+	}
+
+	// the compressed blobs have the original size and crc32c
+	reader, size, hash, err = b.fs.openBlobFile(uid, blobPath)
+	if err != nil {
+		log.Errorf("cannot open blob %v", err)
+		return
+	}
+	if hash != "" {
+		return reader, generation, size, "crc32c=" + hash, nil
+	}
+	//TODO: cache the crc32c
+	hash, err = common.CRC32CFromReader(reader)
+	if err != nil {
+		reader.Close()
+		log.Errorf("cannot get crc32c hash %v", err)
+		return
+	}
+	_, err = reader.(io.Seeker).Seek(0, 0)
+	if err != nil {
+		reader.Close()
+		log.Errorf("cannot rewind file %v", err)
+		return
+	}
+	return reader, generation, size, "crc32c=" + hash, err
+}
+
+// StoreBlob stores a document
+func (b *localBlobs) StoreBlob(uid, id string, stream io.Reader, lastGen int64) (generation int64, err error) {
+	generation = 1
+
+	reader := stream
+	if id == rootBlob {
+		historyPath := path.Join(b.fs.getUserBlobPath(uid), historyFile)
+		var lock fslock.Handle
+		lock, err = fslock.Lock(historyPath)
+		if err != nil {
+			log.Error("cannot obtain lock")
+			return 0, err
+		}
+		defer lock.Unlock()
+
+		currentGen := int64(0)
+		fi, err1 := os.Stat(historyPath)
+		if err1 == nil {
+			currentGen = generationFromFileSize(fi.Size())
+		}
+
+		blobPath := path.Join(b.fs.getUserBlobPath(uid), common.Sanitize(id))
+		_, blobErr := os.Stat(blobPath)
+		rootExists := blobErr == nil
+
+		if currentGen != lastGen && currentGen > 0 && rootExists {
+			log.Warnf("wrong generation, currentGen %d, lastGen %d", currentGen, lastGen)
+			return currentGen, ErrorWrongGeneration
+		}
+
+		var buf bytes.Buffer
+		tee := io.TeeReader(stream, &buf)
+
+		var hist *os.File
+		hist, err = os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return
+		}
+		defer hist.Close()
+		t := time.Now().UTC().Format(time.RFC3339) + " "
+		hist.WriteString(t)
+		_, err = io.Copy(hist, tee)
+		if err != nil {
+			return
+		}
+		hist.WriteString("\n")
+
+		reader = io.NopCloser(&buf)
+		size, err1 := hist.Seek(0, io.SeekCurrent)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		generation = generationFromFileSize(size)
+	}
+
+	blobDir := b.fs.getUserBlobPath(uid)
+	blobPath := path.Join(blobDir, common.Sanitize(id))
+	log.Info("Write: ", blobPath)
+	if id != rootBlob && (b.fs.Cfg.BlobDedup || b.fs.Cfg.BlobCompression != "" || b.fs.encryptionEnabled()) {
+		err = b.fs.storeBlobFile(uid, blobDir, blobPath, id, reader)
+		return
+	}
+	err = writeFileAtomic(blobDir, blobPath, reader)
+	return
+}
+
+// storeBlobFile writes a blob to dest, compressed, encrypted and as a link to the shared copy if enabled.
+// Only a blob whose content matches its id is shared, otherwise anyone could replace the documents of the others.
+// The root blob and its history stay plaintext, they only have hashes.
+func (fs *FileSystemStorage) storeBlobFile(uid, blobDir, dest, id string, reader io.Reader) error {
+	hasher := sha256.New()
+	content, err := fs.blobContent(io.TeeReader(reader, hasher))
+	if err != nil {
+		return err
+	}
+	if fs.encryptionEnabled() {
+		// encrypted while written, the keys are per user, nothing to share
+		return fs.writeUserFile(uid, blobDir, dest, content)
+	}
+	if !fs.Cfg.BlobDedup {
+		return writeFileAtomic(blobDir, dest, content)
+	}
+
+	file, err := os.CreateTemp(blobDir, tmpBlobPrefix)
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = io.Copy(file, content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) == id {
+		if _, err = fs.shareBlob(tmpPath, id); err != nil {
+			// eg another file system, keep a private copy
+			log.Warn("dedup: ", err)
+		}
+	}
+	return os.Rename(tmpPath, dest)
+}
+
+// writeFileAtomic writes to a temp file in dir and renames it to dest,
+// an interrupted write never leaves a partial dest
+func writeFileAtomic(dir, dest string, reader io.Reader) error {
+	return writeFileAtomicFunc(dir, dest, func(w io.Writer) error {
+		_, err := io.Copy(w, reader)
+		return err
+	})
+}
+
+// writeFileAtomicFunc like writeFileAtomic, the content is written by write
+func writeFileAtomicFunc(dir, dest string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(dir, tmpBlobPrefix)
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dest)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// use file size as generation
+func generationFromFileSize(size int64) int64 {
+	//time + 1 space + 64 hash + 1 newline
+	return size / 86
+}
@@ -13,9 +13,9 @@ import (
 	"strings"
 	"time"
 
-	"github.com/danjacques/gofslock/fslock"
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/metrics"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
@@ -36,9 +36,7 @@ func (fs *FileSystemStorage) GetCachedTree(uid string) (t *models.HashTree, err
 		fs:  fs,
 	}
 
-	cachePath := path.Join(fs.getUserPath(uid), cachedTreeName)
-
-	tree, err := models.LoadTree(cachePath)
+	tree, err := fs.loadCachedTree(uid)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +46,7 @@ func (fs *FileSystemStorage) GetCachedTree(uid string) (t *models.HashTree, err
 		return nil, err
 	}
 	if changed {
-		err = tree.Save(cachePath)
+		err = fs.SaveCachedTree(uid, tree)
 		if err != nil {
 			return nil, err
 		}
@@ -56,10 +54,35 @@ func (fs *FileSystemStorage) GetCachedTree(uid string) (t *models.HashTree, err
 	return tree, nil
 }
 
-// SaveCachedTree saves the cached tree
-func (fs *FileSystemStorage) SaveCachedTree(uid string, t *models.HashTree) error {
+// loadCachedTree reads the cached tree, decrypting it if needed, an empty tree if there is none
+func (fs *FileSystemStorage) loadCachedTree(uid string) (*models.HashTree, error) {
 	cachePath := path.Join(fs.getUserPath(uid), cachedTreeName)
-	return t.Save(cachePath)
+	f, err := fs.openUserFile(uid, cachePath)
+	if os.IsNotExist(err) {
+		return &models.HashTree{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tree, err := models.ReadTree(f)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("cached tree loaded: ", cachePath)
+	return tree, nil
+}
+
+// SaveCachedTree saves the cached tree, encrypted if enabled
+func (fs *FileSystemStorage) SaveCachedTree(uid string, t *models.HashTree) error {
+	userPath := fs.getUserPath(uid)
+	cachePath := path.Join(userPath, cachedTreeName)
+	log.Println("Writing cache: ", cachePath)
+	var buf bytes.Buffer
+	if err := t.Write(&buf); err != nil {
+		return err
+	}
+	return fs.writeUserFile(uid, userPath, cachePath, &buf)
 }
 
 func (fs *FileSystemStorage) BlobStorage(uid string) *LocalBlobStorage {
@@ -79,8 +102,11 @@ func (fs *FileSystemStorage) ExportRmDoc(uid, docid string) (io.ReadCloser, erro
 	if err != nil {
 		return nil, err
 	}
-	ls := fs.BlobStorage(uid)
+	return exportRmDoc(doc, fs.BlobStorage(uid)), nil
+}
 
+// exportRmDoc zips all blobs of a document
+func exportRmDoc(doc *models.HashDoc, ls *LocalBlobStorage) io.ReadCloser {
 	reader, writer := io.Pipe()
 	go func() {
 		zw := zip.NewWriter(writer)
@@ -113,7 +139,7 @@ func (fs *FileSystemStorage) ExportRmDoc(uid, docid string) (io.ReadCloser, erro
 		zw.Close()
 		writer.Close()
 	}()
-	return reader, nil
+	return reader
 }
 
 // Export exports a document
@@ -126,8 +152,11 @@ func (fs *FileSystemStorage) Export(uid, docid string) (r io.ReadCloser, err err
 	if err != nil {
 		return nil, err
 	}
-	ls := fs.BlobStorage(uid)
+	return exportPdf(doc, fs.BlobStorage(uid))
+}
 
+// exportPdf renders a document
+func exportPdf(doc *models.HashDoc, ls *LocalBlobStorage) (r io.ReadCloser, err error) {
 	archive, err := models.ArchiveFromHashDoc(doc, ls)
 	if err != nil {
 		return nil, err
@@ -145,6 +174,23 @@ func (fs *FileSystemStorage) Export(uid, docid string) (r io.ReadCloser, err err
 	return reader, err
 }
 
+// ExportBlobPages renders a page (starting from 1) as svg or png, or all of them in a zip when page is 0
+func (fs *FileSystemStorage) ExportBlobPages(uid, docid, format string, page int) (io.ReadCloser, error) {
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := tree.FindDoc(docid)
+	if err != nil {
+		return nil, err
+	}
+	arch, err := models.ArchiveFromHashDoc(doc, fs.BlobStorage(uid))
+	if err != nil {
+		return nil, err
+	}
+	return renderPages(arch, format, page)
+}
+
 // UpdateBlobDocument updates metadata
 func (fs *FileSystemStorage) UpdateBlobDocument(uid, docID, name, parent string) (err error) {
 	tree, err := fs.GetCachedTree(uid)
@@ -166,31 +212,7 @@ func (fs *FileSystemStorage) UpdateBlobDocument(uid, docID, name, parent string)
 		hashDoc.Parent = parent
 		hashDoc.Version++
 
-		metadataHash, metadataReader, err := hashDoc.MetadataReader()
-		if err != nil {
-			return err
-		}
-
-		err = blobStorage.Write(metadataHash, metadataReader)
-		if err != nil {
-			return err
-		}
-
-		//update the metadata hash
-		for _, hashEntry := range hashDoc.Files {
-			if hashEntry.IsMetadata() {
-				hashEntry.Hash = metadataHash
-				break
-			}
-		}
-		hashDoc.Rehash()
-		hashDocReader, err := hashDoc.IndexReader()
-		if err != nil {
-			return err
-		}
-
-		err = blobStorage.Write(hashDoc.Hash, hashDocReader)
-		if err != nil {
+		if err = writeMetadata(blobStorage, hashDoc); err != nil {
 			return err
 		}
 
@@ -201,6 +223,34 @@ func (fs *FileSystemStorage) UpdateBlobDocument(uid, docID, name, parent string)
 	return err
 }
 
+// writeMetadata stores the changed metadata and the new index of the document
+func writeMetadata(blobStorage *LocalBlobStorage, hashDoc *models.HashDoc) error {
+	metadataHash, metadataReader, err := hashDoc.MetadataReader()
+	if err != nil {
+		return err
+	}
+
+	err = blobStorage.Write(metadataHash, metadataReader)
+	if err != nil {
+		return err
+	}
+
+	//update the metadata hash
+	for _, hashEntry := range hashDoc.Files {
+		if hashEntry.IsMetadata() {
+			hashEntry.Hash = metadataHash
+			break
+		}
+	}
+	hashDoc.Rehash()
+	hashDocReader, err := hashDoc.IndexReader()
+	if err != nil {
+		return err
+	}
+
+	return blobStorage.Write(hashDoc.Hash, hashDocReader)
+}
+
 // DeleteBlobDocument deletes blob document
 func (fs *FileSystemStorage) DeleteBlobDocument(uid, docID string) (err error) {
 	tree, err := fs.GetCachedTree(uid)
@@ -336,7 +386,11 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 		return fs.createFromRmDoc(uid, parent, stream)
 	}
 
-	blobPath := fs.getUserBlobPath(uid)
+	// spool next to the blobs, the s3 blobs are not on this disk
+	spoolDir := ""
+	if _, local := fs.blobs.(*localBlobs); local {
+		spoolDir = fs.getUserBlobPath(uid)
+	}
 	docid := uuid.New().String()
 	docName := strings.TrimSuffix(filename, ext)
 
@@ -401,24 +455,35 @@ func (fs *FileSystemStorage) CreateBlobDocument(uid, filename, parent string, st
 	}
 
 	// given that the payload can be huge
-	// calculate the hash while streaming the payload to the storage
-	// then rename it
-	tmpdoc, err := os.CreateTemp(blobPath, "blob-upload")
+	// calculate the hash while spooling the payload to a temp file (encrypted if enabled)
+	// then store it under its hash
+	tmpdoc, err := os.CreateTemp(spoolDir, "blob-upload")
 	if err != nil {
 		return
 	}
-	defer tmpdoc.Close()
-	defer os.Remove(tmpdoc.Name())
+	tmpPath := tmpdoc.Name()
+	tmpdoc.Close()
+	defer os.Remove(tmpPath)
 
-	tee := io.TeeReader(stream, tmpdoc)
+	spool, err := fs.createUserFile(uid, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	tee := io.TeeReader(stream, spool)
 	payloadHash, size, err := models.Hash(tee)
+	if cerr := spool.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return nil, err
 	}
-	tmpdoc.Close()
-	payloadFilename := path.Join(blobPath, payloadHash)
-	log.Debug("new payload name: ", payloadFilename)
-	err = os.Rename(tmpdoc.Name(), payloadFilename)
+	payload, err := fs.openUserFile(uid, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer payload.Close()
+	log.Debug("new payload: ", payloadHash)
+	err = blobStorage.Write(payloadHash, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -461,6 +526,33 @@ func (fs *FileSystemStorage) createFromRmDoc(uid, parent string, stream io.Reade
 		return nil, err
 	}
 
+	blobStorage := fs.BlobStorage(uid)
+	hashDoc, err := storeRmDoc(blobStorage, parent, data)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	err = updateTree(tree, blobStorage, func(t *models.HashTree) error {
+		return tree.Add(hashDoc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.Document{
+		ID:     hashDoc.EntryName,
+		Type:   hashDoc.CollectionType,
+		Parent: hashDoc.Parent,
+		Name:   hashDoc.DocumentName,
+	}, nil
+}
+
+// storeRmDoc writes the blobs and the index of an rmdoc, without adding it to the tree
+func storeRmDoc(blobStorage *LocalBlobStorage, parent string, data []byte) (*models.HashDoc, error) {
 	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
 		return nil, err
@@ -500,8 +592,6 @@ func (fs *FileSystemStorage) createFromRmDoc(uid, parent string, stream io.Reade
 	metadata.Synced = true
 	metadata.MetadataModified = true
 
-	blobStorage := fs.BlobStorage(uid)
-
 	metaReader := bytes.NewReader(metaBytes)
 	metaHash, metaSize, err := models.Hash(metaReader)
 	if err != nil {
@@ -575,24 +665,7 @@ func (fs *FileSystemStorage) createFromRmDoc(uid, parent string, stream io.Reade
 	if err := blobStorage.Write(hashDoc.Hash, indexReader); err != nil {
 		return nil, err
 	}
-
-	tree, err := fs.GetCachedTree(uid)
-	if err != nil {
-		return nil, err
-	}
-	err = updateTree(tree, blobStorage, func(t *models.HashTree) error {
-		return tree.Add(hashDoc)
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return &storage.Document{
-		ID:     docid,
-		Type:   metadata.CollectionType,
-		Parent: metadata.Parent,
-		Name:   metadata.DocumentName,
-	}, nil
+	return hashDoc, nil
 }
 
 func createMetadataFile(metadata models.MetadataFile) (r io.Reader, filehash string, size int64, err error) {
@@ -641,122 +714,34 @@ func (fs *FileSystemStorage) GetBlobURL(uid, blobid string, write bool) (docurl
 
 // LoadBlob Opens a blob by id
 func (fs *FileSystemStorage) LoadBlob(uid, blobid string) (reader io.ReadCloser, gen int64, size int64, hash string, err error) {
-	generation := int64(0)
-	blobPath := path.Join(fs.getUserBlobPath(uid), common.Sanitize(blobid))
-	log.Debugln("Fullpath:", blobPath)
-	if blobid == rootBlob {
-		historyPath := path.Join(fs.getUserBlobPath(uid), historyFile)
-		lock, err := fslock.Lock(historyPath)
-		if err != nil {
-			log.Error("cannot obtain lock")
-			return nil, 0, 0, "", err
-		}
-		defer lock.Unlock()
-
-		fi, err1 := os.Stat(historyPath)
-		if err1 == nil {
-			generation = generationFromFileSize(fi.Size())
-		}
-	}
-
-	fi, err := os.Stat(blobPath)
-	if err != nil || fi.IsDir() {
-		return nil, generation, 0, "", ErrorNotFound
-	}
-
-	osFile, err := os.Open(blobPath)
-	if err != nil {
-		log.Errorf("cannot open blob %v", err)
-		return
-	}
-	//TODO: cache the crc32c
-	hash, err = common.CRC32CFromReader(osFile)
+	reader, gen, size, hash, err = fs.blobs.LoadBlob(uid, blobid)
 	if err != nil {
-		log.Errorf("cannot get crc32c hash %v", err)
 		return
 	}
-	_, err = osFile.Seek(0, 0)
-	if err != nil {
-		log.Errorf("cannot rewind file %v", err)
-		return
-	}
-	reader = osFile
-	return reader, generation, fi.Size(), "crc32c=" + hash, err
+	return metrics.CountReads(reader, metrics.BlobBytesRead), gen, size, hash, nil
 }
 
 // StoreBlob stores a document
 func (fs *FileSystemStorage) StoreBlob(uid, id string, stream io.Reader, lastGen int64) (generation int64, err error) {
-	generation = 1
-
-	reader := stream
+	stream = &metrics.CountingReader{ReadCloser: io.NopCloser(stream), Counter: metrics.BlobBytesWritten}
+	generation, err = fs.blobs.StoreBlob(uid, id, stream, lastGen)
 	if id == rootBlob {
-		historyPath := path.Join(fs.getUserBlobPath(uid), historyFile)
-		var lock fslock.Handle
-		lock, err = fslock.Lock(historyPath)
-		if err != nil {
-			log.Error("cannot obtain lock")
-			return 0, err
-		}
-		defer lock.Unlock()
-
-		currentGen := int64(0)
-		fi, err1 := os.Stat(historyPath)
-		if err1 == nil {
-			currentGen = generationFromFileSize(fi.Size())
-		}
-
-		blobPath := path.Join(fs.getUserBlobPath(uid), common.Sanitize(id))
-		_, blobErr := os.Stat(blobPath)
-		rootExists := blobErr == nil
-
-		if currentGen != lastGen && currentGen > 0 && rootExists {
-			log.Warnf("wrong generation, currentGen %d, lastGen %d", currentGen, lastGen)
-			return currentGen, ErrorWrongGeneration
-		}
-
-		var buf bytes.Buffer
-		tee := io.TeeReader(stream, &buf)
-
-		var hist *os.File
-		hist, err = os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			return
-		}
-		defer hist.Close()
-		t := time.Now().UTC().Format(time.RFC3339) + " "
-		hist.WriteString(t)
-		_, err = io.Copy(hist, tee)
-		if err != nil {
-			return
-		}
-		hist.WriteString("\n")
-
-		reader = io.NopCloser(&buf)
-		size, err1 := hist.Seek(0, io.SeekCurrent)
-		if err1 != nil {
-			err = err1
-			return
+		if err == ErrorWrongGeneration {
+			metrics.GenerationConflicts.Inc()
+		} else if err == nil {
+			metrics.RootUpdates.Inc()
+			fs.usageChanged(uid)
 		}
-		generation = generationFromFileSize(size)
-	}
-
-	blobPath := path.Join(fs.getUserBlobPath(uid), common.Sanitize(id))
-	log.Info("Write: ", blobPath)
-	file, err := os.Create(blobPath)
-	if err != nil {
-		return
 	}
-	defer file.Close()
-	_, err = io.Copy(file, reader)
-	if err != nil {
-		return
-	}
-
 	return
 }
 
-// use file size as generation
-func generationFromFileSize(size int64) int64 {
-	//time + 1 space + 64 hash + 1 newline
-	return size / 86
+// StatBlob returns the size of a blob or ErrorNotFound
+func (fs *FileSystemStorage) StatBlob(uid, blobid string) (int64, error) {
+	return fs.blobs.StatBlob(uid, blobid)
+}
+
+// RootHistory returns the root modification log of the user
+func (fs *FileSystemStorage) RootHistory(uid string) ([]*models.RootHistory, error) {
+	return fs.blobs.RootHistory(uid)
 }
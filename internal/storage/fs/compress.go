@@ -0,0 +1,373 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+// compressedMagic starts a compressed blob file, it is followed by
+// the original size (8 bytes) and crc32c (4 bytes) and the zstd frame
+var compressedMagic = []byte("rmfczst1")
+
+// rawMagic escapes a raw blob starting like a header, the content follows
+var rawMagic = []byte("rmfcraw1")
+
+// zstdMagic starts a zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+const (
+	compressedHeaderSize = 8 + 8 + 4
+	// maxCompressedBlobSize larger blobs are kept raw, a blob is compressed in memory
+	maxCompressedBlobSize = 64 << 20
+	// minCompressedBlobSize not worth it below
+	minCompressedBlobSize = 128
+)
+
+var zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+	enc, _ := zstd.NewWriter(nil)
+	return enc
+})
+
+// CompressReport the result of compressing the existing blobs
+type CompressReport struct {
+	// Files blobs seen
+	Files int
+	// Compressed blobs (would be) compressed now
+	Compressed int
+	// Already compressed before
+	Already int
+	// Before and After the size of the blobs compressed now
+	Before int64
+	After  int64
+}
+
+// compressBlob the compressed file content, false if it doesn't get at least 10% smaller
+// (pdfs and images are compressed already)
+func compressBlob(data []byte) ([]byte, bool) {
+	if len(data) < minCompressedBlobSize || len(data) > maxCompressedBlobSize {
+		return nil, false
+	}
+	crc := common.CRC32CWriter()
+	crc.Write(data)
+
+	out := make([]byte, compressedHeaderSize, compressedHeaderSize+len(data)/2)
+	copy(out, compressedMagic)
+	binary.BigEndian.PutUint64(out[8:], uint64(len(data)))
+	binary.BigEndian.PutUint32(out[16:], crc.Sum32())
+	out = zstdEncoder().EncodeAll(data, out)
+	if len(out) > len(data)*9/10 {
+		return nil, false
+	}
+	return out, true
+}
+
+// isCompressed the header and the start of the zstd frame, a raw blob from before the escape
+// starting with the magic is still read raw
+func isCompressed(data []byte) bool {
+	return len(data) >= compressedHeaderSize+len(zstdMagic) &&
+		bytes.Equal(data[:len(compressedMagic)], compressedMagic) &&
+		bytes.Equal(data[compressedHeaderSize:compressedHeaderSize+len(zstdMagic)], zstdMagic)
+}
+
+func isEscaped(data []byte) bool {
+	return bytes.HasPrefix(data, rawMagic)
+}
+
+// escapeRaw prefixes a raw blob with rawMagic if it starts like a header, so it is never read as one
+func escapeRaw(r io.Reader) io.Reader {
+	head := make([]byte, len(compressedMagic))
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return failedReader{err}
+	}
+	head = head[:n]
+	if bytes.Equal(head, compressedMagic) || bytes.Equal(head, rawMagic) {
+		return io.MultiReader(bytes.NewReader(rawMagic), bytes.NewReader(head), r)
+	}
+	return io.MultiReader(bytes.NewReader(head), r)
+}
+
+type failedReader struct {
+	err error
+}
+
+func (f failedReader) Read([]byte) (int, error) {
+	return 0, f.err
+}
+
+// blobContent the content of a blob file as it is stored: compressed if enabled and worth it, otherwise raw.
+// A raw blob is only escaped with the compression on, without it the blobs are stored as is.
+// Up to maxCompressedBlobSize is read into memory to compress it
+func (fs *FileSystemStorage) blobContent(r io.Reader) (io.Reader, error) {
+	if fs.Cfg.BlobCompression == "" {
+		return r, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxCompressedBlobSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if out, ok := compressBlob(data); ok {
+		return bytes.NewReader(out), nil
+	}
+	return escapeRaw(io.MultiReader(bytes.NewReader(data), r)), nil
+}
+
+// openBlobFile opens a blob file of a user, decrypting and decompressing it if needed.
+// Returns the original size and the crc32c (base64) if it was stored
+func (fs *FileSystemStorage) openBlobFile(uid, p string) (reader io.ReadCloser, size int64, crc32c string, err error) {
+	file, err := fs.openUserFile(uid, p)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	header := make([]byte, compressedHeaderSize+len(zstdMagic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		file.Close()
+		return nil, 0, "", err
+	}
+	header = header[:n]
+	switch {
+	case isCompressed(header):
+		size = int64(binary.BigEndian.Uint64(header[8:]))
+		z, err := newZstdFile(file, size)
+		if err != nil {
+			file.Close()
+			return nil, 0, "", err
+		}
+		return z, size, base64.StdEncoding.EncodeToString(header[16:compressedHeaderSize]), nil
+	case isEscaped(header):
+		offset := int64(len(rawMagic))
+		return &sectionFile{SectionReader: io.NewSectionReader(file, offset, file.Size()-offset), file: file}, file.Size() - offset, "", nil
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, "", err
+	}
+	return file, file.Size(), "", nil
+}
+
+// sectionFile the content of an escaped raw blob
+type sectionFile struct {
+	*io.SectionReader
+	file userFile
+}
+
+func (f *sectionFile) Close() error {
+	return f.file.Close()
+}
+
+// zstdFile decodes a compressed blob file while it is read,
+// seeking back decodes it again from the start (the pdf export needs to seek)
+type zstdFile struct {
+	file userFile
+	dec  *zstd.Decoder
+	size int64
+	pos  int64
+}
+
+func newZstdFile(file userFile, size int64) (*zstdFile, error) {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	if err != nil {
+		return nil, err
+	}
+	z := &zstdFile{file: file, dec: dec, size: size}
+	if err = z.rewind(); err != nil {
+		dec.Close()
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *zstdFile) rewind() error {
+	if _, err := z.file.Seek(compressedHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+	z.pos = 0
+	return z.dec.Reset(z.file)
+}
+
+func (z *zstdFile) Read(p []byte) (int, error) {
+	if z.pos >= z.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > z.size-z.pos {
+		p = p[:z.size-z.pos]
+	}
+	n, err := z.dec.Read(p)
+	z.pos += int64(n)
+	if err == io.EOF && z.pos < z.size {
+		err = errors.New("compressed blob size mismatch")
+	}
+	return n, err
+}
+
+func (z *zstdFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += z.pos
+	case io.SeekEnd:
+		offset += z.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start")
+	}
+	if offset < z.pos {
+		if err := z.rewind(); err != nil {
+			return 0, err
+		}
+	}
+	if skip := min(offset, z.size) - z.pos; skip > 0 {
+		if _, err := io.CopyN(io.Discard, z, skip); err != nil {
+			return 0, err
+		}
+	}
+	z.pos = offset
+	return offset, nil
+}
+
+func (z *zstdFile) Close() error {
+	z.dec.Close()
+	return z.file.Close()
+}
+
+// compressFile replaces the blob file at p with its compressed form if it is worth it, returns the sizes
+func compressFile(p string, dryRun bool) (before, after int64, err error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi.Size() < minCompressedBlobSize || fi.Size() > maxCompressedBlobSize {
+		return 0, 0, nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	if isCompressed(data) {
+		return 0, 0, nil
+	}
+	out, ok := compressBlob(bytes.TrimPrefix(data, rawMagic))
+	if !ok {
+		return 0, 0, nil
+	}
+	if !dryRun {
+		if err = writeFileAtomic(filepath.Dir(p), p, bytes.NewReader(out)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return int64(len(data)), int64(len(out)), nil
+}
+
+// CompressBlobs compresses the blobs stored raw of all users.
+// The shared copies are compressed once and the users linked to them again
+func (fs *FileSystemStorage) CompressBlobs(dryRun bool) (*CompressReport, error) {
+	if _, local := fs.blobs.(*localBlobs); !local {
+		return nil, errors.New("only the fs blob storage can be compressed")
+	}
+	users, err := fs.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	report := &CompressReport{}
+	dedup := fs.dedupSupported()
+	shared := map[string]bool{}
+
+	for _, u := range users {
+		blobDir := fs.getUserBlobPath(u.ID)
+		entries, err := os.ReadDir(blobDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || name == rootBlob || name == historyFile || strings.HasPrefix(name, tmpBlobPrefix) {
+				continue
+			}
+			report.Files++
+			p := filepath.Join(blobDir, name)
+			// compressed before it was encrypted
+			if encrypted, _, err := encryptedKeyID(p); err != nil || encrypted {
+				continue
+			}
+			if compressed, err := isCompressedFile(p); err != nil || compressed {
+				if compressed {
+					report.Already++
+				}
+				continue
+			}
+
+			// the users are linked to the compressed copy afterwards
+			if dedup && isContentHash(name) && fs.hasSharedCopy(name) {
+				if shared[name] {
+					continue
+				}
+				shared[name] = true
+				sharedPath := fs.sharedBlobPath(name)
+				before, after, err := compressFile(sharedPath, dryRun)
+				if err != nil {
+					return report, err
+				}
+				if after > 0 {
+					report.Compressed++
+					report.Before += before
+					report.After += after
+				}
+				continue
+			}
+
+			before, after, err := compressFile(p, dryRun)
+			if err != nil {
+				return report, err
+			}
+			if after > 0 {
+				report.Compressed++
+				report.Before += before
+				report.After += after
+			}
+		}
+	}
+
+	// the links of the users point to the old copies
+	if len(shared) > 0 && !dryRun {
+		if _, err := fs.DedupBlobs(false); err != nil {
+			return report, err
+		}
+	}
+	log.Infof("compress: %d blobs, %d compressed, %d already, saved %d bytes",
+		report.Files, report.Compressed, report.Already, report.Before-report.After)
+	return report, nil
+}
+
+func (fs *FileSystemStorage) hasSharedCopy(hash string) bool {
+	_, err := os.Stat(fs.sharedBlobPath(hash))
+	return err == nil
+}
+
+func isCompressedFile(p string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, compressedHeaderSize+len(zstdMagic))
+	if _, err = io.ReadFull(f, header); err != nil {
+		// shorter than a header
+		return false, nil
+	}
+	return isCompressed(header), nil
+}
@@ -0,0 +1,205 @@
+package fs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobCompression(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, HashSchemaVersion: "3"}
+	fs := NewStorage(cfg)
+	for _, uid := range []string{"alice", "bob"} {
+		if err = os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, fs.UpdateUser(&model.User{ID: uid, Sync15: true}))
+	}
+	content := strings.Repeat(`{"id":"page","lines":[1,2,3]}`, 100)
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	crc, _ := common.CRC32CFromReader(strings.NewReader(content))
+	load := func(uid, id string) string {
+		r, _, size, crc32c, err := fs.blobs.LoadBlob(uid, id)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer r.Close()
+		_, seekable := r.(io.ReadSeekCloser)
+		assert.True(t, seekable)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), size)
+		assert.Equal(t, "crc32c="+crc, crc32c)
+		return string(data)
+	}
+	stored := func(uid, id string) int64 {
+		size, err := fs.blobs.StatBlob(uid, id)
+		assert.NoError(t, err)
+		return size
+	}
+
+	// raw before the compression was enabled
+	_, err = fs.blobs.StoreBlob("alice", hash, strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), stored("alice", hash))
+
+	cfg.BlobCompression = config.BlobCompressionZstd
+	_, err = fs.blobs.StoreBlob("bob", hash, strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	assert.Less(t, stored("bob", hash), int64(len(content)/10))
+	assert.Equal(t, content, load("bob", hash))
+	assert.Equal(t, content, load("alice", hash), "side by side")
+
+	// not worth it
+	random := make([]byte, 4096)
+	rand.Read(random)
+	_, err = fs.blobs.StoreBlob("bob", "random", strings.NewReader(string(random)), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(random)), stored("bob", "random"))
+
+	report, err := fs.CompressBlobs(true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Compressed)
+	assert.Equal(t, 1, report.Already)
+	assert.Equal(t, int64(len(content)), report.Before)
+	assert.Equal(t, int64(len(content)), stored("alice", hash), "dry run")
+
+	report, err = fs.CompressBlobs(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Compressed)
+	assert.Greater(t, report.Before-report.After, int64(len(content)/2))
+	assert.Less(t, stored("alice", hash), int64(len(content)/10))
+	assert.Equal(t, content, load("alice", hash))
+}
+
+func TestBlobCompressionShared(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, HashSchemaVersion: "3", BlobDedup: true}
+	fs := NewStorage(cfg)
+	content := strings.Repeat("reMarkable .lines file, version=6 ", 50)
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	for _, uid := range []string{"alice", "bob"} {
+		if err = os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, fs.UpdateUser(&model.User{ID: uid, Sync15: true}))
+		_, err = fs.blobs.StoreBlob(uid, hash, strings.NewReader(content), 0)
+		assert.NoError(t, err)
+	}
+
+	report, err := fs.CompressBlobs(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Compressed, "the shared copy once")
+	for _, uid := range []string{"alice", "bob"} {
+		assert.True(t, isLinked(fs, uid, hash))
+		compressed, err := isCompressedFile(fs.getUserBlobPath(uid) + "/" + hash)
+		assert.NoError(t, err)
+		assert.True(t, compressed)
+	}
+}
+
+func isLinked(fs *FileSystemStorage, uid, hash string) bool {
+	fi, err := os.Stat(fs.getUserBlobPath(uid) + "/" + hash)
+	if err != nil {
+		return false
+	}
+	sharedFi, err := os.Stat(fs.sharedBlobPath(hash))
+	return err == nil && os.SameFile(fi, sharedFi)
+}
+
+func TestBlobCompressionEscape(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, HashSchemaVersion: "3"}
+	fs := NewStorage(cfg)
+	if err = os.MkdirAll(fs.getUserBlobPath("alice"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, fs.UpdateUser(&model.User{ID: "alice", Sync15: true}))
+	load := func(id string) string {
+		r, _, size, _, err := fs.blobs.LoadBlob("alice", id)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), size)
+		return string(data)
+	}
+
+	// without the compression the blobs are stored as is
+	_, err = fs.blobs.StoreBlob("alice", "raw", strings.NewReader("short"), 0)
+	assert.NoError(t, err)
+	stored, err := os.ReadFile(fs.getUserBlobPath("alice") + "/raw")
+	assert.NoError(t, err)
+	assert.Equal(t, "short", string(stored))
+
+	// a blob looking like a compressed one
+	cfg.BlobCompression = config.BlobCompressionZstd
+	compressed, ok := compressBlob([]byte(strings.Repeat("page ", 100)))
+	assert.True(t, ok)
+	for _, content := range []string{string(compressed), string(rawMagic) + "x", "short"} {
+		_, err = fs.blobs.StoreBlob("alice", "compressed", strings.NewReader(content), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, content, load("compressed"))
+	}
+
+	// stored raw before the escape
+	legacy := string(compressedMagic) + strings.Repeat("x", 100)
+	assert.NoError(t, os.WriteFile(fs.getUserBlobPath("alice")+"/legacy", []byte(legacy), 0600))
+	assert.Equal(t, legacy, load("legacy"))
+
+	// decoded while read
+	content := strings.Repeat("0123456789", 1000)
+	_, err = fs.blobs.StoreBlob("alice", "seek", strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	before, _, err := compressFile(fs.getUserBlobPath("alice")+"/seek", false)
+	assert.NoError(t, err)
+	assert.Zero(t, before, "compressed once")
+	r, _, _, _, err := fs.blobs.LoadBlob("alice", "seek")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	_, isStream := r.(*zstdFile)
+	assert.True(t, isStream)
+	s := r.(io.ReadSeeker)
+	buf := make([]byte, 10)
+	for _, off := range []int64{5000, 20, 9990} {
+		_, err = s.Seek(off, io.SeekStart)
+		assert.NoError(t, err)
+		_, err = io.ReadFull(s, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, content[off:off+10], string(buf))
+	}
+	pos, err := s.Seek(-3, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)-3), pos)
+	rest, err := io.ReadAll(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "789", string(rest))
+}
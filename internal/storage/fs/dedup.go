@@ -0,0 +1,255 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sharedBlobDir the deduplicated blobs of all users, in the data dir.
+// A user blob is a hard link to the shared copy, so the link count is the reference count
+// and removing the blob of a user (gc, user deletion) never touches the others.
+const sharedBlobDir = "blobs"
+
+// DedupReport the result of deduplicating the existing blobs
+type DedupReport struct {
+	// Files content addressed blobs seen
+	Files int
+	// Linked blobs replaced with a link to the shared copy
+	Linked int
+	// Added blobs which became the shared copy
+	Added int
+	// Skipped blobs whose content doesn't match their id
+	Skipped    int
+	SavedBytes int64
+}
+
+// sharedBlobPath blobs/<first 2 chars>/<hash>
+func (fs *FileSystemStorage) sharedBlobPath(hash string) string {
+	return filepath.Join(fs.Cfg.DataDir, sharedBlobDir, hash[:2], hash)
+}
+
+// isContentHash only the blobs named after the sha256 of their content can be shared
+func isContentHash(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// shareBlob makes p a link to the shared copy of hash, p becomes the shared copy if there is none.
+// Returns true if p was replaced
+func (fs *FileSystemStorage) shareBlob(p, hash string) (bool, error) {
+	shared := fs.sharedBlobPath(hash)
+	var err error
+	for i := 0; i < 3; i++ {
+		err = os.Link(p, shared)
+		if err == nil {
+			return false, nil
+		}
+		if os.IsNotExist(err) {
+			if err = os.MkdirAll(filepath.Dir(shared), 0700); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if !os.IsExist(err) {
+			return false, err
+		}
+
+		var replaced bool
+		replaced, err = replaceWithLink(shared, p)
+		// swept meanwhile, add it again
+		if os.IsNotExist(err) {
+			continue
+		}
+		return replaced, err
+	}
+	return false, err
+}
+
+// replaceWithLink replaces p with a hard link to shared
+func replaceWithLink(shared, p string) (bool, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+	sharedFi, err := os.Stat(shared)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(fi, sharedFi) {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), tmpBlobPrefix)
+	if err != nil {
+		return false, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath)
+	if err = os.Link(shared, tmpPath); err != nil {
+		return false, err
+	}
+	// the gc grace period is based on the modification time, the blob is new for this user
+	now := time.Now()
+	os.Chtimes(tmpPath, now, now)
+	if err = os.Rename(tmpPath, p); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// CollectSharedBlobs deletes the shared blobs no user links to anymore
+func (fs *FileSystemStorage) CollectSharedBlobs(opts GCOptions) (*GCReport, error) {
+	report := &GCReport{UID: sharedBlobDir}
+	root := filepath.Join(fs.Cfg.DataDir, sharedBlobDir)
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			// removed in the meantime
+			return nil
+		}
+		links, ok := linkCount(fi)
+		if !ok || links > 1 {
+			report.Reachable++
+			return nil
+		}
+		if fi.ModTime().After(cutoff) {
+			report.Recent++
+			return nil
+		}
+		if !opts.DryRun {
+			if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		report.Swept = append(report.Swept, d.Name())
+		report.SweptBytes += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	log.Infof("gc: shared blobs referenced: %d, recent: %d, unreferenced: %d (%d bytes)",
+		report.Reachable, report.Recent, len(report.Swept), report.SweptBytes)
+	return report, nil
+}
+
+// DedupBlobs links the existing blobs of all users to the shared copies
+func (fs *FileSystemStorage) DedupBlobs(dryRun bool) (*DedupReport, error) {
+	users, err := fs.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	report := &DedupReport{}
+	// the blobs which would be shared, for the dry run
+	seen := map[string]bool{}
+
+	for _, u := range users {
+		blobDir := fs.getUserBlobPath(u.ID)
+		entries, err := os.ReadDir(blobDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || strings.HasPrefix(name, tmpBlobPrefix) || !isContentHash(name) {
+				continue
+			}
+			report.Files++
+			p := filepath.Join(blobDir, name)
+			fi, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			sharedFi, err := os.Stat(fs.sharedBlobPath(name))
+			if err == nil && os.SameFile(fi, sharedFi) {
+				continue
+			}
+			exists := err == nil || seen[name]
+
+			// the data keys are per user
+			if encrypted, _, err := encryptedKeyID(p); err != nil || encrypted {
+				report.Skipped++
+				continue
+			}
+			hash, err := fs.fileHash(u.ID, p)
+			if err != nil {
+				return report, err
+			}
+			if hash != name {
+				log.Warnf("dedup: %s/%s doesn't match its content, skipped", u.ID, name)
+				report.Skipped++
+				continue
+			}
+
+			if dryRun {
+				seen[name] = true
+				if exists {
+					report.Linked++
+					report.SavedBytes += fi.Size()
+				} else {
+					report.Added++
+				}
+				continue
+			}
+			replaced, err := fs.shareBlob(p, name)
+			if err != nil {
+				return report, err
+			}
+			if replaced {
+				report.Linked++
+				report.SavedBytes += fi.Size()
+			} else {
+				report.Added++
+			}
+		}
+	}
+	log.Infof("dedup: %d blobs, %d linked, %d added, %d skipped, saved %d bytes",
+		report.Files, report.Linked, report.Added, report.Skipped, report.SavedBytes)
+	return report, nil
+}
+
+// fileHash the sha256 of the content of a blob file, compressed or not
+func (fs *FileSystemStorage) fileHash(uid, p string) (string, error) {
+	f, _, _, err := fs.openBlobFile(uid, p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// dedupSupported the shared blobs need the local file system
+func (fs *FileSystemStorage) dedupSupported() bool {
+	_, local := fs.blobs.(*localBlobs)
+	return fs.Cfg.BlobDedup && local
+}
@@ -0,0 +1,89 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobDedup(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, HashSchemaVersion: "3"}
+	fs := NewStorage(cfg)
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		if err = os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, fs.UpdateUser(&model.User{ID: uid, Sync15: true}))
+	}
+	content := "%PDF-1.4 the same pdf"
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	blobPath := func(uid string) string {
+		return fs.getUserBlobPath(uid) + "/" + hash
+	}
+	sameFile := func(a, b string) bool {
+		fa, err := os.Stat(a)
+		assert.NoError(t, err)
+		fb, err := os.Stat(b)
+		assert.NoError(t, err)
+		return os.SameFile(fa, fb)
+	}
+
+	// stored before the dedup was enabled
+	_, err = fs.blobs.StoreBlob("carol", hash, strings.NewReader(content), 0)
+	assert.NoError(t, err)
+
+	cfg.BlobDedup = true
+	_, err = fs.blobs.StoreBlob("alice", hash, strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	_, err = fs.blobs.StoreBlob("bob", hash, strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	assert.True(t, sameFile(blobPath("alice"), blobPath("bob")))
+	assert.True(t, sameFile(blobPath("alice"), fs.sharedBlobPath(hash)))
+
+	// someone else's hash with other content is never shared
+	_, err = fs.blobs.StoreBlob("bob", strings.Repeat("a", 64), strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	_, err = os.Stat(fs.sharedBlobPath(strings.Repeat("a", 64)))
+	assert.True(t, os.IsNotExist(err))
+
+	report, err := fs.DedupBlobs(true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Linked)
+	assert.Equal(t, 1, report.Skipped)
+	assert.False(t, sameFile(blobPath("carol"), blobPath("alice")), "dry run")
+	report, err = fs.DedupBlobs(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Linked)
+	assert.Equal(t, int64(len(content)), report.SavedBytes)
+	assert.True(t, sameFile(blobPath("carol"), blobPath("alice")))
+
+	// removing the blob of a user keeps the others
+	assert.NoError(t, fs.blobs.DeleteBlob("alice", hash))
+	assert.NoError(t, os.RemoveAll(fs.getUserPath("carol")))
+	gc, err := fs.CollectSharedBlobs(GCOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, gc.Swept)
+	r, _, _, _, err := fs.blobs.LoadBlob("bob", hash)
+	if assert.NoError(t, err) {
+		r.Close()
+	}
+
+	assert.NoError(t, fs.blobs.DeleteBlob("bob", hash))
+	gc, err = fs.CollectSharedBlobs(GCOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{hash}, gc.Swept)
+	_, err = os.Stat(fs.sharedBlobPath(hash))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -2,6 +2,7 @@ package fs
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -70,7 +71,7 @@ func (fs *FileSystemStorage) CreateFolder(uid, name, parent string) (*storage.Do
 	}
 
 	metafilePath := fs.getPathFromUser(uid, docID+storage.MetadataFileExt)
-	err = os.WriteFile(metafilePath, jsn, 0600)
+	err = fs.writeUserFile(uid, fs.getUserPath(uid), metafilePath, bytes.NewReader(jsn))
 
 	if err != nil {
 		return nil, err
@@ -78,7 +79,7 @@ func (fs *FileSystemStorage) CreateFolder(uid, name, parent string) (*storage.Do
 
 	//create zip from pdf
 	zipfile := fs.getPathFromUser(uid, docID+storage.ZipFileExt)
-	file, err := os.Create(zipfile)
+	file, err := fs.createUserFile(uid, zipfile)
 	if err != nil {
 		return nil, err
 	}
@@ -139,11 +140,17 @@ func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream
 	}
 	//create zip from pdf
 	zipfile := fs.getPathFromUser(uid, docid+storage.ZipFileExt)
-	file, err := os.Create(zipfile)
+	file, err := fs.createUserFile(uid, zipfile)
 	if err != nil {
 		return
 	}
 	defer file.Close()
+	// don't leave a partial upload behind
+	defer func() {
+		if err != nil {
+			os.Remove(zipfile)
+		}
+	}()
 
 	if !isZip {
 		w := zip.NewWriter(file)
@@ -199,7 +206,7 @@ func (fs *FileSystemStorage) CreateDocument(uid, filename, parent string, stream
 	}
 	//save metadata
 	metafilePath := fs.getPathFromUser(uid, docid+storage.MetadataFileExt)
-	err = os.WriteFile(metafilePath, jsn, 0600)
+	err = fs.writeUserFile(uid, fs.getUserPath(uid), metafilePath, bytes.NewReader(jsn))
 	return
 }
 
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -16,6 +18,7 @@ import (
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/crypt"
 	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
 )
 
@@ -30,6 +33,19 @@ const (
 // FileSystemStorage store everything to disk
 type FileSystemStorage struct {
 	Cfg *config.Config
+	// the user profiles, yaml files or a database
+	users userBackend
+	// sync15 blobs, on disk unless configured otherwise
+	blobs BlobBackend
+	// per user locks of the search index
+	searchLocks sync.Map
+	// the versions of the documents, read from the root history
+	versionCache sync.Map
+	// the space used by the users, for the quota
+	usages sync.Map
+	// the unwrapped data keys of the users
+	keyrings map[string]*crypt.Keyring
+	keysLock sync.Mutex
 }
 
 func sanitizeFileName(fileName string) string {
@@ -51,15 +67,13 @@ func (fs *FileSystemStorage) getPathFromUser(uid, path string) string {
 
 // ExportDocument Exports a document to the outputType
 func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOption storage.ExportOption) (io.ReadCloser, error) {
+	if exporter.IsImageFormat(outputType) {
+		return fs.ExportDocumentPages(uid, id, outputType, 0)
+	}
 	if outputType != "pdf" {
 		return nil, errors.New("todo: only pdfs supported")
 	}
 
-	cacheDirPath := fs.getPathFromUser(uid, CacheDir)
-	err := os.MkdirAll(cacheDirPath, 0700)
-	if err != nil {
-		return nil, err
-	}
 	sanitizedID := common.Sanitize(id)
 
 	zipFilePath := fs.getPathFromUser(uid, sanitizedID+storage.ZipFileExt)
@@ -69,6 +83,25 @@ func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOp
 		return nil, fmt.Errorf("cant find raw document %v", err)
 	}
 
+	// the cached pdf would be plaintext
+	if fs.encryptionEnabled() {
+		arch, err := fs.readArchive(uid, zipFilePath)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = exporter.RenderRmapi(arch, &buf); err != nil {
+			return nil, err
+		}
+		return exporter.NewSeekCloser(buf.Bytes()), nil
+	}
+
+	cacheDirPath := fs.getPathFromUser(uid, CacheDir)
+	err = os.MkdirAll(cacheDirPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+
 	outputFilePath := path.Join(cacheDirPath, sanitizedID+"-annotated.pdf")
 	outStat, err := os.Stat(outputFilePath)
 
@@ -77,22 +110,11 @@ func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOp
 		return os.Open(outputFilePath)
 	}
 
-	size := rawStat.Size()
-	arch := &exporter.MyArchive{}
-	zipFile, err := os.Open(zipFilePath)
-	if err != nil {
-		return nil, err
-	}
-	defer zipFile.Close()
-	err = arch.Read(zipFile, size)
+	arch, err := fs.readArchive(uid, zipFilePath)
 	if err != nil {
 		return nil, err
 	}
 
-	if arch.Payload != nil {
-		arch.PayloadReader = exporter.NewSeekCloser(arch.Payload)
-	}
-
 	outputFile, err := os.Create(outputFilePath)
 	if err != nil {
 		return nil, err
@@ -112,12 +134,56 @@ func (fs *FileSystemStorage) ExportDocument(uid, id, outputType string, exportOp
 
 }
 
+func (fs *FileSystemStorage) readArchive(uid, zipFilePath string) (*exporter.MyArchive, error) {
+	arch := &exporter.MyArchive{}
+	zipFile, err := fs.openUserFile(uid, zipFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zipFile.Close()
+	err = arch.Read(zipFile, zipFile.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	if arch.Payload != nil {
+		arch.PayloadReader = exporter.NewSeekCloser(arch.Payload)
+	}
+	return arch, nil
+}
+
+// renderPages renders the pages of the archive in the background
+func renderPages(arch *exporter.MyArchive, format string, page int) (io.ReadCloser, error) {
+	if page < 0 || page > len(arch.Pages) {
+		arch.Close()
+		return nil, exporter.ErrorNoSuchPage
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		defer arch.Close()
+		writer.CloseWithError(exporter.RenderPages(arch, format, page, writer))
+	}()
+	return reader, nil
+}
+
+// ExportDocumentPages renders a page (starting from 1) as svg or png, or all of them in a zip when page is 0
+func (fs *FileSystemStorage) ExportDocumentPages(uid, id, format string, page int) (io.ReadCloser, error) {
+	zipFilePath := fs.getPathFromUser(uid, common.Sanitize(id)+storage.ZipFileExt)
+	if _, err := os.Stat(zipFilePath); err != nil {
+		return nil, fmt.Errorf("cant find raw document %v", err)
+	}
+	arch, err := fs.readArchive(uid, zipFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return renderPages(arch, format, page)
+}
+
 // GetDocument Opens a document by id
 func (fs *FileSystemStorage) GetDocument(uid, id string) (io.ReadCloser, error) {
 	fullPath := fs.getPathFromUser(uid, id+storage.ZipFileExt)
 	log.Debugln("Fullpath:", fullPath)
-	reader, err := os.Open(fullPath)
-	return reader, err
+	return fs.openUserFile(uid, fullPath)
 }
 
 // RemoveDocument removes document (moves it to trash)
@@ -149,13 +215,9 @@ func (fs *FileSystemStorage) RemoveDocument(uid, id string) error {
 // StoreDocument stores a document
 func (fs *FileSystemStorage) StoreDocument(uid, id string, stream io.ReadCloser) error {
 	fullPath := fs.getPathFromUser(uid, id+storage.ZipFileExt)
-	file, err := os.Create(fullPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	_, err = io.Copy(file, stream)
-	return err
+	// a failed upload keeps the previous version
+	defer fs.usageChanged(uid)
+	return fs.writeUserFile(uid, fs.getUserPath(uid), fullPath, stream)
 }
 
 // GetStorageURL the storage url
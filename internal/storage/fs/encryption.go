@@ -0,0 +1,599 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/crypt"
+	log "github.com/sirupsen/logrus"
+)
+
+// dataKeysName the data keys of a user, wrapped with the master key, in the user dir.
+// Removing the user removes the keys, so whatever is left (eg backups) can't be read anymore
+const dataKeysName = ".datakeys"
+
+// dataKeys the content of the data keys file
+type dataKeys struct {
+	// Current the id of the key new data is encrypted with
+	Current uint32       `json:"current"`
+	Keys    []wrappedKey `json:"keys"`
+}
+
+type wrappedKey struct {
+	ID uint32 `json:"id"`
+	// Master the id of the master key it is wrapped with
+	Master uint32 `json:"master"`
+	Key    string `json:"key"`
+}
+
+// EncryptOptions what EncryptDataDir does
+type EncryptOptions struct {
+	// RotateDataKeys new data keys for the users, everything is encrypted again
+	RotateDataKeys bool
+	DryRun         bool
+}
+
+// EncryptReport the result of encrypting the data dir
+type EncryptReport struct {
+	Users int
+	// Rewrapped data keys wrapped with the current master key now
+	Rewrapped int
+	// Files documents, blobs and indexes seen
+	Files int
+	// Encrypted files (would be) encrypted now, with the current data key
+	Encrypted int
+	// Profiles saved with the secrets encrypted
+	Profiles int
+	// Shared plaintext shared blobs (which would be) removed
+	Shared int
+}
+
+// userFile a file of a user, decrypted if it is encrypted
+type userFile interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Size() int64
+}
+
+type plainFile struct {
+	*os.File
+	size int64
+}
+
+func (f *plainFile) Size() int64 {
+	return f.size
+}
+
+type decryptedFile struct {
+	*crypt.Reader
+	file *os.File
+}
+
+func (f *decryptedFile) Close() error {
+	return f.file.Close()
+}
+
+// encryptingFile closes the encryption and the file
+type encryptingFile struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (f *encryptingFile) Close() error {
+	err := f.WriteCloser.Close()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (fs *FileSystemStorage) encryptionEnabled() bool {
+	return len(fs.Cfg.EncryptionKey) > 0
+}
+
+// masterKey a configured master key by id
+func (fs *FileSystemStorage) masterKey(id uint32) ([]byte, bool) {
+	for _, k := range append([][]byte{fs.Cfg.EncryptionKey}, fs.Cfg.EncryptionOldKeys...) {
+		if len(k) > 0 && crypt.KeyID(k) == id {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+func (fs *FileSystemStorage) readDataKeys(uid string) (*dataKeys, error) {
+	b, err := os.ReadFile(fs.getPathFromUser(uid, dataKeysName))
+	if err != nil {
+		return nil, err
+	}
+	dk := &dataKeys{}
+	if err = json.Unmarshal(b, dk); err != nil {
+		return nil, fmt.Errorf("broken data keys of %s: %w", uid, err)
+	}
+	return dk, nil
+}
+
+func (fs *FileSystemStorage) writeDataKeys(uid string, dk *dataKeys) error {
+	b, err := json.Marshal(dk)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.getUserPath(uid), fs.getPathFromUser(uid, dataKeysName), bytes.NewReader(b))
+}
+
+// addDataKey a new random data key, wrapped with the current master key, becomes the current one
+func (fs *FileSystemStorage) addDataKey(dk *dataKeys) error {
+	key, err := crypt.NewKey()
+	if err != nil {
+		return err
+	}
+	wrapped, err := crypt.Wrap(fs.Cfg.EncryptionKey, key)
+	if err != nil {
+		return err
+	}
+	dk.Current = crypt.KeyID(key)
+	dk.Keys = append(dk.Keys, wrappedKey{ID: dk.Current, Master: crypt.KeyID(fs.Cfg.EncryptionKey), Key: wrapped})
+	return nil
+}
+
+func (fs *FileSystemStorage) unwrapDataKeys(uid string, dk *dataKeys) (*crypt.Keyring, error) {
+	var current []byte
+	others := [][]byte{}
+	for _, w := range dk.Keys {
+		master, ok := fs.masterKey(w.Master)
+		if !ok {
+			return nil, fmt.Errorf("the data keys of %s are wrapped with an unknown master key %08x", uid, w.Master)
+		}
+		key, err := crypt.Unwrap(master, w.Key)
+		if err != nil {
+			return nil, fmt.Errorf("data key %08x of %s: %w", w.ID, uid, err)
+		}
+		if w.ID == dk.Current {
+			current = key
+		} else {
+			others = append(others, key)
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("the current data key of %s is missing", uid)
+	}
+	return crypt.NewKeyring(current, others...), nil
+}
+
+// keyring the data keys of a user, created if create is set and there are none.
+// Returns nil if there are none
+func (fs *FileSystemStorage) keyring(uid string, create bool) (*crypt.Keyring, error) {
+	fs.keysLock.Lock()
+	defer fs.keysLock.Unlock()
+	if keys, ok := fs.keyrings[uid]; ok {
+		return keys, nil
+	}
+	if !fs.encryptionEnabled() {
+		return nil, fmt.Errorf("the data of %s is encrypted but the encryption key is not set", uid)
+	}
+
+	dk, err := fs.readDataKeys(uid)
+	if os.IsNotExist(err) {
+		if !create {
+			return nil, nil
+		}
+		dk = &dataKeys{}
+		if err = fs.addDataKey(dk); err != nil {
+			return nil, err
+		}
+		if err = fs.writeDataKeys(uid, dk); err != nil {
+			return nil, err
+		}
+		log.Info("created the data key of ", uid)
+	} else if err != nil {
+		return nil, err
+	}
+
+	keys, err := fs.unwrapDataKeys(uid, dk)
+	if err != nil {
+		return nil, err
+	}
+	if fs.keyrings == nil {
+		fs.keyrings = map[string]*crypt.Keyring{}
+	}
+	fs.keyrings[uid] = keys
+	return keys, nil
+}
+
+// readHeader the first n bytes of a file, shorter if the file is
+func readHeader(p string, n int) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header := make([]byte, n)
+	c, err := io.ReadFull(f, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return header[:c], err
+}
+
+// encryptedKeyID if the file is encrypted, and the id of the data key
+func encryptedKeyID(p string) (bool, uint32, error) {
+	header, err := readHeader(p, crypt.HeaderSize)
+	if err != nil || !crypt.IsEncrypted(header) {
+		return false, 0, err
+	}
+	return true, crypt.HeaderKeyID(header), nil
+}
+
+// openUserFile opens a file of a user, decrypting it if it is encrypted
+func (fs *FileSystemStorage) openUserFile(uid, p string) (userFile, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	header := make([]byte, crypt.HeaderSize)
+	if _, err = file.ReadAt(header, 0); err != nil || !crypt.IsEncrypted(header) {
+		return &plainFile{File: file, size: fi.Size()}, nil
+	}
+
+	keys, err := fs.keyring(uid, false)
+	if err == nil && keys == nil {
+		err = crypt.ErrorNoKey
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := crypt.NewReader(file, fi.Size(), keys)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+	}
+	return &decryptedFile{Reader: reader, file: file}, nil
+}
+
+// createUserFile creates a file of a user, encrypted if enabled
+func (fs *FileSystemStorage) createUserFile(uid, p string) (io.WriteCloser, error) {
+	if !fs.encryptionEnabled() {
+		return os.Create(p)
+	}
+	keys, err := fs.keyring(uid, true)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	w, err := crypt.NewWriter(file, keys)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &encryptingFile{WriteCloser: w, file: file}, nil
+}
+
+// writeUserFile writes a file of a user atomically, encrypted if enabled
+func (fs *FileSystemStorage) writeUserFile(uid, dir, dest string, reader io.Reader) error {
+	if !fs.encryptionEnabled() {
+		return writeFileAtomic(dir, dest, reader)
+	}
+	keys, err := fs.keyring(uid, true)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicFunc(dir, dest, func(w io.Writer) error {
+		enc, err := crypt.NewWriter(w, keys)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(enc, reader); err != nil {
+			return err
+		}
+		return enc.Close()
+	})
+}
+
+// encryptFile encrypts a file of a user again, with the current data key
+func (fs *FileSystemStorage) encryptFile(uid, p string) error {
+	src, err := fs.openUserFile(uid, p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return fs.writeUserFile(uid, filepath.Dir(p), p, src)
+}
+
+// encryptUser a copy of the user with the secrets encrypted
+func (fs *FileSystemStorage) encryptUser(u *model.User) (*model.User, error) {
+	if !fs.encryptionEnabled() {
+		return u, nil
+	}
+	keys, err := fs.keyring(u.ID, true)
+	if err != nil {
+		return nil, err
+	}
+	c := *u
+	c.Integrations = append([]model.IntegrationConfig(nil), u.Integrations...)
+	for _, s := range userSecrets(&c) {
+		if *s, err = crypt.EncryptString(keys, *s); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// decryptUser decrypts the secrets of the user in place
+func (fs *FileSystemStorage) decryptUser(u *model.User) error {
+	var keys *crypt.Keyring
+	for _, s := range userSecrets(u) {
+		if !crypt.IsEncryptedString(*s) {
+			continue
+		}
+		if keys == nil {
+			var err error
+			if keys, err = fs.keyring(u.ID, false); err != nil {
+				return err
+			}
+		}
+		plain, err := crypt.DecryptString(keys, *s)
+		if err != nil {
+			return fmt.Errorf("the secrets of %s: %w", u.ID, err)
+		}
+		*s = plain
+	}
+	return nil
+}
+
+// userSecrets the profile fields kept encrypted
+func userSecrets(u *model.User) []*string {
+	secrets := []*string{&u.TOTPSecret}
+	for i := range u.Integrations {
+		secrets = append(secrets, &u.Integrations[i].Password, &u.Integrations[i].Accesstoken)
+	}
+	return secrets
+}
+
+// rewrapDataKeys wraps the data keys of a user with the current master key, adding a new data key if rotate is set.
+// Returns the number of keys wrapped again
+func (fs *FileSystemStorage) rewrapDataKeys(uid string, rotate bool) (int, error) {
+	if _, err := fs.keyring(uid, true); err != nil {
+		return 0, err
+	}
+	fs.keysLock.Lock()
+	defer fs.keysLock.Unlock()
+	dk, err := fs.readDataKeys(uid)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := fs.unwrapDataKeys(uid, dk)
+	if err != nil {
+		return 0, err
+	}
+
+	masterID := crypt.KeyID(fs.Cfg.EncryptionKey)
+	rewrapped := 0
+	for i, w := range dk.Keys {
+		if w.Master == masterID {
+			continue
+		}
+		key, _ := keys.Key(w.ID)
+		if dk.Keys[i].Key, err = crypt.Wrap(fs.Cfg.EncryptionKey, key); err != nil {
+			return 0, err
+		}
+		dk.Keys[i].Master = masterID
+		rewrapped++
+	}
+	if rotate {
+		if err = fs.addDataKey(dk); err != nil {
+			return 0, err
+		}
+	}
+	if rewrapped == 0 && !rotate {
+		return 0, nil
+	}
+	if err = fs.writeDataKeys(uid, dk); err != nil {
+		return 0, err
+	}
+	delete(fs.keyrings, uid)
+	return rewrapped, nil
+}
+
+// dropOldDataKeys keeps only the current data key, once nothing is encrypted with the others
+func (fs *FileSystemStorage) dropOldDataKeys(uid string) error {
+	fs.keysLock.Lock()
+	defer fs.keysLock.Unlock()
+	dk, err := fs.readDataKeys(uid)
+	if err != nil {
+		return err
+	}
+	keys := dk.Keys[:0]
+	for _, w := range dk.Keys {
+		if w.ID == dk.Current {
+			keys = append(keys, w)
+		}
+	}
+	dk.Keys = keys
+	delete(fs.keyrings, uid)
+	return fs.writeDataKeys(uid, dk)
+}
+
+// userDataFiles the files of a user which are encrypted: documents and their metadata, blobs, the search index and the tree cache
+func (fs *FileSystemStorage) userDataFiles(uid string) ([]string, error) {
+	files := []string{}
+	userPath := fs.getUserPath(uid)
+	for _, dir := range []string{userPath, fs.getPathFromUser(uid, DefaultTrashDir), fs.getUserBlobPath(uid)} {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobs := dir == fs.getUserBlobPath(uid)
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || strings.HasPrefix(name, tmpBlobPrefix) {
+				continue
+			}
+			if blobs && (name == rootBlob || name == historyFile) {
+				continue
+			}
+			document := strings.HasSuffix(name, storage.ZipFileExt) || strings.HasSuffix(name, storage.MetadataFileExt)
+			if !blobs && !document && !(dir == userPath && (name == searchIndexName || name == cachedTreeName)) {
+				continue
+			}
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files, nil
+}
+
+// EncryptDataDir encrypts the documents, blobs and profile secrets of all users in place.
+// The data keys wrapped with an old master key are wrapped with the current one,
+// so it is also how the master key is rotated.
+// Files already encrypted with the current data key are skipped, so it can be run again
+func (fs *FileSystemStorage) EncryptDataDir(opts EncryptOptions) (*EncryptReport, error) {
+	if !fs.encryptionEnabled() {
+		return nil, errors.New("the encryption key is not set")
+	}
+	users, err := fs.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	report := &EncryptReport{}
+	compress := fs.Cfg.BlobCompression != ""
+	for _, u := range users {
+		report.Users++
+		if !opts.DryRun {
+			n, err := fs.rewrapDataKeys(u.ID, opts.RotateDataKeys)
+			if err != nil {
+				return report, err
+			}
+			report.Rewrapped += n
+		}
+		var current uint32
+		if keys, err := fs.keyring(u.ID, false); err == nil && keys != nil {
+			current, _ = keys.Current()
+		}
+
+		files, err := fs.userDataFiles(u.ID)
+		if err != nil {
+			return report, err
+		}
+		for _, p := range files {
+			report.Files++
+			encrypted, id, err := encryptedKeyID(p)
+			if err != nil {
+				return report, err
+			}
+			if encrypted && id == current {
+				continue
+			}
+			report.Encrypted++
+			if opts.DryRun {
+				continue
+			}
+			// compressed first, it doesn't compress afterwards
+			if !encrypted && compress && filepath.Dir(p) == fs.getUserBlobPath(u.ID) {
+				err = fs.encryptBlobFile(u.ID, p)
+			} else {
+				err = fs.encryptFile(u.ID, p)
+			}
+			if err != nil {
+				return report, fmt.Errorf("%s: %w", p, err)
+			}
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		// the rendered pdfs
+		if err = os.RemoveAll(fs.getPathFromUser(u.ID, CacheDir)); err != nil {
+			return report, err
+		}
+		// read again, the secrets might be encrypted with an old data key
+		user, err := fs.GetUser(u.ID)
+		if err != nil {
+			return report, err
+		}
+		if err = fs.UpdateUser(user); err != nil {
+			return report, err
+		}
+		report.Profiles++
+		if opts.RotateDataKeys {
+			if err = fs.dropOldDataKeys(u.ID); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// every user has an encrypted copy of its blobs now
+	if report.Shared, err = fs.removeSharedBlobs(opts.DryRun); err != nil {
+		return report, err
+	}
+	log.Infof("encrypt: %d users, %d keys rewrapped, %d files, %d encrypted, %d profiles, %d shared blobs removed",
+		report.Users, report.Rewrapped, report.Files, report.Encrypted, report.Profiles, report.Shared)
+	return report, nil
+}
+
+// removeSharedBlobs removes the plaintext shared copies of the blobs, the dedup is off with the encryption.
+// A copy still linked by a user is kept. Returns the number of copies (which would be) removed
+func (fs *FileSystemStorage) removeSharedBlobs(dryRun bool) (int, error) {
+	removed := 0
+	root := filepath.Join(fs.Cfg.DataDir, sharedBlobDir)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			// removed in the meantime
+			return nil
+		}
+		// the users are still linked before the encryption
+		if dryRun {
+			removed++
+			return nil
+		}
+		if links, ok := linkCount(fi); ok && links > 1 {
+			log.Warnf("encrypt: shared blob %s is still linked, kept", d.Name())
+			return nil
+		}
+		if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// encryptBlobFile encrypts a plaintext blob file, compressed first if it is worth it
+func (fs *FileSystemStorage) encryptBlobFile(uid, p string) error {
+	src, _, _, err := fs.openBlobFile(uid, p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	content, err := fs.blobContent(src)
+	if err != nil {
+		return err
+	}
+	return fs.writeUserFile(uid, filepath.Dir(p), p, content)
+}
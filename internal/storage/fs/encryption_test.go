@@ -0,0 +1,204 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage/crypt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryption(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, HashSchemaVersion: "3"}
+	fs := NewStorage(cfg)
+	uid := "alice"
+	user := &model.User{ID: uid, Sync15: true, Integrations: []model.IntegrationConfig{{ID: "1", Provider: "webdav", Password: "hunter2"}}}
+	assert.NoError(t, fs.UpdateUser(user))
+
+	content := strings.Repeat("a page with some strokes ", 100)
+	plaintextOnDisk := func(p string) bool {
+		b, err := os.ReadFile(p)
+		assert.NoError(t, err)
+		return strings.Contains(string(b), "strokes") || strings.Contains(string(b), "hunter2")
+	}
+	load := func(fs *FileSystemStorage, id string) string {
+		r, _, size, _, err := fs.blobs.LoadBlob(uid, id)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), size)
+		return string(data)
+	}
+	document := func(fs *FileSystemStorage) string {
+		r, err := fs.GetDocument(uid, "doc")
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	// before the encryption was enabled
+	_, err = fs.blobs.StoreBlob(uid, "old", strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	assert.NoError(t, fs.StoreDocument(uid, "doc", io.NopCloser(strings.NewReader(content))))
+	assert.NoError(t, fs.UpdateMetadata(uid, &messages.RawMetadata{ID: "doc", VissibleName: "notes with strokes"}))
+	assert.True(t, plaintextOnDisk(fs.getUserBlobPath(uid)+"/old"))
+	assert.True(t, plaintextOnDisk(fs.getPathFromUser(uid, "doc.metadata")))
+
+	master, _ := crypt.NewKey()
+	cfg.EncryptionKey = master
+	_, err = fs.blobs.StoreBlob(uid, "new", strings.NewReader(content), 0)
+	assert.NoError(t, err)
+	assert.False(t, plaintextOnDisk(fs.getUserBlobPath(uid)+"/new"))
+	folder, err := fs.CreateFolder(uid, "folder with strokes", "")
+	assert.NoError(t, err)
+	assert.False(t, plaintextOnDisk(fs.getPathFromUser(uid, folder.ID+".metadata")))
+	assert.Equal(t, content, load(fs, "new"))
+	assert.Equal(t, content, load(fs, "old"), "side by side")
+
+	report, err := fs.EncryptDataDir(EncryptOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, report.Files)
+	assert.Equal(t, 3, report.Encrypted)
+
+	report, err = fs.EncryptDataDir(EncryptOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Encrypted)
+	assert.Equal(t, 1, report.Profiles)
+	assert.False(t, plaintextOnDisk(fs.getUserBlobPath(uid)+"/old"))
+	assert.False(t, plaintextOnDisk(fs.getPathFromUser(uid, "doc.zip")))
+	assert.False(t, plaintextOnDisk(fs.getPathFromUser(uid, "doc.metadata")))
+	meta, err := fs.GetMetadata(uid, "doc")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "notes with strokes", meta.VissibleName)
+	}
+	assert.False(t, plaintextOnDisk(fs.users.(*ProfileStore).profilePath(uid)))
+	assert.Equal(t, content, document(fs))
+	u, err := fs.GetUser(uid)
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", u.Integrations[0].Password)
+
+	// a new master key, the old one until the data keys are rewrapped
+	newMaster, _ := crypt.NewKey()
+	cfg.EncryptionKey = newMaster
+	cfg.EncryptionOldKeys = [][]byte{master}
+	fs = NewStorage(cfg)
+	report, err = fs.EncryptDataDir(EncryptOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Rewrapped)
+	assert.Equal(t, 0, report.Encrypted)
+
+	cfg.EncryptionOldKeys = nil
+	fs = NewStorage(cfg)
+	assert.Equal(t, content, load(fs, "old"))
+
+	// new data keys, everything is encrypted again
+	report, err = fs.EncryptDataDir(EncryptOptions{RotateDataKeys: true})
+	assert.NoError(t, err)
+	assert.Equal(t, report.Files, report.Encrypted)
+	fs = NewStorage(cfg)
+	dk, err := fs.readDataKeys(uid)
+	assert.NoError(t, err)
+	assert.Len(t, dk.Keys, 1)
+	assert.Equal(t, content, load(fs, "new"))
+	assert.Equal(t, content, document(fs))
+	u, err = fs.GetUser(uid)
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", u.Integrations[0].Password)
+
+	// without the key
+	cfg.EncryptionKey = nil
+	fs = NewStorage(cfg)
+	_, err = fs.GetDocument(uid, "doc")
+	assert.Error(t, err)
+}
+
+func TestEncryptionSharedBlobs(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, HashSchemaVersion: "3", BlobDedup: true, BlobCompression: config.BlobCompressionZstd}
+	fs := NewStorage(cfg)
+	content := strings.Repeat("a page with some strokes ", 100)
+	sum := sha256.Sum256([]byte(content))
+	hash := hex.EncodeToString(sum[:])
+	for _, uid := range []string{"alice", "bob"} {
+		if err = os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, fs.UpdateUser(&model.User{ID: uid, Sync15: true}))
+		_, err = fs.blobs.StoreBlob(uid, hash, strings.NewReader(content), 0)
+		assert.NoError(t, err)
+	}
+	_, err = fs.CreateBlobFolder("alice", "notes", "")
+	assert.NoError(t, err)
+	assert.FileExists(t, fs.sharedBlobPath(hash))
+	shared, err := filepath.Glob(filepath.Join(dir, sharedBlobDir, "*", "*"))
+	assert.NoError(t, err)
+	encrypted := func(p string) bool {
+		ok, _, err := encryptedKeyID(p)
+		assert.NoError(t, err)
+		return ok
+	}
+	treePath := fs.getPathFromUser("alice", cachedTreeName)
+	assert.False(t, encrypted(treePath))
+
+	// the dedup is off with the encryption
+	cfg.EncryptionKey, _ = crypt.NewKey()
+	cfg.BlobDedup = false
+	report, err := fs.EncryptDataDir(EncryptOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, len(shared), report.Shared)
+	assert.FileExists(t, fs.sharedBlobPath(hash), "dry run")
+
+	report, err = fs.EncryptDataDir(EncryptOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, len(shared), report.Shared)
+	assert.NoFileExists(t, fs.sharedBlobPath(hash), "no plaintext copy left")
+	assert.True(t, encrypted(treePath))
+	for _, uid := range []string{"alice", "bob"} {
+		assert.True(t, encrypted(fs.getUserBlobPath(uid)+"/"+hash))
+		r, _, _, _, err := fs.blobs.LoadBlob(uid, hash)
+		if assert.NoError(t, err) {
+			data, _ := io.ReadAll(r)
+			r.Close()
+			assert.Equal(t, content, string(data))
+		}
+	}
+	tree, err := fs.GetCachedTree("alice")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Docs, 1)
+
+	// the uploads are encrypted while they are written
+	_, err = fs.CreateBlobDocument("alice", "doc.pdf", "", strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(fs.getUserBlobPath("alice"))
+	assert.NoError(t, err)
+	for _, e := range entries {
+		if e.Name() == rootBlob || e.Name() == historyFile {
+			continue
+		}
+		assert.True(t, encrypted(fs.getUserBlobPath("alice")+"/"+e.Name()), e.Name())
+	}
+	assert.True(t, encrypted(treePath))
+}
@@ -0,0 +1,148 @@
+package fs
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultGCGracePeriod blobs younger than this are never swept,
+// they can belong to a sync which has not updated the root yet
+const DefaultGCGracePeriod = time.Hour
+
+// ErrorRootChanged the root was updated during the garbage collection, it has to run again
+var ErrorRootChanged = errors.New("the root changed during the garbage collection")
+
+// GCOptions garbage collection options
+type GCOptions struct {
+	// DryRun only report what would be deleted
+	DryRun bool
+	// KeepHistory keep the blobs reachable from the last N roots in the history
+	KeepHistory int
+	// GracePeriod don't sweep blobs modified after now - GracePeriod
+	GracePeriod time.Duration
+}
+
+// GCReport the result of a garbage collection
+type GCReport struct {
+	UID string
+	// Reachable blobs reachable from the kept roots
+	Reachable int
+	// Recent unreachable blobs which were kept because of the grace period
+	Recent int
+	// Swept unreachable blobs (deleted unless dry run)
+	Swept []string
+	// SweptBytes the size of the swept blobs
+	SweptBytes int64
+}
+
+// CollectGarbage deletes the blobs of a user which are not reachable
+// from the current root or the last opts.KeepHistory roots
+func (fs *FileSystemStorage) CollectGarbage(uid string, opts GCOptions) (*GCReport, error) {
+	ls := fs.BlobStorage(uid)
+
+	// list first, anything uploaded after this is not touched
+	blobs, err := fs.blobs.ListBlobs(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	rootHash, rootGen, err := ls.GetRootIndex()
+	if err != nil {
+		return nil, err
+	}
+	report := &GCReport{UID: uid}
+	if rootHash == "" {
+		log.Infof("gc: %s has no root, skipping", uid)
+		return report, nil
+	}
+
+	marked := make(map[string]bool)
+	if err = models.MarkReachable(ls, ls, rootHash, marked); err != nil {
+		return nil, err
+	}
+
+	if opts.KeepHistory > 0 {
+		history, err := fs.blobs.RootHistory(uid)
+		if err != nil {
+			return nil, err
+		}
+		if len(history) > opts.KeepHistory {
+			history = history[len(history)-opts.KeepHistory:]
+		}
+		for _, h := range history {
+			if err = models.MarkReachable(ls, ls, h.Hash, marked); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !opts.DryRun {
+		// the old roots might be swept
+		defer fs.dropVersions(uid)
+	}
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	for _, b := range blobs {
+		if marked[b.ID] {
+			report.Reachable++
+			continue
+		}
+		if b.ModTime.After(cutoff) {
+			report.Recent++
+			continue
+		}
+		// leftovers of interrupted uploads are swept too
+		if !opts.DryRun {
+			// a root pushed meanwhile can reference the blob again, without uploading it
+			_, gen, err := ls.GetRootIndex()
+			if err != nil {
+				return report, err
+			}
+			if gen != rootGen {
+				return report, ErrorRootChanged
+			}
+			if err = fs.blobs.DeleteBlob(uid, b.ID); err != nil {
+				return report, err
+			}
+		}
+		report.Swept = append(report.Swept, b.ID)
+		report.SweptBytes += b.Size
+	}
+
+	verb := "deleted"
+	if opts.DryRun {
+		verb = "would delete"
+	}
+	log.Infof("gc: %s reachable: %d, recent: %d, %s %d blobs (%d bytes)",
+		uid, report.Reachable, report.Recent, verb, len(report.Swept), report.SweptBytes)
+	return report, nil
+}
+
+// CollectGarbageAll runs the garbage collection for all users
+func (fs *FileSystemStorage) CollectGarbageAll(opts GCOptions) ([]*GCReport, error) {
+	users, err := fs.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*GCReport, 0, len(users))
+	for _, u := range users {
+		report, err := fs.CollectGarbage(u.ID, opts)
+		if err != nil {
+			log.Errorf("gc: %s failed, %v", u.ID, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	// after the users, their deleted blobs unlink the shared copies
+	if fs.dedupSupported() {
+		report, err := fs.CollectSharedBlobs(opts)
+		if err != nil {
+			log.Errorf("gc: shared blobs failed, %v", err)
+		} else {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
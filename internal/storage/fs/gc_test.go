@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	blobDir := fs.getUserBlobPath("test")
+	if err = os.MkdirAll(blobDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.CreateBlobDocument("test", "first.pdf", "", strings.NewReader("%PDF-1.4"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	firstRoot, _, _ := fs.BlobStorage("test").GetRootIndex()
+	_, err = fs.CreateBlobDocument("test", "second.pdf", "", strings.NewReader("%PDF-1.5"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = fs.StoreBlob("test", "orphan", strings.NewReader("orphan"), -1)
+	assert.NoError(t, err)
+
+	opts := GCOptions{DryRun: true, GracePeriod: time.Hour}
+	report, err := fs.CollectGarbage("test", opts)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Swept, "everything is recent")
+
+	old := time.Now().Add(-2 * time.Hour)
+	entries, _ := os.ReadDir(blobDir)
+	for _, e := range entries {
+		os.Chtimes(path.Join(blobDir, e.Name()), old, old)
+	}
+
+	report, err = fs.CollectGarbage("test", opts)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{firstRoot, "orphan"}, report.Swept)
+	_, err = fs.StatBlob("test", "orphan")
+	assert.NoError(t, err, "dry run")
+
+	opts.DryRun = false
+	opts.KeepHistory = 2
+	report, err = fs.CollectGarbage("test", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orphan"}, report.Swept)
+	_, err = fs.StatBlob("test", "orphan")
+	assert.Equal(t, ErrorNotFound, err)
+
+	opts.KeepHistory = 0
+	report, err = fs.CollectGarbage("test", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{firstRoot}, report.Swept)
+
+	missing, err := fs.MissingBlobs("test")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+// racingBlobs pushes the root again when it is read for the second time
+type racingBlobs struct {
+	BlobBackend
+	rootReads int
+	push      func()
+}
+
+func (b *racingBlobs) LoadBlob(uid, blobID string) (io.ReadCloser, int64, int64, string, error) {
+	if blobID == rootBlob {
+		b.rootReads++
+		if b.rootReads == 2 {
+			b.push()
+		}
+	}
+	return b.BlobBackend.LoadBlob(uid, blobID)
+}
+
+func TestCollectGarbageRootChanged(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	blobDir := fs.getUserBlobPath("test")
+	if err = os.MkdirAll(blobDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.CreateBlobDocument("test", "first.pdf", "", strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	_, err = fs.StoreBlob("test", "orphan", strings.NewReader("orphan"), -1)
+	assert.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour)
+	entries, _ := os.ReadDir(blobDir)
+	for _, e := range entries {
+		os.Chtimes(path.Join(blobDir, e.Name()), old, old)
+	}
+
+	// a device pushes a root referencing the orphan, which it didn't upload again
+	local := fs.blobs
+	fs.blobs = &racingBlobs{BlobBackend: local, push: func() {
+		rootHash, gen, _ := fs.BlobStorage("test").GetRootIndex()
+		_, err := local.StoreBlob("test", rootBlob, strings.NewReader(rootHash), gen)
+		assert.NoError(t, err)
+	}}
+	_, err = fs.CollectGarbage("test", GCOptions{GracePeriod: time.Hour})
+	assert.ErrorIs(t, err, ErrorRootChanged)
+	_, err = fs.StatBlob("test", "orphan")
+	assert.NoError(t, err, "nothing deleted")
+}
@@ -0,0 +1,307 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	libraryVersion = 1
+	// the rmdoc of a folder, inside its directory
+	folderRmDoc = ".folder" + storage.RmDocFileExt
+	trashDir    = "trash"
+	// maxLibraryEntrySize an rmdoc, or the files in it, are unpacked in memory up to this size
+	maxLibraryEntrySize = 512 << 20
+)
+
+// ExportLibrary writes a zip with every document of a user as an rmdoc, in its folder, and a manifest
+func (fs *FileSystemStorage) ExportLibrary(uid string, w io.Writer) error {
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return err
+	}
+	blobStorage := fs.BlobStorage(uid)
+	paths := libraryPaths(tree.Docs)
+
+	manifest := storage.LibraryManifest{
+		Version:    libraryVersion,
+		User:       uid,
+		Created:    time.Now().UTC(),
+		Generation: tree.Generation,
+		Documents:  []storage.LibraryEntry{},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, d := range tree.Docs {
+		p := paths[d.EntryName]
+		// the rmdocs are zips already
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: p, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		rmdoc := exportRmDoc(d, blobStorage)
+		_, err = io.Copy(fw, rmdoc)
+		rmdoc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.EntryName, err)
+		}
+		manifest.Documents = append(manifest.Documents, storage.LibraryEntry{
+			ID:           d.EntryName,
+			Name:         d.DocumentName,
+			Type:         d.CollectionType,
+			Parent:       d.Parent,
+			Path:         p,
+			Version:      d.Version,
+			LastModified: d.LastModified,
+			Size:         d.Size,
+		})
+	}
+
+	fw, err := zw.Create(storage.LibraryManifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(&manifest); err != nil {
+		return err
+	}
+	log.Infof("exported %d documents of %s", len(manifest.Documents), uid)
+	return zw.Close()
+}
+
+// ImportLibrary adds the documents of a library archive, keeping their ids and folders.
+// Documents already in the tree are skipped, so it can be run again.
+func (fs *FileSystemStorage) ImportLibrary(uid string, r io.ReaderAt, size int64) (*storage.ImportReport, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	mf, ok := files[storage.LibraryManifestName]
+	if !ok {
+		return nil, errors.New("no " + storage.LibraryManifestName + " in the archive")
+	}
+	var manifest storage.LibraryManifest
+	if err = readZipJSON(mf, &manifest); err != nil {
+		return nil, fmt.Errorf("broken manifest: %w", err)
+	}
+	if manifest.Version > libraryVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	blobStorage := fs.BlobStorage(uid)
+	report := &storage.ImportReport{UID: uid}
+	left, err := fs.spaceLeft(uid)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.usageChanged(uid)
+
+	inArchive := map[string]bool{}
+	for _, e := range manifest.Documents {
+		inArchive[e.ID] = true
+	}
+
+	docs := []*models.HashDoc{}
+	for _, e := range manifest.Documents {
+		if _, err := tree.FindDoc(e.ID); err == nil {
+			report.Existing++
+			continue
+		}
+		problem := func(reason string) {
+			report.Problems = append(report.Problems, storage.MigrationProblem{ID: e.ID, Name: e.Name, Reason: reason})
+		}
+
+		f, ok := files[e.Path]
+		if !ok {
+			problem("missing " + e.Path)
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			problem(err.Error())
+			continue
+		}
+		size, err := unpackedSize(data)
+		if err != nil {
+			problem(err.Error())
+			continue
+		}
+		if left >= 0 {
+			if size > left {
+				problem(storage.ErrorQuotaExceeded.Error())
+				continue
+			}
+			left -= size
+		}
+		hashDoc, err := storeRmDoc(blobStorage, "", data)
+		if err != nil {
+			problem(err.Error())
+			continue
+		}
+		if hashDoc.EntryName != e.ID {
+			problem("the rmdoc is " + hashDoc.EntryName)
+			continue
+		}
+
+		parent := hashDoc.Parent
+		if parent != "" && parent != TrashParent && !inArchive[parent] {
+			if _, err := tree.FindDoc(parent); err != nil {
+				problem("parent " + parent + " not found, moved to the root")
+				hashDoc.Parent = ""
+				if err = writeMetadata(blobStorage, hashDoc); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		docs = append(docs, hashDoc)
+		if hashDoc.CollectionType == common.CollectionType {
+			report.Folders++
+		} else {
+			report.Documents++
+		}
+	}
+
+	if len(docs) == 0 {
+		return report, nil
+	}
+	// all in one generation
+	err = updateTree(tree, blobStorage, func(t *models.HashTree) error {
+		for _, d := range docs {
+			if _, err := t.FindDoc(d.EntryName); err == nil {
+				continue
+			}
+			t.Docs = append(t.Docs, d)
+		}
+		return t.Rehash()
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("imported %d documents and %d folders into %s", report.Documents, report.Folders, uid)
+	return report, nil
+}
+
+// libraryPaths the archive path of every document, following the folders
+func libraryPaths(docs []*models.HashDoc) map[string]string {
+	byID := map[string]*models.HashDoc{}
+	for _, d := range docs {
+		byID[d.EntryName] = d
+	}
+
+	dirs := map[string]string{}
+	used := map[string]bool{}
+	unique := func(p, id string) string {
+		if used[p] {
+			ext := path.Ext(p)
+			p = strings.TrimSuffix(p, ext) + " (" + id + ")" + ext
+		}
+		used[p] = true
+		return p
+	}
+
+	var dirOf func(id string, depth int) string
+	dirOf = func(id string, depth int) string {
+		if id == "" {
+			return ""
+		}
+		if id == TrashParent {
+			return trashDir
+		}
+		if dir, ok := dirs[id]; ok {
+			return dir
+		}
+		folder, ok := byID[id]
+		// a missing parent or a loop ends up at the root
+		if !ok || depth > len(docs) {
+			return ""
+		}
+		dir := unique(path.Join(dirOf(folder.Parent, depth+1), safeName(folder.DocumentName, id)), id)
+		dirs[id] = dir
+		return dir
+	}
+
+	paths := map[string]string{}
+	// the folders first, a document named .folder gets another name
+	for _, d := range docs {
+		if d.CollectionType == common.CollectionType {
+			p := path.Join(dirOf(d.EntryName, 0), folderRmDoc)
+			paths[d.EntryName] = p
+			used[p] = true
+		}
+	}
+	for _, d := range docs {
+		if d.CollectionType == common.CollectionType {
+			continue
+		}
+		p := path.Join(dirOf(d.Parent, 0), safeName(d.DocumentName, d.EntryName)+storage.RmDocFileExt)
+		paths[d.EntryName] = unique(p, d.EntryName)
+	}
+	return paths
+}
+
+// safeName a document name usable as a path element
+func safeName(name, id string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return id
+	}
+	return name
+}
+
+// unpackedSize the size of the files of an rmdoc, the zip reader fails on a file longer than its header says
+func unpackedSize(data []byte) (int64, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, f := range zr.File {
+		if f.UncompressedSize64 > maxLibraryEntrySize-size {
+			return 0, fmt.Errorf("%s: too large", f.Name)
+		}
+		size += f.UncompressedSize64
+	}
+	return int64(size), nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxLibraryEntrySize {
+		return nil, fmt.Errorf("%s: too large", f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func readZipJSON(f *zip.File, v interface{}) error {
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
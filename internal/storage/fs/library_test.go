@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLibraryExportImport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		if err = os.MkdirAll(fs.getUserBlobPath(uid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = fs.UpdateUser(&model.User{ID: uid, Sync15: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	folder, err := fs.CreateBlobFolder("alice", "work/notes", "")
+	assert.NoError(t, err)
+	doc, err := fs.CreateBlobDocument("alice", "doc.pdf", folder.ID, strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	_, err = fs.CreateBlobDocument("alice", "doc.pdf", folder.ID, strings.NewReader("%PDF-1.5"))
+	assert.NoError(t, err)
+	_, err = fs.CreateBlobDocument("alice", ".folder.pdf", folder.ID, strings.NewReader("%PDF-1.6"))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, fs.ExportLibrary("alice", &buf))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Contains(t, names, storage.LibraryManifestName)
+	assert.Contains(t, names, "work_notes/"+folderRmDoc)
+	assert.Contains(t, names, "work_notes/doc.rmdoc")
+	assert.Len(t, names, 5, "same names don't collide")
+	renamed := 0
+	for _, n := range names {
+		if strings.HasPrefix(n, "work_notes/.folder (") {
+			renamed++
+		}
+	}
+	assert.Equal(t, 1, renamed, "a document named .folder doesn't replace the folder")
+
+	report, err := fs.ImportLibrary("bob", bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Documents)
+	assert.Equal(t, 1, report.Folders)
+	assert.Empty(t, report.Problems)
+
+	tree, err := fs.GetCachedTree("bob")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Docs, 4)
+	imported, err := tree.FindDoc(doc.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, folder.ID, imported.Parent)
+	}
+	missing, err := fs.MissingBlobs("bob")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// again, nothing new
+	report, err = fs.ImportLibrary("bob", bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Existing)
+	assert.Equal(t, 0, report.Documents)
+
+	// the unpacked documents count against the quota
+	assert.NoError(t, fs.UpdateUser(&model.User{ID: "carol", Sync15: true, Quota: 200}))
+	report, err = fs.ImportLibrary("carol", bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, report.Problems)
+	assert.Less(t, report.Documents+report.Folders, 4)
+}
+
+func TestUnpackedSize(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a", "b"} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(make([]byte, 1000))
+	}
+	assert.NoError(t, zw.Close())
+	size, err := unpackedSize(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), size)
+
+	// a header claiming more than the limit
+	data := buf.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	zr.File[0].UncompressedSize64 = maxLibraryEntrySize + 1
+	_, err = readZipFile(zr.File[0])
+	assert.Error(t, err)
+}
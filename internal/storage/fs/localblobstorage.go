@@ -44,6 +44,15 @@ func (p *LocalBlobStorage) GetReader(hash string) (io.ReadCloser, error) {
 	return r, err
 }
 
+// Exists checks if the blob is present
+func (p *LocalBlobStorage) Exists(hash string) (bool, error) {
+	_, err := p.fs.StatBlob(p.uid, hash)
+	if err == ErrorNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // Write stores the reader in the hash
 func (p *LocalBlobStorage) Write(hash string, r io.Reader) error {
 	_, err := p.fs.StoreBlob(p.uid, hash, r, -1)
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"os"
@@ -40,7 +41,7 @@ func (fs *FileSystemStorage) GetAllMetadata(uid string) (result []*messages.RawM
 // GetMetadata loads a document's metadata
 func (fs *FileSystemStorage) GetMetadata(uid, id string) (*messages.RawMetadata, error) {
 	fullPath := fs.getPathFromUser(uid, id+storage.MetadataFileExt)
-	f, err := os.Open(fullPath)
+	f, err := fs.openUserFile(uid, fullPath)
 	if err != nil {
 		return nil, err
 	}
@@ -68,6 +69,5 @@ func (fs *FileSystemStorage) UpdateMetadata(uid string, r *messages.RawMetadata)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(filepath, js, 0600)
-	return err
+	return fs.writeUserFile(uid, fs.getUserPath(uid), filepath, bytes.NewReader(js))
 }
@@ -0,0 +1,210 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// MigrateToSync15 converts the sync10 documents of a user to sync15 blobs and adds them to the root.
+// The ids, names, parents and annotations are kept, the sync10 files are left alone.
+// Documents already in the tree are skipped, so it can be run again.
+func (fs *FileSystemStorage) MigrateToSync15(uid string, dryRun bool) (*storage.MigrationReport, error) {
+	report := &storage.MigrationReport{
+		UID:    uid,
+		DryRun: dryRun,
+	}
+
+	metadata, err := fs.GetAllMetadata(uid)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	blobStorage := fs.BlobStorage(uid)
+
+	folders := map[string]bool{}
+	for _, m := range metadata {
+		if m.Type == common.CollectionType {
+			folders[m.ID] = true
+		}
+	}
+
+	docs := []*models.HashDoc{}
+	for _, m := range metadata {
+		if _, err := tree.FindDoc(m.ID); err == nil {
+			report.Existing++
+			continue
+		}
+		problem := func(reason string) {
+			report.Problems = append(report.Problems, storage.MigrationProblem{ID: m.ID, Name: m.VissibleName, Reason: reason})
+		}
+
+		parent := m.Parent
+		if parent != "" && parent != TrashParent && !folders[parent] {
+			if _, err := tree.FindDoc(parent); err != nil {
+				problem("parent " + parent + " not found, moved to the root")
+				parent = ""
+			}
+		}
+
+		hashDoc, err := fs.migrateDocument(uid, m, parent, blobStorage, dryRun)
+		if err != nil {
+			problem(err.Error())
+			continue
+		}
+		docs = append(docs, hashDoc)
+		if m.Type == common.CollectionType {
+			report.Folders++
+		} else {
+			report.Documents++
+		}
+	}
+
+	if dryRun || len(docs) == 0 {
+		return report, nil
+	}
+
+	err = updateTree(tree, blobStorage, func(t *models.HashTree) error {
+		for _, d := range docs {
+			// a device may have synced meanwhile
+			if _, err := t.FindDoc(d.EntryName); err == nil {
+				continue
+			}
+			t.Docs = append(t.Docs, d)
+		}
+		return t.Rehash()
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("migrated %d documents and %d folders of %s to sync15", report.Documents, report.Folders, uid)
+	return report, nil
+}
+
+// migrateDocument stores the files of a sync10 document as blobs
+func (fs *FileSystemStorage) migrateDocument(uid string, m *messages.RawMetadata, parent string, blobStorage *LocalBlobStorage, dryRun bool) (*models.HashDoc, error) {
+	write := func(name string, data []byte) (*models.HashEntry, error) {
+		hash, size, err := models.Hash(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if !dryRun {
+			if err = blobStorage.Write(hash, bytes.NewReader(data)); err != nil {
+				return nil, err
+			}
+		}
+		return models.NewHashEntry(hash, name, size), nil
+	}
+
+	lastModified, err := time.Parse(time.RFC3339Nano, m.ModifiedClient)
+	if err != nil {
+		lastModified = time.Now()
+	}
+	metadata := models.MetadataFile{
+		DocumentName:   m.VissibleName,
+		CollectionType: m.Type,
+		Parent:         parent,
+		CreatedTime:    models.FromTime(lastModified),
+		LastModified:   models.FromTime(lastModified),
+		LastOpenedPage: m.CurrentPage,
+		Version:        m.Version,
+		Pinned:         m.Bookmarked,
+		Synced:         true,
+	}
+	hashDoc := models.NewHashDocWithMeta(m.ID, metadata)
+
+	metadataReader, _, _, err := createMetadataFile(metadata)
+	if err != nil {
+		return nil, err
+	}
+	metadataBytes, err := io.ReadAll(metadataReader)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := write(m.ID+storage.MetadataFileExt, metadataBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err = hashDoc.AddFile(entry); err != nil {
+		return nil, err
+	}
+
+	zipPath := fs.getPathFromUser(uid, m.ID+storage.ZipFileExt)
+	zipFile, err := fs.openUserFile(uid, zipPath)
+	if os.IsNotExist(err) && m.Type == common.CollectionType {
+		// a folder is just metadata
+		return hashDoc, writeIndex(hashDoc, blobStorage, dryRun)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no document archive: %w", err)
+	}
+	defer zipFile.Close()
+	zr, err := zip.NewReader(zipFile, zipFile.Size())
+	if err != nil {
+		return nil, fmt.Errorf("broken document archive: %w", err)
+	}
+
+	hasContent := false
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasSuffix(f.Name, storage.MetadataFileExt) {
+			continue
+		}
+		// the index entries are named after the document
+		if !strings.HasPrefix(f.Name, m.ID) {
+			return nil, fmt.Errorf("unexpected file %s in the archive", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		entry, err := write(f.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		if err = hashDoc.AddFile(entry); err != nil {
+			return nil, err
+		}
+		if f.Name == m.ID+storage.ContentFileExt {
+			hasContent = true
+			var content models.ContentFile
+			if err := json.Unmarshal(data, &content); err == nil {
+				hashDoc.PayloadType = content.FileType
+			}
+		}
+	}
+	if !hasContent && m.Type != common.CollectionType {
+		return nil, fmt.Errorf("no %s file in the archive", storage.ContentFileExt)
+	}
+
+	return hashDoc, writeIndex(hashDoc, blobStorage, dryRun)
+}
+
+func writeIndex(hashDoc *models.HashDoc, blobStorage *LocalBlobStorage, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+	indexReader, err := hashDoc.IndexReader()
+	if err != nil {
+		return err
+	}
+	return blobStorage.Write(hashDoc.Hash, indexReader)
+}
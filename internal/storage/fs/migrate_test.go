@@ -0,0 +1,69 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateToSync15(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	folder, err := fs.CreateFolder("test", "folder", "")
+	assert.NoError(t, err)
+	doc, err := fs.CreateDocument("test", "doc.pdf", folder.ID, strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	broken, err := fs.CreateDocument("test", "broken.pdf", "", strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(fs.getPathFromUser("test", broken.ID+storage.ZipFileExt), []byte("not a zip"), 0600))
+
+	report, err := fs.MigrateToSync15("test", true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Documents)
+	assert.Equal(t, 1, report.Folders)
+	if assert.Len(t, report.Problems, 1) {
+		assert.Equal(t, broken.ID, report.Problems[0].ID)
+	}
+	tree, err := fs.GetCachedTree("test")
+	assert.NoError(t, err)
+	assert.Empty(t, tree.Docs, "dry run")
+
+	report, err = fs.MigrateToSync15("test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Documents)
+	tree, err = fs.GetCachedTree("test")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Docs, 2)
+	migrated, err := tree.FindDoc(doc.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "doc", migrated.DocumentName)
+		assert.Equal(t, folder.ID, migrated.Parent)
+		assert.Equal(t, "pdf", migrated.PayloadType)
+	}
+	missing, err := fs.MissingBlobs("test")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// the pdf can be read back
+	r, err := fs.Export("test", doc.ID)
+	if assert.NoError(t, err) {
+		r.Close()
+	}
+
+	report, err = fs.MigrateToSync15("test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Existing)
+	assert.Equal(t, 0, report.Documents)
+}
@@ -0,0 +1,9 @@
+package fs
+
+import "github.com/ddvk/rmfakecloud/internal/storage/models"
+
+// MissingBlobs returns the blobs referenced by the current root which are not stored
+func (fs *FileSystemStorage) MissingBlobs(uid string) ([]string, error) {
+	ls := fs.BlobStorage(uid)
+	return models.MissingBlobs(ls, ls)
+}
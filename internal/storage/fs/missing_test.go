@@ -0,0 +1,79 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/s3/s3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingBlobs(t *testing.T) {
+	for _, backend := range []string{"fs", "s3"} {
+		for _, schema := range []string{"3", "4"} {
+			t.Run(backend+"/v"+schema, func(t *testing.T) {
+				testMissingBlobs(t, backend, schema)
+			})
+		}
+	}
+}
+
+func testMissingBlobs(t *testing.T, backend, schema string) {
+	var fs *FileSystemStorage
+	var remove func(hash string)
+	if backend == "s3" {
+		var srv *s3test.Server
+		fs, srv = newS3Storage(t)
+		remove = func(hash string) { srv.Delete("users/test/sync/" + hash) }
+	} else {
+		dir, err := os.MkdirTemp("", "rmfake-missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		fs = NewStorage(&config.Config{DataDir: dir})
+		remove = func(hash string) { os.Remove(filepath.Join(fs.getUserBlobPath("test"), hash)) }
+	}
+	fs.Cfg.HashSchemaVersion = schema
+	err := os.MkdirAll(fs.getUserBlobPath("test"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err := fs.MissingBlobs("test")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+
+	doc, err := fs.CreateBlobDocument("test", "some.pdf", "", strings.NewReader("%PDF-1.4"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	missing, err = fs.MissingBlobs("test")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+
+	tree, err := fs.GetCachedTree("test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, schema, tree.SchemaVersion)
+	hashDoc, err := tree.FindDoc(doc.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	removed := hashDoc.Files[0].Hash
+	remove(removed)
+
+	missing, err = fs.MissingBlobs("test")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{removed}, missing)
+
+	// the index of the document itself
+	remove(hashDoc.Hash)
+	missing, err = fs.MissingBlobs("test")
+	assert.NoError(t, err)
+	assert.Contains(t, missing, hashDoc.Hash)
+}
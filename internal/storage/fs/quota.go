@@ -0,0 +1,163 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// SmallBlobSize blobs up to this size (indexes, metadata) can go over the quota by QuotaOverdraft,
+// so that documents can still be moved or deleted to free some space
+const SmallBlobSize = 64 * 1024
+
+// QuotaOverdraft how far the small blobs can go over the quota
+const QuotaOverdraft = 1024 * 1024
+
+// StorageUsage the space used by a user, the files in the sync15 tree plus the sync10 documents
+func (fs *FileSystemStorage) StorageUsage(uid string) (int64, error) {
+	var used int64
+
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range tree.Docs {
+		for _, f := range d.Files {
+			used += f.Size
+		}
+	}
+
+	for _, dir := range []string{fs.getUserPath(uid), fs.getPathFromUser(uid, DefaultTrashDir)} {
+		zips, err := filepath.Glob(filepath.Join(dir, "*"+storage.ZipFileExt))
+		if err != nil {
+			return 0, err
+		}
+		for _, z := range zips {
+			if fi, err := os.Stat(z); err == nil {
+				used += fi.Size()
+			}
+		}
+	}
+	return used, nil
+}
+
+// usage the space used by a user, counted once and then kept up to date.
+// The uploads are charged when they start, it is counted again when the tree or the documents change
+type usage struct {
+	sync.Mutex
+	counted bool
+	used    int64
+}
+
+func (fs *FileSystemStorage) userUsage(uid string) *usage {
+	u, _ := fs.usages.LoadOrStore(uid, &usage{})
+	return u.(*usage)
+}
+
+// usageChanged the usage is counted again on the next upload
+func (fs *FileSystemStorage) usageChanged(uid string) {
+	u := fs.userUsage(uid)
+	u.Lock()
+	u.counted = false
+	u.Unlock()
+}
+
+// countUsage counts the usage if it changed, with the lock held
+func (fs *FileSystemStorage) countUsage(uid string, u *usage) (err error) {
+	if !u.counted {
+		if u.used, err = fs.StorageUsage(uid); err != nil {
+			return err
+		}
+		u.counted = true
+	}
+	return nil
+}
+
+// spaceLeft the quota left of a user, -1 without a quota
+func (fs *FileSystemStorage) spaceLeft(uid string) (int64, error) {
+	user, err := fs.GetUser(uid)
+	if err != nil {
+		return 0, err
+	}
+	if user.Quota <= 0 {
+		return -1, nil
+	}
+	u := fs.userUsage(uid)
+	u.Lock()
+	defer u.Unlock()
+	if err = fs.countUsage(uid, u); err != nil {
+		return 0, err
+	}
+	return max(user.Quota-u.used, 0), nil
+}
+
+// QuotaReader checks that size (-1 if unknown) more bytes fit into the quota of the user
+// and returns a reader which fails with storage.ErrorQuotaExceeded when more than the space left is read.
+// Every blob is charged, a small one can use the overdraft.
+// The size is reserved right away, so parallel uploads can't all take the space left
+func (fs *FileSystemStorage) QuotaReader(uid string, r io.Reader, size int64) (io.Reader, error) {
+	user, err := fs.GetUser(uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.Quota <= 0 {
+		return r, nil
+	}
+
+	u := fs.userUsage(uid)
+	u.Lock()
+	defer u.Unlock()
+	if err = fs.countUsage(uid, u); err != nil {
+		return nil, err
+	}
+	limit := user.Quota
+	if size >= 0 && size <= SmallBlobSize {
+		limit += QuotaOverdraft
+	}
+	remaining := limit - u.used
+	if remaining < 0 || size > remaining {
+		log.Warnf("%s over quota, needs %d, left %d", uid, size, remaining)
+		return nil, storage.ErrorQuotaExceeded
+	}
+	reserved := max(size, 0)
+	u.used += reserved
+	return &quotaReader{r: r, usage: u, quota: limit, charged: reserved}, nil
+}
+
+type quotaReader struct {
+	r     io.Reader
+	usage *usage
+	quota int64
+	// read so far and charged to the usage
+	read    int64
+	charged int64
+	failed  bool
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	if q.failed {
+		return n, err
+	}
+	q.read += int64(n)
+
+	q.usage.Lock()
+	defer q.usage.Unlock()
+	if q.read > q.charged {
+		q.usage.used += q.read - q.charged
+		q.charged = q.read
+	}
+	if q.usage.used > q.quota {
+		err = storage.ErrorQuotaExceeded
+	}
+	// a failed upload doesn't take space
+	if err != nil && err != io.EOF {
+		q.usage.used -= q.charged
+		q.failed = true
+	}
+	return n, err
+}
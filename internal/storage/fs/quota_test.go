@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{ID: "test", Sync15: true, Quota: 200000}
+	if err = fs.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.CreateBlobDocument("test", "first.pdf", "", strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	used, err := fs.StorageUsage("test")
+	assert.NoError(t, err)
+	assert.Greater(t, used, int64(0))
+
+	_, err = fs.QuotaReader("test", nil, 4*SmallBlobSize)
+	assert.ErrorIs(t, err, storage.ErrorQuotaExceeded)
+
+	blob := bytes.Repeat([]byte("x"), 4*SmallBlobSize)
+	r, err := fs.QuotaReader("test", bytes.NewReader(blob), -1)
+	assert.NoError(t, err)
+	_, err = fs.StoreBlob("test", "big", r, -1)
+	assert.True(t, errors.Is(err, storage.ErrorQuotaExceeded))
+	_, err = fs.StatBlob("test", "big")
+	assert.ErrorIs(t, err, ErrorNotFound, "no partial blob")
+
+	// the space is reserved when the upload starts, a failed one gives it back
+	half := (200000-used)/2 + 1
+	r, err = fs.QuotaReader("test", bytes.NewReader(blob[:half]), half)
+	assert.NoError(t, err)
+	_, err = fs.QuotaReader("test", nil, half)
+	assert.ErrorIs(t, err, storage.ErrorQuotaExceeded, "parallel upload")
+	_, err = io.Copy(io.Discard, r)
+	assert.NoError(t, err)
+	r, err = fs.QuotaReader("test", bytes.NewReader(blob), -1)
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, r)
+	assert.ErrorIs(t, err, storage.ErrorQuotaExceeded)
+	_, err = fs.QuotaReader("test", nil, half-2)
+	assert.NoError(t, err, "released")
+
+	// over the quota, the small blobs are still charged and only get the overdraft
+	user.Quota = 1
+	assert.NoError(t, fs.UpdateUser(user))
+	_, err = fs.QuotaReader("test", nil, SmallBlobSize+1)
+	assert.ErrorIs(t, err, storage.ErrorQuotaExceeded)
+	r, err = fs.QuotaReader("test", bytes.NewReader(blob[:100]), 100)
+	assert.NoError(t, err)
+	_, err = fs.StoreBlob("test", "small", r, -1)
+	assert.NoError(t, err)
+	accepted := 0
+	for ; accepted < 20; accepted++ {
+		if _, err = fs.QuotaReader("test", nil, SmallBlobSize); err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, storage.ErrorQuotaExceeded)
+	assert.Less(t, accepted, QuotaOverdraft/SmallBlobSize)
+
+	user.Quota = 0
+	assert.NoError(t, fs.UpdateUser(user))
+	r, err = fs.QuotaReader("test", bytes.NewReader(blob), -1)
+	assert.NoError(t, err)
+	_, err = fs.StoreBlob("test", "big", r, -1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,148 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorBlobsMissing some blobs of a generation were deleted
+var ErrorBlobsMissing = errors.New("blobs missing")
+
+// the n-th root write creates generation n
+func generationOf(h *models.RootHistory) int64 {
+	return h.Generation + 1
+}
+
+func (fs *FileSystemStorage) findGeneration(uid string, generation int64) (*models.RootHistory, error) {
+	history, err := fs.blobs.RootHistory(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range history {
+		if generationOf(h) == generation {
+			return h, nil
+		}
+	}
+	return nil, ErrorNotFound
+}
+
+// ListGenerations lists the roots in the history, newest first
+func (fs *FileSystemStorage) ListGenerations(uid string) ([]*storage.Generation, error) {
+	history, err := fs.blobs.RootHistory(uid)
+	if err != nil {
+		return nil, err
+	}
+	ls := fs.BlobStorage(uid)
+	currentHash, _, err := ls.GetRootIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	generations := make([]*storage.Generation, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		h := history[i]
+		g := &storage.Generation{
+			Generation: generationOf(h),
+			Date:       h.Date,
+			Hash:       h.Hash,
+			Current:    i == len(history)-1 && h.Hash == currentHash,
+		}
+		// only the root index is checked, a restore checks all the blobs
+		g.Documents, err = h.DocumentCount(ls)
+		if err != nil && err != ErrorNotFound {
+			log.Warnf("generation %d: %v", g.Generation, err)
+		}
+		g.Available = err == nil
+		generations = append(generations, g)
+	}
+	return generations, nil
+}
+
+func (fs *FileSystemStorage) generationTree(uid string, generation int64) (*models.HashTree, error) {
+	h, err := fs.findGeneration(uid, generation)
+	if err != nil {
+		return nil, err
+	}
+	ls := fs.BlobStorage(uid)
+	missing, err := models.MissingBlobsFrom(ls, ls, h.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("generation %d, %d %w", generation, len(missing), ErrorBlobsMissing)
+	}
+	return h.GetHashTree(ls)
+}
+
+// DiffGenerations compares the documents of two generations
+func (fs *FileSystemStorage) DiffGenerations(uid string, from, to int64) (*storage.GenerationDiff, error) {
+	fromTree, err := fs.generationTree(uid, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := fs.generationTree(uid, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &storage.GenerationDiff{From: from, To: to}
+	fromDocs := make(map[string]*models.HashDoc)
+	for _, d := range fromTree.Docs {
+		fromDocs[d.EntryName] = d
+	}
+	for _, d := range toTree.Docs {
+		change := storage.DocumentChange{ID: d.EntryName, Name: d.DocumentName}
+		old, ok := fromDocs[d.EntryName]
+		if !ok {
+			diff.Added = append(diff.Added, change)
+			continue
+		}
+		delete(fromDocs, d.EntryName)
+		if old.Hash != d.Hash {
+			diff.Modified = append(diff.Modified, change)
+		}
+	}
+	for _, d := range fromDocs {
+		diff.Removed = append(diff.Removed, storage.DocumentChange{ID: d.EntryName, Name: d.DocumentName})
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Name < diff.Removed[j].Name })
+	return diff, nil
+}
+
+// RestoreGeneration points the root to the one of an older (or newer) generation,
+// the restore itself is a new generation
+func (fs *FileSystemStorage) RestoreGeneration(uid string, generation int64) (newGeneration int64, err error) {
+	h, err := fs.findGeneration(uid, generation)
+	if err != nil {
+		return 0, err
+	}
+	ls := fs.BlobStorage(uid)
+	missing, err := models.MissingBlobsFrom(ls, ls, h.Hash)
+	if err != nil {
+		return 0, err
+	}
+	if len(missing) > 0 {
+		return 0, fmt.Errorf("generation %d, %d %w", generation, len(missing), ErrorBlobsMissing)
+	}
+
+	currentHash, currentGen, err := ls.GetRootIndex()
+	if err != nil {
+		return 0, err
+	}
+	if currentHash == h.Hash {
+		log.Info("generation ", generation, " is already the current root")
+		return currentGen, nil
+	}
+
+	newGeneration, err = ls.WriteRootIndex(currentGen, h.Hash)
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("restored %s to generation %d, new generation %d", uid, generation, newGeneration)
+	return newGeneration, nil
+}
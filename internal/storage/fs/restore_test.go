@@ -0,0 +1,118 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreGeneration(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := fs.CreateBlobDocument("test", "first.pdf", "", strings.NewReader("%PDF-1.4"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	second, err := fs.CreateBlobDocument("test", "second.pdf", "", strings.NewReader("%PDF-1.5"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	generations, err := fs.ListGenerations("test")
+	assert.NoError(t, err)
+	if !assert.Len(t, generations, 2) {
+		return
+	}
+	assert.Equal(t, int64(2), generations[0].Generation)
+	assert.True(t, generations[0].Current)
+	assert.Equal(t, 2, generations[0].Documents)
+	assert.Equal(t, 1, generations[1].Documents)
+
+	diff, err := fs.DiffGenerations("test", 1, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, diff.Added, 1) {
+		assert.Equal(t, second.ID, diff.Added[0].ID)
+	}
+	assert.Empty(t, diff.Removed)
+
+	gen, err := fs.RestoreGeneration("test", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), gen)
+
+	tree, err := fs.GetCachedTree("test")
+	assert.NoError(t, err)
+	if assert.Len(t, tree.Docs, 1) {
+		assert.Equal(t, first.ID, tree.Docs[0].EntryName)
+	}
+
+	// and forward again
+	gen, err = fs.RestoreGeneration("test", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), gen)
+
+	_, err = fs.RestoreGeneration("test", 10)
+	assert.Equal(t, ErrorNotFound, err)
+}
+
+func TestDocumentVersions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := fs.CreateBlobDocument("test", "first.pdf", "", strings.NewReader("%PDF-1.4"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = fs.CreateBlobDocument("test", "other.pdf", "", strings.NewReader("%PDF-1.5"))
+	assert.NoError(t, err)
+	err = fs.UpdateBlobDocument("test", doc.ID, "renamed", "")
+	assert.NoError(t, err)
+
+	versions, err := fs.DocumentVersions("test", doc.ID)
+	assert.NoError(t, err)
+	if !assert.Len(t, versions, 2) {
+		return
+	}
+	assert.Equal(t, "renamed", versions[0].Name)
+	assert.True(t, versions[0].Current)
+	assert.Equal(t, "first", versions[1].Name)
+	assert.Equal(t, int64(1), versions[1].Generation)
+
+	_, err = fs.ExportVersion("test", doc.ID, "unknown", "rmdoc")
+	assert.Equal(t, ErrorNotFound, err)
+
+	err = fs.RestoreDocumentVersion("test", doc.ID, versions[1].Version)
+	assert.NoError(t, err)
+	tree, err := fs.GetCachedTree("test")
+	assert.NoError(t, err)
+	hashDoc, err := tree.FindDoc(doc.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "first", hashDoc.DocumentName)
+	}
+	assert.Len(t, tree.Docs, 2)
+
+	// only the new roots are read, the restore is not a new version
+	versions, err = fs.DocumentVersions("test", doc.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 2) {
+		assert.False(t, versions[0].Current)
+		assert.True(t, versions[1].Current)
+	}
+}
@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"sort"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// docAudit an audit entry of a document
+func docAudit(action, docID, name string) audit.Entry {
+	return audit.Entry{
+		Action:   action,
+		Document: docID,
+		Details:  map[string]string{"name": name},
+	}
+}
+
+// RootChanges the documents created, deleted, moved or renamed by a root update, for the audit log.
+// Only the documents whose index changed are read
+func (fs *FileSystemStorage) RootChanges(uid, oldHash, newHash string) ([]audit.Entry, error) {
+	ls := fs.BlobStorage(uid)
+	oldEntries := make(map[string]*models.HashEntry)
+	if oldHash != "" {
+		entries, err := (&models.RootHistory{Hash: oldHash}).Entries(ls)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			oldEntries[e.EntryName] = e
+		}
+	}
+	newEntries, err := (&models.RootHistory{Hash: newHash}).Entries(ls)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]audit.Entry, 0)
+	for _, entry := range newEntries {
+		old, existed := oldEntries[entry.EntryName]
+		delete(oldEntries, entry.EntryName)
+		if existed && old.Hash == entry.Hash {
+			continue
+		}
+		doc, err := loadVersion(ls, entry.EntryName, entry.Hash)
+		if err != nil {
+			log.Warnf("audit, document %s: %v", entry.EntryName, err)
+			continue
+		}
+		if !existed {
+			changes = append(changes, docAudit(audit.ActionDocumentCreate, doc.EntryName, doc.DocumentName))
+			continue
+		}
+		oldDoc, err := loadVersion(ls, old.EntryName, old.Hash)
+		if err != nil {
+			log.Warnf("audit, document %s: %v", old.EntryName, err)
+			continue
+		}
+		switch {
+		case doc.Deleted && !oldDoc.Deleted:
+			changes = append(changes, docAudit(audit.ActionDocumentDelete, doc.EntryName, doc.DocumentName))
+		case oldDoc.Parent != doc.Parent:
+			e := docAudit(audit.ActionDocumentMove, doc.EntryName, doc.DocumentName)
+			e.Details["oldParent"] = oldDoc.Parent
+			e.Details["parent"] = doc.Parent
+			changes = append(changes, e)
+		case oldDoc.DocumentName != doc.DocumentName:
+			e := docAudit(audit.ActionDocumentRename, doc.EntryName, doc.DocumentName)
+			e.Details["oldName"] = oldDoc.DocumentName
+			changes = append(changes, e)
+		}
+	}
+
+	removed := make([]*models.HashEntry, 0, len(oldEntries))
+	for _, old := range oldEntries {
+		removed = append(removed, old)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].EntryName < removed[j].EntryName })
+	for _, old := range removed {
+		name := ""
+		if doc, err := loadVersion(ls, old.EntryName, old.Hash); err == nil {
+			name = doc.DocumentName
+		}
+		changes = append(changes, docAudit(audit.ActionDocumentDelete, old.EntryName, name))
+	}
+	return changes, nil
+}
@@ -0,0 +1,82 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootChanges(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-rootchanges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ls := fs.BlobStorage("test")
+	root := func() string {
+		hash, _, err := ls.GetRootIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	changes := func(oldHash string) []audit.Entry {
+		entries, err := fs.RootChanges("test", oldHash, root())
+		assert.NoError(t, err)
+		return entries
+	}
+
+	doc, err := fs.CreateBlobDocument("test", "first.pdf", "", strings.NewReader("%PDF-1.4"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	entries := changes("")
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.ActionDocumentCreate, entries[0].Action)
+		assert.Equal(t, doc.ID, entries[0].Document)
+		assert.Equal(t, "first", entries[0].Details["name"])
+	}
+
+	before := root()
+	folder, err := fs.CreateBlobFolder("test", "folder", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, fs.UpdateBlobDocument("test", doc.ID, "renamed", ""))
+	entries = changes(before)
+	if assert.Len(t, entries, 2) {
+		// in the order of the root index
+		if entries[0].Document != folder.ID {
+			entries[0], entries[1] = entries[1], entries[0]
+		}
+		assert.Equal(t, audit.ActionDocumentCreate, entries[0].Action)
+		assert.Equal(t, folder.ID, entries[0].Document)
+		assert.Equal(t, audit.ActionDocumentRename, entries[1].Action)
+		assert.Equal(t, "first", entries[1].Details["oldName"])
+	}
+
+	before = root()
+	assert.NoError(t, fs.UpdateBlobDocument("test", doc.ID, "renamed", folder.ID))
+	entries = changes(before)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.ActionDocumentMove, entries[0].Action)
+		assert.Equal(t, folder.ID, entries[0].Details["parent"])
+	}
+
+	before = root()
+	assert.NoError(t, fs.DeleteBlobDocument("test", doc.ID))
+	entries = changes(before)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, audit.ActionDocumentDelete, entries[0].Action)
+		assert.Equal(t, "renamed", entries[0].Details["name"])
+	}
+	assert.Empty(t, changes(root()))
+}
@@ -0,0 +1,235 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/ddvk/rmfakecloud/internal/storage/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metaGeneration = "generation"
+	metaCRC32C     = "crc32c"
+)
+
+// s3Blobs stores the blobs in an s3 compatible object store
+// using the same layout as on disk: users/<uid>/sync/<blobid>
+// the root generation is kept in the object metadata and changed with conditional writes,
+// every root change is recorded as an empty object .root.history/<gen>.<unixtime>.<hash>
+type s3Blobs struct {
+	client *s3.Client
+}
+
+// NewS3BlobBackend creates a blob backend for an s3 compatible store
+func NewS3BlobBackend(cfg *s3.Config) (BlobBackend, error) {
+	client, err := s3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Blobs{client: client}, nil
+}
+
+func (b *s3Blobs) key(uid, blobID string) string {
+	return path.Join(userDir, common.SanitizeUid(uid), SyncFolder, common.Sanitize(blobID))
+}
+
+func (b *s3Blobs) historyPrefix(uid string) string {
+	return path.Join(userDir, common.SanitizeUid(uid), SyncFolder, historyFile) + "/"
+}
+
+// StatBlob the size of a blob
+func (b *s3Blobs) StatBlob(uid, blobID string) (int64, error) {
+	info, err := b.client.Head(b.key(uid, blobID))
+	if err == s3.ErrNotFound {
+		return 0, ErrorNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// ListBlobs lists the objects in the sync folder
+func (b *s3Blobs) ListBlobs(uid string) ([]BlobInfo, error) {
+	prefix := path.Join(userDir, common.SanitizeUid(uid), SyncFolder) + "/"
+	objects, err := b.client.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]BlobInfo, 0, len(objects))
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, prefix)
+		// the history entries are in a subfolder
+		if name == rootBlob || strings.Contains(name, "/") {
+			continue
+		}
+		blobs = append(blobs, BlobInfo{ID: name, Size: o.Size, ModTime: o.LastModified})
+	}
+	return blobs, nil
+}
+
+// DeleteBlob removes the object
+func (b *s3Blobs) DeleteBlob(uid, blobID string) error {
+	log.Info("s3 delete: ", b.key(uid, blobID))
+	return b.client.Delete(b.key(uid, blobID))
+}
+
+// LoadBlob opens a blob
+func (b *s3Blobs) LoadBlob(uid, blobID string) (reader io.ReadCloser, gen int64, size int64, crc32c string, err error) {
+	key := b.key(uid, blobID)
+	log.Debugln("s3 get:", key)
+	body, info, err := b.client.Get(key)
+	if err == s3.ErrNotFound {
+		return nil, 0, 0, "", ErrorNotFound
+	}
+	if err != nil {
+		return nil, 0, 0, "", err
+	}
+	if blobID == rootBlob {
+		gen, _ = strconv.ParseInt(info.Metadata[metaGeneration], 10, 64)
+	}
+
+	crc32c = info.Metadata[metaCRC32C]
+	if crc32c == "" {
+		// written by something else, spool it to get the hash
+		tmp, err := os.CreateTemp("", "s3-blob")
+		if err != nil {
+			body.Close()
+			return nil, 0, 0, "", err
+		}
+		os.Remove(tmp.Name())
+		crc := common.CRC32CWriter()
+		size, err = io.Copy(io.MultiWriter(tmp, crc), body)
+		body.Close()
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			tmp.Close()
+			return nil, 0, 0, "", err
+		}
+		return tmp, gen, size, "crc32c=" + common.CRC32CSum(crc), nil
+	}
+
+	return body, gen, info.Size, "crc32c=" + crc32c, nil
+}
+
+// StoreBlob writes a blob, the root is only replaced when the generation matches
+func (b *s3Blobs) StoreBlob(uid, blobID string, stream io.Reader, lastGen int64) (gen int64, err error) {
+	// the size and hash are needed upfront
+	tmp, err := os.CreateTemp("", "s3-blob")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	crc := common.CRC32CWriter()
+	size, err := io.Copy(io.MultiWriter(tmp, crc), stream)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	key := b.key(uid, blobID)
+	opts := &s3.PutOptions{
+		Metadata: map[string]string{
+			metaCRC32C: common.CRC32CSum(crc),
+		},
+	}
+
+	if blobID != rootBlob {
+		log.Info("s3 write: ", key)
+		_, err = b.client.Put(key, tmp, size, opts)
+		return 1, err
+	}
+
+	currentGen := int64(0)
+	info, err := b.client.Head(key)
+	switch err {
+	case nil:
+		currentGen, _ = strconv.ParseInt(info.Metadata[metaGeneration], 10, 64)
+		if currentGen != lastGen {
+			log.Warnf("wrong generation, currentGen %d, lastGen %d", currentGen, lastGen)
+			return currentGen, ErrorWrongGeneration
+		}
+		opts.IfMatch = info.ETag
+	case s3.ErrNotFound:
+		opts.IfNoneMatch = "*"
+	default:
+		return 0, err
+	}
+
+	gen = currentGen + 1
+	opts.Metadata[metaGeneration] = strconv.FormatInt(gen, 10)
+	log.Info("s3 write root: ", key, " gen: ", gen)
+	_, err = b.client.Put(key, tmp, size, opts)
+	if err == s3.ErrPreconditionFailed {
+		log.Warn("root changed concurrently, gen ", gen)
+		return currentGen, ErrorWrongGeneration
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// the root is replaced already, a missing history entry only loses that generation from the history
+	if err := b.writeHistory(uid, gen, tmp); err != nil {
+		log.Errorf("cannot write root history of %s, gen %d: %v", uid, gen, err)
+	}
+	return gen, nil
+}
+
+// writeHistory records the root in root as generation gen
+func (b *s3Blobs) writeHistory(uid string, gen int64, root io.ReadSeeker) error {
+	if _, err := root.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	rootHash, err := io.ReadAll(root)
+	if err != nil {
+		return err
+	}
+	historyKey := fmt.Sprintf("%s%020d.%d.%s", b.historyPrefix(uid), gen, time.Now().Unix(), strings.TrimSpace(string(rootHash)))
+	_, err = b.client.Put(historyKey, nil, 0, &s3.PutOptions{IfNoneMatch: "*"})
+	return err
+}
+
+// RootHistory lists the history entries, oldest first
+func (b *s3Blobs) RootHistory(uid string) ([]*models.RootHistory, error) {
+	prefix := b.historyPrefix(uid)
+	objects, err := b.client.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	history := make([]*models.RootHistory, 0, len(objects))
+	for _, o := range objects {
+		parts := strings.SplitN(strings.TrimPrefix(o.Key, prefix), ".", 3)
+		if len(parts) != 3 {
+			log.Warn("unexpected history entry ", o.Key)
+			continue
+		}
+		unix, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			log.Warn("unexpected history entry ", o.Key)
+			continue
+		}
+		history = append(history, &models.RootHistory{
+			Generation: int64(len(history)),
+			Date:       time.Unix(unix, 0).UTC(),
+			Hash:       parts[2],
+		})
+	}
+	return history, nil
+}
@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/s3/s3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newS3Storage(t *testing.T) (*FileSystemStorage, *s3test.Server) {
+	srv := s3test.NewServer("rmfakecloud")
+	t.Cleanup(srv.Close)
+	dir, err := os.MkdirTemp("", "rmfake-s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	cfg := &config.Config{
+		DataDir:           dir,
+		HashSchemaVersion: "3",
+		S3Config:          srv.Config(),
+	}
+	return NewStorage(cfg), srv
+}
+
+func TestS3StoreLoadBlob(t *testing.T) {
+	fs, srv := newS3Storage(t)
+
+	_, _, _, _, err := fs.LoadBlob("test", "missing")
+	assert.Equal(t, ErrorNotFound, err)
+
+	_, err = fs.StoreBlob("test", "someblob", strings.NewReader("content"), -1)
+	assert.NoError(t, err)
+	assert.Contains(t, srv.Keys(), "users/test/sync/someblob")
+
+	r, _, size, crc, err := fs.LoadBlob("test", "someblob")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(r)
+	assert.Equal(t, "content", string(b))
+	assert.Equal(t, int64(7), size)
+	expected, _ := common.CRC32CFromReader(strings.NewReader("content"))
+	assert.Equal(t, "crc32c="+expected, crc)
+}
+
+func TestS3RootGeneration(t *testing.T) {
+	fs, _ := newS3Storage(t)
+
+	_, gen, _, _, err := fs.LoadBlob("test", rootBlob)
+	assert.Equal(t, ErrorNotFound, err)
+	assert.Equal(t, int64(0), gen)
+
+	gen, err = fs.StoreBlob("test", rootBlob, strings.NewReader("hash1"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), gen)
+
+	gen, err = fs.StoreBlob("test", rootBlob, strings.NewReader("hash2"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), gen)
+
+	gen, err = fs.StoreBlob("test", rootBlob, strings.NewReader("hash3"), 1)
+	assert.Equal(t, ErrorWrongGeneration, err)
+	assert.Equal(t, int64(2), gen)
+
+	r, gen, _, _, err := fs.LoadBlob("test", rootBlob)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(r)
+	assert.Equal(t, "hash2", string(b))
+	assert.Equal(t, int64(2), gen)
+
+	history, err := fs.RootHistory("test")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "hash1", history[0].Hash)
+		assert.Equal(t, "hash2", history[1].Hash)
+	}
+}
+
+func TestS3RootHistoryFailure(t *testing.T) {
+	fs, srv := newS3Storage(t)
+	srv.FailPrefix = "users/test/sync/" + historyFile + "/"
+
+	// the root is replaced, the client gets the new generation
+	gen, err := fs.StoreBlob("test", rootBlob, strings.NewReader("hash1"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), gen)
+	_, gen, _, _, err = fs.LoadBlob("test", rootBlob)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), gen)
+}
+
+func TestS3CreateBlobDocument(t *testing.T) {
+	fs, _ := newS3Storage(t)
+	err := os.MkdirAll(fs.getUserBlobPath("test"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := fs.CreateBlobDocument("test", "some.pdf", "", strings.NewReader("%PDF-1.4"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// drop the cache, the tree has to be rebuilt from s3
+	os.Remove(fs.getPathFromUser("test", cachedTreeName))
+	tree, err := fs.GetCachedTree("test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	hashDoc, err := tree.FindDoc(doc.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "some", hashDoc.DocumentName)
+		assert.Len(t, hashDoc.Files, 3)
+	}
+	assert.Equal(t, int64(1), tree.Generation)
+}
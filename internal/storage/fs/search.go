@@ -0,0 +1,392 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const searchIndexName = ".search"
+
+// MaxSearchResults the maximum number of documents returned by a search
+const MaxSearchResults = 100
+
+const snippetContext = 40
+
+// maxSearchBlobSize the largest blob read into memory to be indexed,
+// the text of bigger pdfs and epubs is left out of the index
+var maxSearchBlobSize int64 = 32 << 20
+
+var errBlobTooLarge = errors.New("blob too large to index")
+
+// searchIndex an inverted index of the text of the documents of a user
+type searchIndex struct {
+	// Root the root index that was indexed
+	Root string                 `json:"root"`
+	Docs map[string]*indexedDoc `json:"docs"`
+	// Terms term -> document -> pages (0 based)
+	Terms map[string]map[string][]int `json:"terms"`
+}
+
+type indexedDoc struct {
+	// Hash the hash of the document index
+	Hash  string   `json:"hash"`
+	Name  string   `json:"name"`
+	Pages []string `json:"pages"`
+}
+
+// tokenize splits text into lowercase terms
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (idx *searchIndex) add(docID string, doc *indexedDoc) {
+	idx.Docs[docID] = doc
+	for page, text := range doc.Pages {
+		for _, term := range tokenize(text) {
+			docs, ok := idx.Terms[term]
+			if !ok {
+				docs = make(map[string][]int)
+				idx.Terms[term] = docs
+			}
+			pages := docs[docID]
+			if len(pages) > 0 && pages[len(pages)-1] == page {
+				continue
+			}
+			docs[docID] = append(pages, page)
+		}
+	}
+}
+
+func (idx *searchIndex) remove(docID string) {
+	doc, ok := idx.Docs[docID]
+	if !ok {
+		return
+	}
+	for _, text := range doc.Pages {
+		for _, term := range tokenize(text) {
+			if docs, ok := idx.Terms[term]; ok {
+				delete(docs, docID)
+				if len(docs) == 0 {
+					delete(idx.Terms, term)
+				}
+			}
+		}
+	}
+	delete(idx.Docs, docID)
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		Docs:  make(map[string]*indexedDoc),
+		Terms: make(map[string]map[string][]int),
+	}
+}
+
+func (fs *FileSystemStorage) loadSearchIndex(uid string) (*searchIndex, error) {
+	f, err := fs.openUserFile(uid, fs.getPathFromUser(uid, searchIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		return newSearchIndex(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	idx := newSearchIndex()
+	if err = json.Unmarshal(b, idx); err != nil {
+		log.Warn("search index corrupt, rebuilding ", err)
+		return newSearchIndex(), nil
+	}
+	return idx, nil
+}
+
+func (fs *FileSystemStorage) saveSearchIndex(uid string, idx *searchIndex) error {
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return fs.writeUserFile(uid, fs.getUserPath(uid), fs.getPathFromUser(uid, searchIndexName), bytes.NewReader(b))
+}
+
+func (fs *FileSystemStorage) searchLock(uid string) *sync.Mutex {
+	lock, _ := fs.searchLocks.LoadOrStore(uid, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func readBlob(ls *LocalBlobStorage, hash string) ([]byte, error) {
+	reader, err := ls.GetReader(hash)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	b, err := io.ReadAll(io.LimitReader(reader, maxSearchBlobSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSearchBlobSize {
+		return nil, errBlobTooLarge
+	}
+	return b, nil
+}
+
+// documentText extracts the text of each page: the pdf or epub payload
+// and the typed text of the v6 pages
+func documentText(doc *models.HashDoc, ls *LocalBlobStorage) ([]string, error) {
+	var payload []string
+	var pageIDs []string
+	rmPages := make(map[string]string)
+
+	for _, f := range doc.Files {
+		ext := path.Ext(f.EntryName)
+		switch ext {
+		case storage.PdfFileExt, storage.EpubFileExt:
+			b, err := readBlob(ls, f.Hash)
+			if errors.Is(err, errBlobTooLarge) {
+				log.Infof("search: %s of %s is too large, only the notes are indexed", f.EntryName, doc.EntryName)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if ext == storage.PdfFileExt {
+				payload, err = exporter.PdfText(bytes.NewReader(b))
+			} else {
+				payload, err = exporter.EpubText(bytes.NewReader(b), int64(len(b)))
+			}
+			if err != nil {
+				return nil, err
+			}
+		case storage.ContentFileExt:
+			b, err := readBlob(ls, f.Hash)
+			if err != nil {
+				return nil, err
+			}
+			if pageIDs, err = exporter.ContentPageIDs(b); err != nil {
+				return nil, err
+			}
+		case storage.RmFileExt:
+			rmPages[strings.TrimSuffix(path.Base(f.EntryName), ext)] = f.Hash
+		}
+	}
+
+	pages := payload
+	for len(pages) < len(pageIDs) {
+		pages = append(pages, "")
+	}
+	for i, pageID := range pageIDs {
+		hash, ok := rmPages[pageID]
+		if !ok {
+			continue
+		}
+		b, err := readBlob(ls, hash)
+		if err != nil {
+			return nil, err
+		}
+		if !exporter.IsRmV6(b) {
+			continue
+		}
+		text, err := exporter.RmText(b)
+		if err != nil {
+			log.Warnf("page %s of %s: %v", pageID, doc.EntryName, err)
+			continue
+		}
+		if text != "" {
+			pages[i] = strings.TrimSpace(pages[i] + "\n" + text)
+		}
+	}
+	return pages, nil
+}
+
+// updateSearchIndex brings the index up to date with the current tree, only changed documents are extracted
+func (fs *FileSystemStorage) updateSearchIndex(uid string) (*searchIndex, error) {
+	idx, err := fs.loadSearchIndex(uid)
+	if err != nil {
+		return nil, err
+	}
+	ls := fs.BlobStorage(uid)
+	rootHash, _, err := ls.GetRootIndex()
+	if err != nil {
+		return nil, err
+	}
+	if rootHash == idx.Root {
+		return idx, nil
+	}
+
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]bool)
+	for _, d := range tree.Docs {
+		if d.Deleted || d.CollectionType == common.CollectionType {
+			continue
+		}
+		current[d.EntryName] = true
+		if old, ok := idx.Docs[d.EntryName]; ok && old.Hash == d.Hash {
+			old.Name = d.DocumentName
+			continue
+		}
+		pages, err := documentText(d, ls)
+		if err != nil {
+			// indexed without text, not to retry until it changes
+			log.Warnf("search: cannot extract the text of %s: %v", d.EntryName, err)
+		}
+		idx.remove(d.EntryName)
+		idx.add(d.EntryName, &indexedDoc{Hash: d.Hash, Name: d.DocumentName, Pages: pages})
+		log.Debugf("search: indexed %s, %d pages", d.EntryName, len(pages))
+	}
+	for id := range idx.Docs {
+		if !current[id] {
+			idx.remove(id)
+		}
+	}
+
+	idx.Root = tree.Hash
+	if err = fs.saveSearchIndex(uid, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// UpdateSearchIndex indexes the documents changed since the last update
+func (fs *FileSystemStorage) UpdateSearchIndex(uid string) error {
+	lock := fs.searchLock(uid)
+	lock.Lock()
+	defer lock.Unlock()
+	_, err := fs.updateSearchIndex(uid)
+	return err
+}
+
+// snippet the text around the first occurrence of term
+func snippet(text, term string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	needle := []rune(term)
+	pos := 0
+	if len(lower) == len(runes) {
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) == term {
+				pos = i
+				break
+			}
+		}
+	}
+	start := pos - snippetContext
+	if start < 0 {
+		start = 0
+	}
+	end := pos + len(needle) + snippetContext
+	if end > len(runes) {
+		end = len(runes)
+	}
+	s := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+// Search finds the documents with pages containing all the terms of the query,
+// the last term matches as a prefix
+func (fs *FileSystemStorage) Search(uid, query string) ([]*storage.SearchResult, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []*storage.SearchResult{}, nil
+	}
+
+	lock := fs.searchLock(uid)
+	lock.Lock()
+	idx, err := fs.updateSearchIndex(uid)
+	lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// document -> page -> number of matched terms
+	matches := make(map[string]map[int]int)
+	for i, term := range terms {
+		pagesOfTerm := make(map[string]map[int]bool)
+		mark := func(docs map[string][]int) {
+			for docID, pages := range docs {
+				if pagesOfTerm[docID] == nil {
+					pagesOfTerm[docID] = make(map[int]bool)
+				}
+				for _, p := range pages {
+					pagesOfTerm[docID][p] = true
+				}
+			}
+		}
+		if i == len(terms)-1 {
+			for t, docs := range idx.Terms {
+				if strings.HasPrefix(t, term) {
+					mark(docs)
+				}
+			}
+		} else {
+			mark(idx.Terms[term])
+		}
+		for docID, pages := range pagesOfTerm {
+			if matches[docID] == nil {
+				matches[docID] = make(map[int]int)
+			}
+			for p := range pages {
+				matches[docID][p]++
+			}
+		}
+	}
+
+	results := make([]*storage.SearchResult, 0)
+	for docID, pages := range matches {
+		doc := idx.Docs[docID]
+		result := &storage.SearchResult{ID: docID, Name: doc.Name}
+		for p, count := range pages {
+			if count < len(terms) {
+				continue
+			}
+			result.Hits = append(result.Hits, storage.SearchHit{
+				Page:    p + 1,
+				Snippet: snippet(doc.Pages[p], terms[0]),
+			})
+		}
+		if len(result.Hits) == 0 {
+			continue
+		}
+		sort.Slice(result.Hits, func(i, j int) bool {
+			return result.Hits[i].Page < result.Hits[j].Page
+		})
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if len(a.Hits) != len(b.Hits) {
+			return len(a.Hits) > len(b.Hits)
+		}
+		return a.Name < b.Name
+	})
+	if len(results) > MaxSearchResults {
+		results = results[:MaxSearchResults]
+	}
+	return results, nil
+}
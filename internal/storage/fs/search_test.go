@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/unidoc/unipdf/v3/creator"
+)
+
+func testPdf(t *testing.T, pages ...string) *bytes.Buffer {
+	c := creator.New()
+	for _, text := range pages {
+		c.NewPage()
+		if err := c.Draw(c.NewParagraph(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := c.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func testEpub(t *testing.T, chapter string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	files := []struct{ name, body string }{
+		{"META-INF/container.xml", `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`},
+		{"OEBPS/content.opf", `<package><manifest><item id="c1" href="c1.xhtml"/></manifest><spine><itemref idref="c1"/></spine></package>`},
+		{"OEBPS/c1.xhtml", `<html><head><title>ignored</title></head><body><p>` + chapter + `</p></body></html>`},
+	}
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(f.body))
+	}
+	w.Close()
+	return buf
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	pdf, err := fs.CreateBlobDocument("test", "report.pdf", "", testPdf(t, "Quarterly numbers", "The zebra crossing is closed"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	epub, err := fs.CreateBlobDocument("test", "book.epub", "", testEpub(t, "A zebra in the savanna"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	results, err := fs.Search("test", "ZEBRA")
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		for _, r := range results {
+			assert.Len(t, r.Hits, 1)
+			assert.Contains(t, r.Hits[0].Snippet, "zebra")
+		}
+	}
+
+	results, err = fs.Search("test", "zebra cross")
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, pdf.ID, results[0].ID)
+		assert.Equal(t, "report", results[0].Name)
+		assert.Equal(t, 2, results[0].Hits[0].Page)
+	}
+
+	// incremental, the removed document is dropped
+	assert.NoError(t, fs.DeleteBlobDocument("test", pdf.ID))
+	results, err = fs.Search("test", "zebra")
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, epub.ID, results[0].ID)
+	}
+
+	results, err = fs.Search("test", "ignored")
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	// too large to be read, indexed without its text
+	defer func(size int64) { maxSearchBlobSize = size }(maxSearchBlobSize)
+	maxSearchBlobSize = 100
+	_, err = fs.CreateBlobDocument("test", "large.pdf", "", testPdf(t, "An okapi in the forest"))
+	assert.NoError(t, err)
+	results, err = fs.Search("test", "okapi")
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
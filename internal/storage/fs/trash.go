@@ -0,0 +1,194 @@
+package fs
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// TrashParent the parent of the trashed documents, same as the tablet
+const TrashParent = "trash"
+
+// ErrorNotInTrash the document is not in the trash
+var ErrorNotInTrash = errors.New("not in the trash")
+
+// TrashBlobDocument moves a document to the trash,
+// a document which is already there is deleted for good
+func (fs *FileSystemStorage) TrashBlobDocument(uid, docID string) error {
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return err
+	}
+	blobStorage := fs.BlobStorage(uid)
+
+	return updateTree(tree, blobStorage, func(t *models.HashTree) error {
+		hashDoc, err := t.FindDoc(docID)
+		if err != nil {
+			return err
+		}
+		if hashDoc.Parent == TrashParent {
+			removeWithChildren(t, []string{docID})
+			return nil
+		}
+		log.Info("trashing: ", hashDoc.DocumentName)
+		hashDoc.Parent = TrashParent
+		// the retention counts from here
+		hashDoc.LastModified = models.FromTime(time.Now())
+		hashDoc.Version++
+		if err = writeMetadata(blobStorage, hashDoc); err != nil {
+			return err
+		}
+		return t.Rehash()
+	})
+}
+
+// liveFolder checks that the folder exists and is not in the trash, itself or one of its parents
+func liveFolder(t *models.HashTree, folderID string) bool {
+	seen := make(map[string]bool)
+	for id := folderID; id != ""; {
+		if id == TrashParent || seen[id] {
+			return false
+		}
+		seen[id] = true
+		doc, err := t.FindDoc(id)
+		if err != nil {
+			return false
+		}
+		id = doc.Parent
+	}
+	return true
+}
+
+// RestoreBlobDocument moves a document out of the trash, to the root if parent is empty,
+// deleted or trashed. Returns the parent
+func (fs *FileSystemStorage) RestoreBlobDocument(uid, docID, parent string) (string, error) {
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return "", err
+	}
+	blobStorage := fs.BlobStorage(uid)
+
+	err = updateTree(tree, blobStorage, func(t *models.HashTree) error {
+		hashDoc, err := t.FindDoc(docID)
+		if err != nil {
+			return err
+		}
+		if hashDoc.Parent != TrashParent {
+			return ErrorNotInTrash
+		}
+		if parent != "" && !liveFolder(t, parent) {
+			log.Info("the folder ", parent, " is gone, restoring to the root: ", hashDoc.DocumentName)
+			parent = ""
+		}
+		hashDoc.Parent = parent
+		hashDoc.LastModified = models.FromTime(time.Now())
+		hashDoc.Version++
+		if err = writeMetadata(blobStorage, hashDoc); err != nil {
+			return err
+		}
+		return t.Rehash()
+	})
+	return parent, err
+}
+
+// EmptyTrash deletes all the trashed documents, returns how many
+func (fs *FileSystemStorage) EmptyTrash(uid string) (int, error) {
+	return fs.purgeTrash(uid, time.Time{})
+}
+
+// PurgeTrash deletes the documents trashed longer than retention ago
+func (fs *FileSystemStorage) PurgeTrash(uid string, retention time.Duration) (int, error) {
+	return fs.purgeTrash(uid, time.Now().Add(-retention))
+}
+
+// PurgeTrashAll purges the trash of every user
+func (fs *FileSystemStorage) PurgeTrashAll(retention time.Duration) (int, error) {
+	users, err := fs.GetUsers()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, u := range users {
+		if !u.Sync15 {
+			continue
+		}
+		n, err := fs.PurgeTrash(u.ID, retention)
+		if err != nil {
+			log.Errorf("trash: %s failed, %v", u.ID, err)
+			continue
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// purgeTrash removes the trashed documents modified before, all if before is zero
+func (fs *FileSystemStorage) purgeTrash(uid string, before time.Time) (int, error) {
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return 0, err
+	}
+	// don't make a new generation for nothing
+	if len(expiredTrash(tree, before)) == 0 {
+		return 0, nil
+	}
+
+	removed := 0
+	err = updateTree(tree, fs.BlobStorage(uid), func(t *models.HashTree) error {
+		removed = removeWithChildren(t, expiredTrash(t, before))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("trash: purged %d documents of %s", removed, uid)
+	return removed, nil
+}
+
+// expiredTrash the trashed documents modified before, all if before is zero.
+// A document without a valid modification time is kept, only emptying the trash removes it
+func expiredTrash(t *models.HashTree, before time.Time) []string {
+	expired := []string{}
+	for _, d := range t.Docs {
+		if d.Parent != TrashParent {
+			continue
+		}
+		if !before.IsZero() {
+			lastModified, err := models.ToTime(d.LastModified)
+			if err != nil {
+				log.Debugf("trash: %s has no valid modification time %q, kept", d.EntryName, d.LastModified)
+				continue
+			}
+			if lastModified.After(before) {
+				continue
+			}
+		}
+		expired = append(expired, d.EntryName)
+	}
+	return expired
+}
+
+// removeWithChildren removes the documents and everything in the trashed folders
+func removeWithChildren(t *models.HashTree, ids []string) int {
+	children := map[string][]string{}
+	for _, d := range t.Docs {
+		children[d.Parent] = append(children[d.Parent], d.EntryName)
+	}
+	seen := map[string]bool{}
+	removed := 0
+	for len(ids) > 0 {
+		id := ids[0]
+		ids = ids[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, children[id]...)
+		if t.Remove(id) == nil {
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir, HashSchemaVersion: "3"})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	folder, err := fs.CreateBlobFolder("test", "folder", "")
+	assert.NoError(t, err)
+	inFolder, err := fs.CreateBlobDocument("test", "inside.pdf", folder.ID, strings.NewReader("%PDF-1.4"))
+	assert.NoError(t, err)
+	doc, err := fs.CreateBlobDocument("test", "doc.pdf", "", strings.NewReader("%PDF-1.5"))
+	assert.NoError(t, err)
+
+	parentOf := func(id string) string {
+		tree, err := fs.GetCachedTree("test")
+		assert.NoError(t, err)
+		d, err := tree.FindDoc(id)
+		if err != nil {
+			return "<deleted>"
+		}
+		return d.Parent
+	}
+
+	assert.NoError(t, fs.TrashBlobDocument("test", doc.ID))
+	assert.Equal(t, TrashParent, parentOf(doc.ID))
+	_, err = fs.RestoreBlobDocument("test", folder.ID, "")
+	assert.ErrorIs(t, err, ErrorNotInTrash)
+	parent, err := fs.RestoreBlobDocument("test", doc.ID, folder.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, folder.ID, parent)
+	assert.Equal(t, folder.ID, parentOf(doc.ID))
+
+	// the folder is trashed or gone, back to the root
+	assert.NoError(t, fs.TrashBlobDocument("test", doc.ID))
+	assert.NoError(t, fs.TrashBlobDocument("test", folder.ID))
+	parent, err = fs.RestoreBlobDocument("test", doc.ID, folder.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "", parent)
+	assert.Equal(t, "", parentOf(doc.ID))
+	assert.NoError(t, fs.TrashBlobDocument("test", doc.ID))
+	_, err = fs.RestoreBlobDocument("test", doc.ID, "no-such-folder")
+	assert.NoError(t, err)
+	assert.Equal(t, "", parentOf(doc.ID))
+	_, err = fs.RestoreBlobDocument("test", folder.ID, "")
+	assert.NoError(t, err)
+	assert.NoError(t, fs.UpdateBlobDocument("test", doc.ID, "doc", folder.ID))
+
+	// a folder goes with its content
+	assert.NoError(t, fs.TrashBlobDocument("test", folder.ID))
+	n, err := fs.PurgeTrash("test", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n, "not old enough")
+
+	// trashed a while ago
+	tree, _ := fs.GetCachedTree("test")
+	err = UpdateTree(tree, fs.BlobStorage("test"), func(t *models.HashTree) error {
+		d, err := t.FindDoc(folder.ID)
+		if err != nil {
+			return err
+		}
+		d.LastModified = models.FromTime(time.Now().Add(-2 * time.Hour))
+		if err = writeMetadata(fs.BlobStorage("test"), d); err != nil {
+			return err
+		}
+		return t.Rehash()
+	})
+	assert.NoError(t, err)
+	n, err = fs.PurgeTrash("test", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "<deleted>", parentOf(inFolder.ID))
+
+	other, err := fs.CreateBlobDocument("test", "other.pdf", "", strings.NewReader("%PDF-1.6"))
+	assert.NoError(t, err)
+	assert.NoError(t, fs.TrashBlobDocument("test", other.ID))
+	n, err = fs.EmptyTrash("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = fs.EmptyTrash("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestExpiredTrash(t *testing.T) {
+	trashed := func(id, lastModified string) *models.HashDoc {
+		return models.NewHashDocWithMeta(id, models.MetadataFile{Parent: TrashParent, LastModified: lastModified})
+	}
+	tree := &models.HashTree{Docs: []*models.HashDoc{
+		trashed("old", models.FromTime(time.Now().Add(-2*time.Hour))),
+		trashed("new", models.FromTime(time.Now())),
+		trashed("empty", ""),
+		trashed("garbage", "yesterday"),
+		models.NewHashDocWithMeta("live", models.MetadataFile{LastModified: "0"}),
+	}}
+	assert.Equal(t, []string{"old"}, expiredTrash(tree, time.Now().Add(-time.Hour)))
+	assert.Equal(t, []string{"old", "new", "empty", "garbage"}, expiredTrash(tree, time.Time{}))
+}
@@ -5,17 +5,30 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
 
+	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/db"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	userDir     = "users"
 	profileName = ".userprofile"
+	devicesName = ".devices"
 )
 
+// userBackend where the users and their devices are kept
+type userBackend interface {
+	storage.UserStorer
+	storage.DeviceStorer
+}
+
 // NewStorage new file system storage
 func NewStorage(cfg *config.Config) *FileSystemStorage {
 	fs := &FileSystemStorage{
@@ -28,17 +41,56 @@ func NewStorage(cfg *config.Config) *FileSystemStorage {
 		log.Fatal("cannot create the user path " + usersPath)
 	}
 
+	fs.users = NewProfileStore(cfg)
+	if cfg.UserDBConfig != nil {
+		log.Info("Using ", cfg.UserDBConfig.Driver, " user storage")
+		fs.users, err = db.NewUserStore(cfg.UserDBConfig)
+		if err != nil {
+			log.Fatal("cannot open the user database ", err)
+		}
+	}
+
+	fs.blobs = &localBlobs{fs: fs}
+	if cfg.S3Config != nil {
+		log.Info("Using s3 blob storage: ", cfg.S3Config.Endpoint, " bucket: ", cfg.S3Config.Bucket)
+		fs.blobs, err = NewS3BlobBackend(cfg.S3Config)
+		if err != nil {
+			log.Fatal("cannot configure the s3 blob storage ", err)
+		}
+	}
+
 	return fs
 }
 
+// ProfileStore keeps the users as yaml profiles in their directories
+type ProfileStore struct {
+	usersPath string
+	// guards the device files
+	devicesLock sync.Mutex
+}
+
+// NewProfileStore the profile store in the data directory
+func NewProfileStore(cfg *config.Config) *ProfileStore {
+	return &ProfileStore{
+		usersPath: filepath.Join(cfg.DataDir, userDir),
+	}
+}
+
+func (p *ProfileStore) profilePath(uid string) string {
+	return filepath.Join(p.usersPath, common.SanitizeUid(uid), profileName)
+}
+
 // GetUser retrieves a user from the storage
-func (fs *FileSystemStorage) GetUser(uid string) (user *model.User, err error) {
+func (p *ProfileStore) GetUser(uid string) (user *model.User, err error) {
 	if uid == "" {
 		err = errors.New("empty user")
 		return
 	}
-	profilePath := fs.getPathFromUser(uid, profileName)
+	profilePath := p.profilePath(uid)
 	_, err = os.Stat(profilePath)
+	if os.IsNotExist(err) {
+		return nil, storage.ErrorUserNotFound
+	}
 	if err != nil {
 		return
 	}
@@ -65,16 +117,14 @@ func (fs *FileSystemStorage) GetUser(uid string) (user *model.User, err error) {
 }
 
 // GetUsers gets all users
-func (fs *FileSystemStorage) GetUsers() (users []*model.User, err error) {
-	usersDir := fs.getUserPath("")
-
-	entries, err := os.ReadDir(usersDir)
+func (p *ProfileStore) GetUsers() (users []*model.User, err error) {
+	entries, err := os.ReadDir(p.usersPath)
 	if err != nil {
 		return
 	}
 	for _, entry := range entries {
 		if entry.IsDir() {
-			if user, err := fs.GetUser(entry.Name()); err == nil {
+			if user, err := p.GetUser(entry.Name()); err == nil {
 				users = append(users, user)
 			}
 		}
@@ -82,21 +132,18 @@ func (fs *FileSystemStorage) GetUsers() (users []*model.User, err error) {
 	return
 }
 
-// RegisterUser blah
-func (fs *FileSystemStorage) RegisterUser(u *model.User) (err error) {
+// RegisterUser creates the profile, fails if it exists
+func (p *ProfileStore) RegisterUser(u *model.User) (err error) {
 	if u.ID == "" {
 		err = errors.New("empty id")
 		return
 	}
-	userBlobPath := fs.getUserBlobPath(u.ID)
-
-	// Create the user's directory
-	err = os.MkdirAll(userBlobPath, 0700)
+	profilePath := p.profilePath(u.ID)
+	err = os.MkdirAll(filepath.Dir(profilePath), 0700)
 	if err != nil {
 		return
 	}
 
-	profilePath := fs.getPathFromUser(u.ID, profileName)
 	// Create the profile file
 	js, err := u.Serialize()
 	if err != nil {
@@ -118,6 +165,204 @@ func (fs *FileSystemStorage) RegisterUser(u *model.User) (err error) {
 	return
 }
 
+// UpdateUser overwrites the profile
+func (p *ProfileStore) UpdateUser(u *model.User) (err error) {
+	if u.ID == "" {
+		err = errors.New("empty id")
+		return
+	}
+	profilePath := p.profilePath(u.ID)
+	err = os.MkdirAll(filepath.Dir(profilePath), 0700)
+	if err != nil {
+		return
+	}
+
+	js, err := u.Serialize()
+	if err != nil {
+		return
+	}
+	return os.WriteFile(profilePath, js, 0600)
+}
+
+// RemoveUser removes the profile
+func (p *ProfileStore) RemoveUser(uid string) error {
+	if uid == "" {
+		return errors.New("empty id")
+	}
+	err := os.Remove(p.profilePath(uid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (p *ProfileStore) readDevices(uid string) ([]*model.Device, error) {
+	devices := make([]*model.Device, 0)
+	b, err := os.ReadFile(filepath.Join(p.usersPath, common.SanitizeUid(uid), devicesName))
+	if os.IsNotExist(err) {
+		return devices, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(b, &devices)
+	return devices, err
+}
+
+// GetDevices lists the devices of the user
+func (p *ProfileStore) GetDevices(uid string) ([]*model.Device, error) {
+	p.devicesLock.Lock()
+	defer p.devicesLock.Unlock()
+	return p.readDevices(uid)
+}
+
+// GetDevice gets a device of the user
+func (p *ProfileStore) GetDevice(uid, deviceID string) (*model.Device, error) {
+	devices, err := p.GetDevices(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return d, nil
+		}
+	}
+	return nil, storage.ErrorDeviceNotFound
+}
+
+// UpdateDevice adds or replaces a device of the user
+func (p *ProfileStore) UpdateDevice(uid string, device *model.Device) error {
+	p.devicesLock.Lock()
+	defer p.devicesLock.Unlock()
+	devices, err := p.readDevices(uid)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i, d := range devices {
+		if d.ID == device.ID {
+			devices[i] = device
+			found = true
+		}
+	}
+	if !found {
+		devices = append(devices, device)
+	}
+	return p.writeDevices(uid, devices)
+}
+
+// ChangeDevice changes a device of the user while holding the lock
+func (p *ProfileStore) ChangeDevice(uid, deviceID string, create bool, change func(*model.Device)) (*model.Device, error) {
+	p.devicesLock.Lock()
+	defer p.devicesLock.Unlock()
+	devices, err := p.readDevices(uid)
+	if err != nil {
+		return nil, err
+	}
+	var device *model.Device
+	for _, d := range devices {
+		if d.ID == deviceID {
+			device = d
+		}
+	}
+	if device == nil {
+		if !create {
+			return nil, storage.ErrorDeviceNotFound
+		}
+		device = &model.Device{ID: deviceID}
+		devices = append(devices, device)
+	}
+	change(device)
+	if err = p.writeDevices(uid, devices); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (p *ProfileStore) writeDevices(uid string, devices []*model.Device) error {
+	b, err := yaml.Marshal(devices)
+	if err != nil {
+		return err
+	}
+	userPath := filepath.Join(p.usersPath, common.SanitizeUid(uid))
+	if err = os.MkdirAll(userPath, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(userPath, devicesName), b, 0600)
+}
+
+// UserStore the store where the users are kept
+func (fs *FileSystemStorage) UserStore() storage.UserStorer {
+	return fs.users
+}
+
+// GetDevices lists the devices of the user
+func (fs *FileSystemStorage) GetDevices(uid string) ([]*model.Device, error) {
+	return fs.users.GetDevices(uid)
+}
+
+// GetDevice gets a device of the user
+func (fs *FileSystemStorage) GetDevice(uid, deviceID string) (*model.Device, error) {
+	return fs.users.GetDevice(uid, deviceID)
+}
+
+// UpdateDevice adds or replaces a device of the user
+func (fs *FileSystemStorage) UpdateDevice(uid string, device *model.Device) error {
+	return fs.users.UpdateDevice(uid, device)
+}
+
+// ChangeDevice changes a device of the user in one step
+func (fs *FileSystemStorage) ChangeDevice(uid, deviceID string, create bool, change func(*model.Device)) (*model.Device, error) {
+	return fs.users.ChangeDevice(uid, deviceID, create, change)
+}
+
+// GetUser retrieves a user from the storage, with the secrets decrypted
+func (fs *FileSystemStorage) GetUser(uid string) (*model.User, error) {
+	u, err := fs.users.GetUser(uid)
+	if err != nil {
+		return nil, err
+	}
+	if err = fs.decryptUser(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetUsers gets all users, a user whose secrets can't be decrypted keeps them encrypted
+func (fs *FileSystemStorage) GetUsers() ([]*model.User, error) {
+	users, err := fs.users.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if err := fs.decryptUser(u); err != nil {
+			log.Warn(err)
+		}
+	}
+	return users, nil
+}
+
+// RegisterUser blah
+func (fs *FileSystemStorage) RegisterUser(u *model.User) (err error) {
+	if u.ID == "" {
+		err = errors.New("empty id")
+		return
+	}
+	userBlobPath := fs.getUserBlobPath(u.ID)
+
+	// Create the user's directory
+	err = os.MkdirAll(userBlobPath, 0700)
+	if err != nil {
+		return
+	}
+
+	u, err = fs.encryptUser(u)
+	if err != nil {
+		return
+	}
+	return fs.users.RegisterUser(u)
+}
+
 // UpdateUser updates the user
 func (fs *FileSystemStorage) UpdateUser(u *model.User) (err error) {
 	if u.ID == "" {
@@ -131,15 +376,11 @@ func (fs *FileSystemStorage) UpdateUser(u *model.User) (err error) {
 		return
 	}
 
-	profilePath := fs.getPathFromUser(u.ID, profileName)
-	// Overwrite the profile
-	js, err := u.Serialize()
+	u, err = fs.encryptUser(u)
 	if err != nil {
 		return
 	}
-	err = os.WriteFile(profilePath, js, 0600)
-
-	return
+	return fs.users.UpdateUser(u)
 }
 
 // RemoveUser remove the user and their data
@@ -149,11 +390,12 @@ func (fs *FileSystemStorage) RemoveUser(uid string) (err error) {
 		return
 	}
 
-	userSyncPath := fs.getUserPath(uid)
-	err = os.RemoveAll(userSyncPath)
+	err = fs.users.RemoveUser(uid)
 	if err != nil {
 		return
 	}
+	fs.dropVersions(uid)
 
-	return
+	userSyncPath := fs.getUserPath(uid)
+	return os.RemoveAll(userSyncPath)
 }
@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevices(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir})
+
+	devices, err := fs.GetDevices("test")
+	assert.NoError(t, err)
+	assert.Empty(t, devices)
+	_, err = fs.GetDevice("test", "rm1")
+	assert.ErrorIs(t, err, storage.ErrorDeviceNotFound)
+
+	paired := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, fs.UpdateDevice("test", &model.Device{ID: "rm1", Description: "remarkable", PairedAt: paired}))
+	assert.NoError(t, fs.UpdateDevice("test", &model.Device{ID: "rm2", Description: "desktop-windows"}))
+	assert.NoError(t, fs.UpdateDevice("test", &model.Device{ID: "rm1", Description: "remarkable", Name: "rm2 at home", PairedAt: paired, Revoked: true}))
+
+	device, err := fs.GetDevice("test", "rm1")
+	assert.NoError(t, err)
+	assert.Equal(t, "rm2 at home", device.Name)
+	assert.True(t, device.Revoked)
+	assert.True(t, paired.Equal(device.PairedAt))
+
+	devices, err = fs.GetDevices("test")
+	assert.NoError(t, err)
+	assert.Len(t, devices, 2)
+
+	_, err = fs.ChangeDevice("test", "rm3", false, func(d *model.Device) {})
+	assert.ErrorIs(t, err, storage.ErrorDeviceNotFound)
+
+	// the devices syncing don't undo the revocation
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fs.ChangeDevice("test", "rm2", true, func(d *model.Device) { d.LastSeen = time.Now() })
+			assert.NoError(t, err)
+		}()
+	}
+	_, err = fs.ChangeDevice("test", "rm2", false, func(d *model.Device) { d.Revoked = true })
+	assert.NoError(t, err)
+	wg.Wait()
+	device, err = fs.GetDevice("test", "rm2")
+	assert.NoError(t, err)
+	assert.True(t, device.Revoked)
+	assert.Equal(t, "desktop-windows", device.Description)
+}
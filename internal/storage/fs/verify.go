@@ -0,0 +1,61 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+)
+
+// verifyingReader hashes the content while it is read and
+// fails the last read when the content does not match
+type verifyingReader struct {
+	r           io.Reader
+	sha         hash.Hash
+	crc         hash.Hash32
+	expectedSHA string
+	expectedCRC string
+}
+
+// NewVerifyingReader returns a reader which fails with ErrorHashMismatch at EOF
+// when the sha256 of the content is not blobID or the crc32c (base64) does not match,
+// an empty crc32c is not checked
+func NewVerifyingReader(r io.Reader, blobID, crc32c string) io.Reader {
+	return &verifyingReader{
+		r:           r,
+		sha:         sha256.New(),
+		crc:         common.CRC32CWriter(),
+		expectedSHA: blobID,
+		expectedCRC: crc32c,
+	}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.sha.Write(p[:n])
+	v.crc.Write(p[:n])
+	if err == io.EOF {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) verify() error {
+	sha := hex.EncodeToString(v.sha.Sum(nil))
+	if sha != v.expectedSHA {
+		return fmt.Errorf("%w: sha256 %s, expected %s", ErrorHashMismatch, sha, v.expectedSHA)
+	}
+	if v.expectedCRC == "" {
+		return nil
+	}
+	crc := common.CRC32CSum(v.crc)
+	if crc != v.expectedCRC {
+		return fmt.Errorf("%w: crc32c %s, expected %s", ErrorHashMismatch, crc, v.expectedCRC)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestStoreBlobVerified(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rmfake-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := NewStorage(&config.Config{DataDir: dir})
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "some content"
+	blobID, _, _ := models.Hash(strings.NewReader(content))
+	crc, _ := common.CRC32CFromReader(strings.NewReader(content))
+
+	_, err = fs.StoreBlob("test", blobID, NewVerifyingReader(strings.NewReader("other content"), blobID, ""), 0)
+	assert.True(t, errors.Is(err, ErrorHashMismatch))
+	_, err = fs.StatBlob("test", blobID)
+	assert.Equal(t, ErrorNotFound, err)
+
+	_, err = fs.StoreBlob("test", blobID, NewVerifyingReader(strings.NewReader(content), blobID, "AAAAAA=="), 0)
+	assert.True(t, errors.Is(err, ErrorHashMismatch))
+
+	// a dropped connection leaves nothing behind
+	_, err = fs.StoreBlob("test", blobID, io.MultiReader(strings.NewReader(content), failingReader{}), 0)
+	assert.Error(t, err)
+	entries, _ := os.ReadDir(fs.getUserBlobPath("test"))
+	assert.Empty(t, entries)
+
+	_, err = fs.StoreBlob("test", blobID, NewVerifyingReader(strings.NewReader(content), blobID, crc), 0)
+	assert.NoError(t, err)
+	size, err := fs.StatBlob("test", blobID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+}
+
+func TestUploadBlobVerified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "rmfake-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{DataDir: dir, JWTSecretKey: []byte("secret"), StorageURL: "http://rmfakecloud.test"}
+	fs := NewStorage(cfg)
+	if err = os.MkdirAll(fs.getUserBlobPath("test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.UpdateUser(&model.User{ID: "test", Sync15: true}); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	NewApp(cfg, fs).RegisterRoutes(router)
+
+	content := "some content"
+	blobID, _, _ := models.Hash(strings.NewReader(content))
+	crc, _ := common.CRC32CFromReader(strings.NewReader(content))
+	upload := func(id, body, crc string) int {
+		u, _, err := fs.GetBlobURL("test", id, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPut, u, strings.NewReader(body))
+		if crc != "" {
+			req.Header.Set(common.GCPHashHeader, "crc32c="+crc)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, upload(blobID, "other content", ""))
+	assert.Equal(t, http.StatusBadRequest, upload(blobID, content, "AAAAAA=="))
+	assert.Equal(t, http.StatusBadRequest, upload("notahash", content, ""))
+	_, err = fs.StatBlob("test", blobID)
+	assert.Equal(t, ErrorNotFound, err)
+
+	assert.Equal(t, http.StatusOK, upload(blobID, content, crc))
+	_, err = fs.StatBlob("test", blobID)
+	assert.NoError(t, err)
+}
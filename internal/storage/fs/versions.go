@@ -0,0 +1,215 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadVersion loads a document from its index hash
+func loadVersion(ls *LocalBlobStorage, docID, version string) (*models.HashDoc, error) {
+	doc := &models.HashDoc{}
+	err := doc.Mirror(&models.HashEntry{Hash: version, EntryName: docID}, ls)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// docVersions the versions of a document found in the first count roots of the history.
+// The history only grows, so the next listing only reads the roots added since
+type docVersions struct {
+	sync.Mutex
+	count int
+	// the hash of the last root read, the history was replaced if it changed
+	last     string
+	previous string
+	seen     map[string]bool
+	// oldest first
+	versions []*storage.DocumentVersion
+}
+
+func (c *docVersions) reset() {
+	c.count = 0
+	c.last = ""
+	c.previous = ""
+	c.seen = make(map[string]bool)
+	c.versions = nil
+}
+
+// add reads the document entry of a root
+func (c *docVersions) add(ls *LocalBlobStorage, docID string, h *models.RootHistory) error {
+	entry, err := h.DocEntry(ls, docID)
+	if err == ErrorNotFound {
+		// garbage collected
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		c.previous = ""
+		return nil
+	}
+	if entry.Hash == c.previous {
+		return nil
+	}
+	c.previous = entry.Hash
+	// reverted to an older version
+	if c.seen[entry.Hash] {
+		return nil
+	}
+	c.seen[entry.Hash] = true
+
+	doc, err := loadVersion(ls, docID, entry.Hash)
+	if err != nil {
+		log.Warnf("version %s of %s: %v", entry.Hash, docID, err)
+		return nil
+	}
+	if doc.Deleted {
+		return nil
+	}
+	v := &storage.DocumentVersion{
+		Version:    entry.Hash,
+		Generation: generationOf(h),
+		Date:       h.Date,
+		Name:       doc.DocumentName,
+		Size:       doc.Size,
+	}
+	v.LastModified, _ = models.ToTime(doc.LastModified)
+	c.versions = append(c.versions, v)
+	return nil
+}
+
+// dropVersions forgets the versions of the documents of a user, after blobs were deleted
+func (fs *FileSystemStorage) dropVersions(uid string) {
+	prefix := uid + "/"
+	fs.versionCache.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			fs.versionCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// DocumentVersions lists the distinct versions of a document in the root history, newest first
+func (fs *FileSystemStorage) DocumentVersions(uid, docID string) ([]*storage.DocumentVersion, error) {
+	history, err := fs.blobs.RootHistory(uid)
+	if err != nil {
+		return nil, err
+	}
+	ls := fs.BlobStorage(uid)
+	currentHash, _, err := ls.GetRootIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	cached, _ := fs.versionCache.LoadOrStore(uid+"/"+docID, &docVersions{})
+	c := cached.(*docVersions)
+	c.Lock()
+	defer c.Unlock()
+	if c.seen == nil || c.count > len(history) || (c.count > 0 && history[c.count-1].Hash != c.last) {
+		c.reset()
+	}
+	for i := c.count; i < len(history); i++ {
+		if err = c.add(ls, docID, history[i]); err != nil {
+			return nil, err
+		}
+		c.count = i + 1
+		c.last = history[i].Hash
+	}
+
+	currentVersion := ""
+	current := &models.RootHistory{Hash: currentHash}
+	if entry, err := current.DocEntry(ls, docID); err == nil && entry != nil {
+		currentVersion = entry.Hash
+	}
+
+	// newest first
+	versions := make([]*storage.DocumentVersion, 0, len(c.versions))
+	for i := len(c.versions) - 1; i >= 0; i-- {
+		v := *c.versions[i]
+		v.Current = v.Version == currentVersion
+		versions = append(versions, &v)
+	}
+	return versions, nil
+}
+
+// findVersion checks that the version belongs to the document and all its blobs are present
+func (fs *FileSystemStorage) findVersion(uid, docID, version string) (*models.HashDoc, error) {
+	versions, err := fs.DocumentVersions(uid, docID)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, v := range versions {
+		if v.Version == version {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrorNotFound
+	}
+
+	ls := fs.BlobStorage(uid)
+	doc, err := loadVersion(ls, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range doc.Files {
+		present, err := ls.Exists(f.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if !present {
+			return nil, fmt.Errorf("version %s, %w", version, ErrorBlobsMissing)
+		}
+	}
+	return doc, nil
+}
+
+// ExportVersion exports a specific version of a document
+func (fs *FileSystemStorage) ExportVersion(uid, docID, version, exporttype string) (io.ReadCloser, error) {
+	doc, err := fs.findVersion(uid, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	if exporttype == "rmdoc" {
+		return exportRmDoc(doc, fs.BlobStorage(uid)), nil
+	}
+	return exportPdf(doc, fs.BlobStorage(uid))
+}
+
+// RestoreDocumentVersion replaces the document in the current tree with an older version,
+// a deleted document is added again
+func (fs *FileSystemStorage) RestoreDocumentVersion(uid, docID, version string) error {
+	doc, err := fs.findVersion(uid, docID, version)
+	if err != nil {
+		return err
+	}
+	tree, err := fs.GetCachedTree(uid)
+	if err != nil {
+		return err
+	}
+	if current, err := tree.FindDoc(docID); err == nil && current.Hash == version {
+		log.Info(docID, " is already at version ", version)
+		return nil
+	}
+	ls := fs.BlobStorage(uid)
+
+	log.Info("restoring ", docID, " version ", version)
+	return updateTree(tree, ls, func(t *models.HashTree) error {
+		if _, err := t.FindDoc(docID); err == nil {
+			if err = t.Remove(docID); err != nil {
+				return err
+			}
+		}
+		return t.Add(doc)
+	})
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
 	"github.com/juruen/rmapi/archive"
-	"github.com/juruen/rmapi/encoding/rm"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,6 +22,7 @@ func ArchiveFromHashDoc(doc *HashDoc, rs RemoteStorage) (*exporter.MyArchive, er
 	}
 
 	pageMap := make(map[string]string)
+	var pageIDs []string
 	for _, f := range doc.Files {
 		filext := path.Ext(f.EntryName)
 		name := strings.TrimSuffix(path.Base(f.EntryName), filext)
@@ -41,6 +41,10 @@ func ArchiveFromHashDoc(doc *HashDoc, rs RemoteStorage) (*exporter.MyArchive, er
 			if err != nil {
 				return nil, err
 			}
+			pageIDs, err = exporter.ContentPageIDs(contentBytes)
+			if err != nil {
+				return nil, err
+			}
 		case storage.EpubFileExt:
 			fallthrough
 		case storage.PdfFileExt:
@@ -65,29 +69,28 @@ func ArchiveFromHashDoc(doc *HashDoc, rs RemoteStorage) (*exporter.MyArchive, er
 		}
 	}
 
-	for _, p := range a.Content.Pages {
-		if hash, ok := pageMap[p]; ok {
-			log.Debug("page ", hash)
-			reader, err := rs.GetReader(hash)
-			if err != nil {
-				return nil, err
-			}
-			pageBin, err := io.ReadAll(reader)
-			if err != nil {
-				return nil, err
-			}
-			rmpage := rm.New()
-			err = rmpage.UnmarshalBinary(pageBin)
-			if err != nil {
-				return nil, err
-			}
-
-			page := archive.Page{
-				Data:     rmpage,
-				Pagedata: "Blank",
-			}
-			a.Pages = append(a.Pages, page)
+	for i, p := range pageIDs {
+		hash, ok := pageMap[p]
+		if !ok {
+			// a page without annotations
+			a.SetPage(i, nil, nil)
+			continue
+		}
+		log.Debug("page ", hash)
+		reader, err := rs.GetReader(hash)
+		if err != nil {
+			return nil, err
+		}
+		pageBin, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		rmpage, text, err := exporter.ReadPage(pageBin)
+		if err != nil {
+			return nil, err
 		}
+		a.SetPage(i, rmpage, text)
 	}
 
 	return &a, nil
@@ -61,22 +61,18 @@ func FileHashAndSize(file string) ([]byte, int64, error) {
 	return h, size, err
 }
 
-// LoadTree loads a cached tree to avoid parsing all the blobs
-func LoadTree(cacheFile string) (*HashTree, error) {
+// ReadTree reads a cached tree to avoid parsing all the blobs
+func ReadTree(r io.Reader) (*HashTree, error) {
 	tree := HashTree{}
-	if _, err := os.Stat(cacheFile); err == nil {
-		b, err := os.ReadFile(cacheFile)
-		if err != nil {
-			return nil, err
-		}
-		err = json.Unmarshal(b, &tree)
-		if err != nil {
-			log.Warn("cached tree corrupt, returning empty tree")
-			return &HashTree{}, nil
-		}
-		log.Info("cached tree loaded: ", cacheFile)
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &tree)
+	if err != nil {
+		log.Warn("cached tree corrupt, returning empty tree")
+		return &HashTree{}, nil
 	}
-
 	return &tree, nil
 }
 
@@ -91,6 +87,16 @@ func (t *HashTree) Save(cacheFile string) error {
 	return err
 }
 
+// Write writes the cached tree
+func (t *HashTree) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(t, "", "")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
 func parseEntry(line string) (*HashEntry, error) {
 	entry := HashEntry{}
 	rdr := NewFieldReader(line)
@@ -21,7 +21,12 @@ func ReadRootHistory(filename string) (history []*RootHistory, err error) {
 	}
 	defer fd.Close()
 
-	scanner := bufio.NewScanner(fd)
+	return ParseRootHistory(fd)
+}
+
+// ParseRootHistory parses the root modification log, one "date hash" per line
+func ParseRootHistory(r io.Reader) (history []*RootHistory, err error) {
+	scanner := bufio.NewScanner(r)
 	var i int64 = 0
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -51,6 +56,39 @@ func (h *RootHistory) OpenIndex(r RemoteStorage) (io.ReadCloser, error) {
 	return r.GetReader(h.Hash)
 }
 
+// Entries the document entries of the root index
+func (h *RootHistory) Entries(r RemoteStorage) ([]*HashEntry, error) {
+	rootFile, err := h.OpenIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	defer rootFile.Close()
+	return parseIndex(rootFile)
+}
+
+// DocumentCount the number of entries in the root index
+func (h *RootHistory) DocumentCount(r RemoteStorage) (int, error) {
+	entries, err := h.Entries(r)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// DocEntry the entry of a document in the root index, nil if it's not in this root
+func (h *RootHistory) DocEntry(r RemoteStorage, docID string) (*HashEntry, error) {
+	entries, err := h.Entries(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.EntryName == docID {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
 func (h *RootHistory) GetHashTree(r RemoteStorage) (t *HashTree, err error) {
 	t = &HashTree{
 		Hash:       h.Hash,
@@ -0,0 +1,79 @@
+package models
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// MissingBlobs walks the current tree (root index -> doc indexes -> files)
+// and returns the hashes that are referenced but not present in the storage
+func MissingBlobs(r RemoteStorage, c BlobChecker) ([]string, error) {
+	rootHash, _, err := r.GetRootIndex()
+	if err != nil {
+		return nil, err
+	}
+	return MissingBlobsFrom(r, c, rootHash)
+}
+
+// MissingBlobsFrom same as MissingBlobs for any root index
+func MissingBlobsFrom(r RemoteStorage, c BlobChecker, rootHash string) ([]string, error) {
+	missing := make([]string, 0)
+	if rootHash == "" {
+		return missing, nil
+	}
+
+	checked := make(map[string]bool)
+	// check reports if the hash is present, adding it to missing otherwise
+	check := func(hash string) (bool, error) {
+		if present, ok := checked[hash]; ok {
+			return present, nil
+		}
+		present, err := c.Exists(hash)
+		if err != nil {
+			return false, err
+		}
+		checked[hash] = present
+		if !present {
+			missing = append(missing, hash)
+		}
+		return present, nil
+	}
+
+	// the index can be present but truncated, report it so that it gets uploaded again
+	readIndex := func(hash string) ([]*HashEntry, error) {
+		present, err := check(hash)
+		if err != nil || !present {
+			return nil, err
+		}
+		rdr, err := r.GetReader(hash)
+		if err != nil {
+			return nil, err
+		}
+		defer rdr.Close()
+		entries, err := parseIndex(rdr)
+		if err != nil {
+			log.Warnf("index %s is corrupt, %v", hash, err)
+			checked[hash] = false
+			missing = append(missing, hash)
+			return nil, nil
+		}
+		return entries, nil
+	}
+
+	docs, err := readIndex(rootHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		files, err := readIndex(doc.Hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if _, err := check(f.Hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return missing, nil
+}
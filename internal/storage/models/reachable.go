@@ -0,0 +1,46 @@
+package models
+
+import (
+	"fmt"
+)
+
+// MarkReachable marks the root index, the doc indexes and the files reachable from rootHash,
+// indexes already marked are not read again, missing indexes are skipped
+// but an unreadable one is an error so that nothing gets swept by accident
+func MarkReachable(r RemoteStorage, c BlobChecker, rootHash string, marked map[string]bool) error {
+	readIndex := func(hash string) ([]*HashEntry, error) {
+		if marked[hash] {
+			return nil, nil
+		}
+		marked[hash] = true
+		present, err := c.Exists(hash)
+		if err != nil || !present {
+			return nil, err
+		}
+		rdr, err := r.GetReader(hash)
+		if err != nil {
+			return nil, err
+		}
+		defer rdr.Close()
+		entries, err := parseIndex(rdr)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", hash, err)
+		}
+		return entries, nil
+	}
+
+	docs, err := readIndex(rootHash)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		files, err := readIndex(doc.Hash)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			marked[f.Hash] = true
+		}
+	}
+	return nil
+}
@@ -18,3 +18,8 @@ type RemoteStorageWriter interface {
 	WriteRootIndex(generation int64, hash string) (gen int64, err error)
 	Write(hash string, reader io.Reader) error
 }
+
+// BlobChecker checks for the presence of blobs without reading them
+type BlobChecker interface {
+	Exists(hash string) (bool, error)
+}
@@ -0,0 +1,396 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	service       = "s3"
+	algorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	dateFormat    = "20060102"
+	metaPrefix    = "x-amz-meta-"
+	emptyHash     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// DefaultRegion used when none is configured, minio ignores it
+	DefaultRegion = "us-east-1"
+)
+
+// ErrNotFound the object does not exist
+var ErrNotFound = errors.New("s3: object not found")
+
+// ErrPreconditionFailed a conditional write did not match
+var ErrPreconditionFailed = errors.New("s3: precondition failed")
+
+// Config s3 compatible object store configuration
+type Config struct {
+	// Endpoint full url of the service eg http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix prepended to all keys
+	Prefix string
+	// VirtualHost use bucket.host addressing instead of host/bucket
+	VirtualHost bool
+}
+
+// ObjectInfo information about a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// PutOptions conditions and metadata for a write
+type PutOptions struct {
+	// IfMatch only write if the current etag matches
+	IfMatch string
+	// IfNoneMatch "*" only write if the object does not exist
+	IfNoneMatch string
+	Metadata    map[string]string
+}
+
+// Client a minimal s3 client (sigv4, path or virtual host style)
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	http     *http.Client
+}
+
+// New creates a client
+func New(cfg *Config) (*Client, error) {
+	if cfg == nil {
+		return nil, errors.New("s3: no config")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: no bucket")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: cannot parse endpoint %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("s3: endpoint '%s' needs a scheme and host", cfg.Endpoint)
+	}
+	c := &Client{
+		cfg:      *cfg,
+		endpoint: u,
+		http:     &http.Client{},
+	}
+	if c.cfg.Region == "" {
+		c.cfg.Region = DefaultRegion
+	}
+	return c, nil
+}
+
+// Get opens an object
+func (c *Client) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	resp, err := c.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, objectInfo(key, resp), nil
+}
+
+// Head returns the object info without the content
+func (c *Client) Head(key string) (*ObjectInfo, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(key, resp), nil
+}
+
+// Put writes an object
+func (c *Client) Put(key string, body io.ReadSeeker, size int64, opts *PutOptions) (*ObjectInfo, error) {
+	headers := http.Header{}
+	if opts != nil {
+		if opts.IfMatch != "" {
+			headers.Set("If-Match", opts.IfMatch)
+		}
+		if opts.IfNoneMatch != "" {
+			headers.Set("If-None-Match", opts.IfNoneMatch)
+		}
+		for k, v := range opts.Metadata {
+			headers.Set(metaPrefix+strings.ToLower(k), v)
+		}
+	}
+	resp, err := c.doWithBody(http.MethodPut, key, nil, headers, body, size)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	info := objectInfo(key, resp)
+	info.Size = size
+	return info, nil
+}
+
+// Delete removes an object, missing objects are not an error
+func (c *Client) Delete(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified time.Time
+	}
+}
+
+// List lists all objects starting with prefix
+func (c *Client) List(prefix string) ([]ObjectInfo, error) {
+	var result []ObjectInfo
+	token := ""
+	fullPrefix := c.cfg.Prefix + prefix
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {fullPrefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.doBucket(http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: cannot parse list result %w", err)
+		}
+		for _, o := range page.Contents {
+			result = append(result, ObjectInfo{
+				Key:          strings.TrimPrefix(o.Key, c.cfg.Prefix),
+				Size:         o.Size,
+				ETag:         o.ETag,
+				LastModified: o.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	return result, nil
+}
+
+func objectInfo(key string, resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:      key,
+		Size:     resp.ContentLength,
+		ETag:     resp.Header.Get("ETag"),
+		Metadata: make(map[string]string),
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" {
+		info.LastModified, _ = http.ParseTime(lm)
+	}
+	for k, v := range resp.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, metaPrefix) && len(v) > 0 {
+			info.Metadata[strings.TrimPrefix(lk, metaPrefix)] = v[0]
+		}
+	}
+	return info
+}
+
+func (c *Client) do(method, key string, query url.Values, headers http.Header, body []byte) (*http.Response, error) {
+	var rs io.ReadSeeker
+	if body != nil {
+		rs = bytes.NewReader(body)
+	}
+	return c.doWithBody(method, key, query, headers, rs, int64(len(body)))
+}
+
+func (c *Client) doWithBody(method, key string, query url.Values, headers http.Header, body io.ReadSeeker, size int64) (*http.Response, error) {
+	return c.doBucket(method, c.cfg.Prefix+key, query, headers, body, size)
+}
+
+func (c *Client) doBucket(method, key string, query url.Values, headers http.Header, body io.ReadSeeker, size int64) (*http.Response, error) {
+	host := c.endpoint.Host
+	escapedPath := strings.TrimSuffix(c.endpoint.EscapedPath(), "/")
+	if c.cfg.VirtualHost {
+		host = c.cfg.Bucket + "." + host
+	} else {
+		escapedPath += "/" + uriEncode(c.cfg.Bucket, true)
+	}
+	if key != "" {
+		escapedPath += "/" + uriEncode(key, false)
+	}
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+
+	payloadHash := emptyHash
+	if body != nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	rawURL := c.endpoint.Scheme + "://" + host + escapedPath
+	if len(query) > 0 {
+		rawURL += "?" + canonicalQuery(query)
+	}
+	var reqBody io.Reader
+	if body != nil && size > 0 {
+		// the transport closes the body, the caller owns it
+		reqBody = io.NopCloser(body)
+	}
+	req, err := http.NewRequest(method, rawURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if reqBody != nil {
+		req.ContentLength = size
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	req.Host = host
+
+	c.sign(req, escapedPath, query, payloadHash, time.Now().UTC())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return nil, ErrPreconditionFailed
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3: %s %s: %d %s", method, key, resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// sign adds the aws signature v4 headers
+func (c *Client) sign(req *http.Request, escapedPath string, query url.Values, payloadHash string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	date := now.Format(dateFormat)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := []string{"host"}
+	canonicalHeaders := map[string]string{"host": req.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "if-match" || lk == "if-none-match" {
+			signed = append(signed, lk)
+			canonicalHeaders[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(signed)
+
+	var hb strings.Builder
+	for _, k := range signed {
+		hb.WriteString(k)
+		hb.WriteString(":")
+		hb.WriteString(canonicalHeaders[k])
+		hb.WriteString("\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		canonicalQuery(query),
+		hb.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.cfg.Region + "/" + service + "/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), date)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, c.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string{}, query[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes as required by sigv4, everything but the unreserved chars
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			sb.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(b)|0x100, 16)[1:]))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,83 @@
+package s3_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/s3"
+	"github.com/ddvk/rmfakecloud/internal/storage/s3/s3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutGetConditional(t *testing.T) {
+	srv := s3test.NewServer("bucket")
+	defer srv.Close()
+	cfg := srv.Config()
+	cfg.Prefix = "pre/"
+	c, err := s3.New(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = c.Head("users/a@b.c/sync/root")
+	assert.Equal(t, s3.ErrNotFound, err)
+
+	info, err := c.Put("users/a@b.c/sync/root", strings.NewReader("hash1"), 5, &s3.PutOptions{
+		IfNoneMatch: "*",
+		Metadata:    map[string]string{"generation": "1"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"pre/users/a@b.c/sync/root"}, srv.Keys())
+
+	_, err = c.Put("users/a@b.c/sync/root", strings.NewReader("hash2"), 5, &s3.PutOptions{IfNoneMatch: "*"})
+	assert.Equal(t, s3.ErrPreconditionFailed, err)
+
+	_, err = c.Put("users/a@b.c/sync/root", strings.NewReader("hash2"), 5, &s3.PutOptions{IfMatch: `"other"`})
+	assert.Equal(t, s3.ErrPreconditionFailed, err)
+
+	_, err = c.Put("users/a@b.c/sync/root", strings.NewReader("hash2"), 5, &s3.PutOptions{
+		IfMatch:  info.ETag,
+		Metadata: map[string]string{"generation": "2"},
+	})
+	assert.NoError(t, err)
+
+	r, got, err := c.Get("users/a@b.c/sync/root")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	content, _ := io.ReadAll(r)
+	assert.Equal(t, "hash2", string(content))
+	assert.Equal(t, "2", got.Metadata["generation"])
+	assert.Equal(t, int64(5), got.Size)
+
+	assert.NoError(t, c.Delete("users/a@b.c/sync/root"))
+	assert.NoError(t, c.Delete("users/a@b.c/sync/root"))
+}
+
+func TestListPaged(t *testing.T) {
+	srv := s3test.NewServer("bucket")
+	defer srv.Close()
+	srv.MaxKeys = 2
+	c, err := s3.New(srv.Config())
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 5; i++ {
+		_, err := c.Put(fmt.Sprintf("a/%d", i), strings.NewReader("x"), 1, nil)
+		assert.NoError(t, err)
+	}
+	_, err = c.Put("b/0", nil, 0, nil)
+	assert.NoError(t, err)
+
+	list, err := c.List("a/")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 5) {
+		assert.Equal(t, "a/4", list[4].Key)
+		assert.Equal(t, int64(1), list[4].Size)
+	}
+}
@@ -0,0 +1,240 @@
+// Package s3test provides an in-process fake of the s3 api subset used by rmfakecloud
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/storage/s3"
+)
+
+const metaPrefix = "X-Amz-Meta-"
+
+type object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+	meta     http.Header
+}
+
+// Server fake s3 server, path style addressing, a single bucket, no auth checks
+type Server struct {
+	*httptest.Server
+	Bucket string
+	// MaxKeys page size for listings
+	MaxKeys int
+	// FailPrefix the writes of the keys with this prefix fail, if set
+	FailPrefix string
+
+	mu      sync.Mutex
+	objects map[string]*object
+}
+
+// NewServer starts a fake s3 server with an empty bucket
+func NewServer(bucket string) *Server {
+	s := &Server{
+		Bucket:  bucket,
+		MaxKeys: 1000,
+		objects: make(map[string]*object),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Config a client config pointing to this server
+func (s *Server) Config() *s3.Config {
+	return &s3.Config{
+		Endpoint:  s.URL,
+		Bucket:    s.Bucket,
+		AccessKey: "test",
+		SecretKey: "testsecret",
+	}
+}
+
+// Keys returns all stored keys sorted
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Object returns the content of a stored object
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return o.data, true
+}
+
+// Delete removes an object
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(p, "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == "" {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			s.list(w, r)
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		o, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range o.meta {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Last-Modified", o.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	case http.MethodPut:
+		if s.FailPrefix != "" && strings.HasPrefix(key, s.FailPrefix) {
+			writeError(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		existing, exists := s.objects[key]
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			if !exists || existing.etag != ifMatch {
+				writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		sum := md5.Sum(data)
+		o := &object{
+			data:     data,
+			etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+			modified: time.Now().UTC(),
+			meta:     http.Header{},
+		}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, metaPrefix) {
+				o.meta[k] = v
+			}
+		}
+		s.objects[key] = o
+		w.Header().Set("ETag", o.etag)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+type listContent struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []listContent
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	after := r.URL.Query().Get("continuation-token")
+
+	keys := make([]string, 0)
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listResult{
+		Name:    s.Bucket,
+		Prefix:  prefix,
+		MaxKeys: s.MaxKeys,
+	}
+	if len(keys) > s.MaxKeys {
+		keys = keys[:s.MaxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		o := s.objects[k]
+		result.Contents = append(result.Contents, listContent{
+			Key:          k,
+			LastModified: o.modified,
+			ETag:         o.etag,
+			Size:         int64(len(o.data)),
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
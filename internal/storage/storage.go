@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"io"
 	"time"
 
@@ -9,6 +10,21 @@ import (
 	"github.com/ddvk/rmfakecloud/internal/model"
 )
 
+// ErrorQuotaExceeded the user has no space left
+var ErrorQuotaExceeded = errors.New("storage quota exceeded")
+
+// ErrorCodeNotFound the pairing code is wrong or expired
+var ErrorCodeNotFound = errors.New("code not found")
+
+// ErrorUserNotFound the user does not exist
+var ErrorUserNotFound = errors.New("user not found")
+
+// ErrorDeviceNotFound the device was never seen
+var ErrorDeviceNotFound = errors.New("device not found")
+
+// ErrorTooManyAttempts too many wrong pairing codes from the same client
+var ErrorTooManyAttempts = errors.New("too many attempts")
+
 // ExportOption type of export
 type ExportOption int
 
@@ -35,6 +51,7 @@ type BlobStorage interface {
 
 	StoreBlob(uid, blobID string, s io.Reader, matchGeneration int64) (int64, error)
 	LoadBlob(uid, blobID string) (reader io.ReadCloser, gen int64, size int64, crc32c string, err error)
+	MissingBlobs(uid string) (hashes []string, err error)
 	CreateBlobDocument(uid, name, parent string, stream io.Reader) (doc *Document, err error)
 }
 
@@ -54,6 +71,17 @@ type UserStorer interface {
 	RemoveUser(uid string) error
 }
 
+// DeviceStorer keeps the paired devices of the users
+type DeviceStorer interface {
+	GetDevices(uid string) ([]*model.Device, error)
+	GetDevice(uid, deviceID string) (*model.Device, error)
+	// UpdateDevice adds or replaces the device
+	UpdateDevice(uid string, device *model.Device) error
+	// ChangeDevice applies change to the stored device in one step, concurrent changes are not lost.
+	// A missing device is added with only its id if create is set, else it is ErrorDeviceNotFound
+	ChangeDevice(uid, deviceID string, create bool, change func(*model.Device)) (*model.Device, error)
+}
+
 // Document represents a document in storage
 type Document struct {
 	ID      string
@@ -62,3 +90,110 @@ type Document struct {
 	Name    string
 	Version int
 }
+
+// Generation a root in the sync15 history
+type Generation struct {
+	Generation int64
+	Date       time.Time
+	Hash       string
+	Documents  int
+	// Available the root index was not garbage collected
+	Available bool
+	Current   bool
+}
+
+// DocumentChange a document that differs between two generations
+type DocumentChange struct {
+	ID   string
+	Name string
+}
+
+// GenerationDiff the differences between two generations
+type GenerationDiff struct {
+	From     int64
+	To       int64
+	Added    []DocumentChange
+	Removed  []DocumentChange
+	Modified []DocumentChange
+}
+
+// DocumentVersion a distinct version of a sync15 document
+type DocumentVersion struct {
+	// Version the hash of the document index
+	Version string
+	// Generation the first generation with this version
+	Generation   int64
+	Date         time.Time
+	Name         string
+	Size         int64
+	LastModified time.Time
+	Current      bool
+}
+
+// MigrationProblem a document that could not be converted or imported
+type MigrationProblem struct {
+	ID     string
+	Name   string
+	Reason string
+}
+
+// MigrationReport the result of a sync10 to sync15 migration
+type MigrationReport struct {
+	UID    string
+	DryRun bool
+	// Documents and Folders converted (or would be)
+	Documents int
+	Folders   int
+	// Existing already in the sync15 tree, skipped
+	Existing int
+	Problems []MigrationProblem
+}
+
+// LibraryManifestName the manifest file in a library archive
+const LibraryManifestName = "manifest.json"
+
+// LibraryEntry a document in a library archive
+type LibraryEntry struct {
+	ID     string           `json:"id"`
+	Name   string           `json:"name"`
+	Type   common.EntryType `json:"type"`
+	Parent string           `json:"parent,omitempty"`
+	// Path of the rmdoc in the archive
+	Path         string `json:"path"`
+	Version      int    `json:"version"`
+	LastModified string `json:"lastModified"`
+	Size         int64  `json:"size"`
+}
+
+// LibraryManifest lists the documents of a library archive
+type LibraryManifest struct {
+	Version    int            `json:"version"`
+	User       string         `json:"user"`
+	Created    time.Time      `json:"created"`
+	Generation int64          `json:"generation"`
+	Documents  []LibraryEntry `json:"documents"`
+}
+
+// ImportReport the result of a library import
+type ImportReport struct {
+	UID       string
+	Documents int
+	Folders   int
+	// Existing already in the tree, skipped
+	Existing int
+	Problems []MigrationProblem
+}
+
+// SearchHit a page of a document that matches a search
+type SearchHit struct {
+	// Page starting from 1
+	Page    int
+	Snippet string
+}
+
+// SearchResult a document that matches a search
+type SearchResult struct {
+	ID   string
+	Name string
+	Hits []SearchHit
+}
@@ -0,0 +1,46 @@
+package ui
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// listAudit the audit log entries, filtered by ?user=&action=&from=&to=&limit=
+func (app *ReactAppWrapper) listAudit(c *gin.Context) {
+	filter := audit.Filter{
+		User:   c.Query("user"),
+		Action: c.Query("action"),
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			badReq(c, "from must be an RFC3339 time")
+			return
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			badReq(c, "to must be an RFC3339 time")
+			return
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			badReq(c, "limit must be a number")
+			return
+		}
+	}
+
+	entries, err := audit.Query(filter)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,65 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	assert.NoError(t, audit.Open(filepath.Join(t.TempDir(), "audit.log")))
+	defer audit.Close()
+
+	user, err := model.NewUser("alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &ReactAppWrapper{
+		cfg:        &config.Config{JWTSecretKey: []byte("jwt")},
+		userStorer: &memoryUsers{users: map[string]*model.User{user.ID: user}},
+		limiter:    ratelimit.New(ratelimit.Config{}),
+	}
+	router := gin.New()
+	router.POST("/ui/api/login", app.login)
+	router.GET("/ui/api/audit", app.listAudit)
+
+	for _, password := range []string{"wrong", "password"} {
+		body, _ := json.Marshal(viewmodel.LoginForm{Email: "alice", Password: password})
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ui/api/login", bytes.NewReader(body)))
+	}
+
+	query := func(q string) (entries []audit.Entry) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/api/audit?"+q, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+		return
+	}
+
+	entries := query("user=alice")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, audit.ActionLogin, entries[0].Action)
+		assert.Equal(t, audit.SourceWeb, entries[0].Source)
+		assert.Equal(t, audit.ActionLoginFailed, entries[1].Action)
+		assert.Equal(t, "wrong password", entries[1].Details["reason"])
+	}
+	assert.Len(t, query("action=login.failed"), 1)
+	assert.Len(t, query("user=bob"), 0)
+	assert.Len(t, query("from=2100-01-01T00:00:00Z"), 0)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/api/audit?from=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"io"
 	"time"
 
@@ -23,6 +24,40 @@ func (d *backend10) Sync(uid string) {
 	//nop
 }
 
+var errNoVersions = errors.New("document versions need sync15")
+
+func (d *backend10) DocumentVersions(uid, docID string) ([]*storage.DocumentVersion, error) {
+	return nil, errNoVersions
+}
+
+func (d *backend10) ExportVersion(uid, docID, version, exporttype string) (io.ReadCloser, error) {
+	return nil, errNoVersions
+}
+
+func (d *backend10) RestoreVersion(uid, docID, version string) error {
+	return errNoVersions
+}
+
+var errNoSearch = errors.New("search needs sync15")
+
+var errNoTrash = errors.New("the trash needs sync15")
+
+func (d *backend10) ListTrash(uid string) ([]viewmodel.Entry, error) {
+	return nil, errNoTrash
+}
+
+func (d *backend10) RestoreDocument(uid, docID, parent string) (string, error) {
+	return "", errNoTrash
+}
+
+func (d *backend10) EmptyTrash(uid string) (int, error) {
+	return 0, errNoTrash
+}
+
+func (d *backend10) Search(uid, query string) ([]*storage.SearchResult, error) {
+	return nil, errNoSearch
+}
+
 func (d *backend10) CreateFolder(uid, filename, parent string) (doc *storage.Document, err error) {
 	doc, err = d.documentHandler.CreateFolder(uid, filename, parent)
 	if err != nil {
@@ -90,6 +125,10 @@ func (d *backend10) Export(uid, docID, exporttype string, opt storage.ExportOpti
 	return r, nil
 }
 
+func (d *backend10) ExportPages(uid, docID, format string, page int) (io.ReadCloser, error) {
+	return d.documentHandler.ExportDocumentPages(uid, docID, format, page)
+}
+
 func (d *backend10) UpdateDocument(uid, docID, name, parent string) (err error) {
 	metadata, err := d.documentHandler.GetMetadata(uid, docID)
 	if err != nil {
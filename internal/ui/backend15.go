@@ -5,6 +5,7 @@ import (
 
 	"github.com/ddvk/rmfakecloud/internal/app/hub"
 	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
 	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
 	"github.com/google/uuid"
 )
@@ -26,10 +27,17 @@ func (b *backend15) Export(uid, docid, exporttype string, opt storage.ExportOpti
 	if exporttype == "rmdoc" {
 		return b.blobHandler.ExportRmDoc(uid, docid)
 	}
+	if exporter.IsImageFormat(exporttype) {
+		return b.blobHandler.ExportBlobPages(uid, docid, exporttype, 0)
+	}
 	r, err = b.blobHandler.Export(uid, docid)
 	return
 }
 
+func (b *backend15) ExportPages(uid, docid, format string, page int) (io.ReadCloser, error) {
+	return b.blobHandler.ExportBlobPages(uid, docid, format, page)
+}
+
 func (b *backend15) CreateDocument(uid, filename, parent string, stream io.Reader) (doc *storage.Document, err error) {
 	doc, err = b.blobHandler.CreateBlobDocument(uid, filename, parent, stream)
 	return
@@ -42,8 +50,41 @@ func (b *backend15) CreateFolder(uid, name, parent string) (doc *storage.Documen
 	return b.blobHandler.CreateBlobFolder(uid, name, parent)
 }
 
+// DeleteDocument moves to the trash like the tablet, deletes if already trashed
 func (b *backend15) DeleteDocument(uid, docID string) (err error) {
-	return b.blobHandler.DeleteBlobDocument(uid, docID)
+	return b.blobHandler.TrashBlobDocument(uid, docID)
+}
+
+func (b *backend15) ListTrash(uid string) ([]viewmodel.Entry, error) {
+	tree, err := b.GetDocumentTree(uid)
+	if err != nil {
+		return nil, err
+	}
+	return tree.Trash, nil
+}
+
+func (b *backend15) RestoreDocument(uid, docID, parent string) (string, error) {
+	return b.blobHandler.RestoreBlobDocument(uid, docID, parent)
+}
+
+func (b *backend15) EmptyTrash(uid string) (int, error) {
+	return b.blobHandler.EmptyTrash(uid)
+}
+
+func (b *backend15) DocumentVersions(uid, docID string) ([]*storage.DocumentVersion, error) {
+	return b.blobHandler.DocumentVersions(uid, docID)
+}
+
+func (b *backend15) ExportVersion(uid, docID, version, exporttype string) (io.ReadCloser, error) {
+	return b.blobHandler.ExportVersion(uid, docID, version, exporttype)
+}
+
+func (b *backend15) RestoreVersion(uid, docID, version string) error {
+	return b.blobHandler.RestoreDocumentVersion(uid, docID, version)
+}
+
+func (b *backend15) Search(uid, query string) ([]*storage.SearchResult, error) {
+	return b.blobHandler.Search(uid, query)
 }
 
 func (b *backend15) Sync(uid string) {
@@ -0,0 +1,82 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const deviceIDParam = "deviceid"
+
+func deviceViewModel(d *model.Device) viewmodel.Device {
+	return viewmodel.Device{
+		ID:          d.ID,
+		Description: d.Description,
+		Name:        d.Name,
+		PairedAt:    d.PairedAt,
+		LastSeen:    d.LastSeen,
+		LastIP:      d.LastIP,
+		LastSync:    d.LastSync,
+		Revoked:     d.Revoked,
+	}
+}
+
+func (app *ReactAppWrapper) listDevices(c *gin.Context) {
+	uid := userID(c)
+	devices, err := app.devices.GetDevices(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	response := make([]viewmodel.Device, 0, len(devices))
+	for _, d := range devices {
+		response = append(response, deviceViewModel(d))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (app *ReactAppWrapper) renameDevice(c *gin.Context) {
+	var req viewmodel.UpdateDevice
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badReq(c, err.Error())
+		return
+	}
+	device, err := app.devices.ChangeDevice(userID(c), c.Param(deviceIDParam), false, func(device *model.Device) {
+		device.Name = req.Name
+	})
+	if err == storage.ErrorDeviceNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	recordAudit(c, audit.Entry{Action: audit.ActionDeviceRename, Device: device.ID, Details: map[string]string{"name": req.Name}})
+	c.JSON(http.StatusOK, deviceViewModel(device))
+}
+
+func (app *ReactAppWrapper) revokeDevice(c *gin.Context) {
+	device, err := app.devices.ChangeDevice(userID(c), c.Param(deviceIDParam), false, func(device *model.Device) {
+		device.Revoked = true
+	})
+	if err == storage.ErrorDeviceNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info("revoked device: ", device.ID, " of: ", userID(c))
+	recordAudit(c, audit.Entry{Action: audit.ActionDeviceUnpair, Device: device.ID})
+	c.Status(http.StatusNoContent)
+}
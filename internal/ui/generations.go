@@ -0,0 +1,103 @@
+package ui
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const generationParam = "generation"
+
+func toDocumentChanges(changes []storage.DocumentChange) []viewmodel.DocumentChange {
+	result := make([]viewmodel.DocumentChange, 0, len(changes))
+	for _, c := range changes {
+		result = append(result, viewmodel.DocumentChange{ID: c.ID, Name: c.Name})
+	}
+	return result
+}
+
+func (app *ReactAppWrapper) listGenerations(c *gin.Context) {
+	uid := c.Param(useridParam)
+
+	generations, err := app.blobHandler.ListGenerations(uid)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+
+	result := make([]viewmodel.Generation, 0, len(generations))
+	for _, g := range generations {
+		result = append(result, viewmodel.Generation{
+			Generation: g.Generation,
+			Date:       g.Date,
+			Documents:  g.Documents,
+			Available:  g.Available,
+			Current:    g.Current,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (app *ReactAppWrapper) diffGenerations(c *gin.Context) {
+	uid := c.Param(useridParam)
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		badReq(c, "invalid from")
+		return
+	}
+	to, err := strconv.ParseInt(c.Query("to"), 10, 64)
+	if err != nil {
+		badReq(c, "invalid to")
+		return
+	}
+
+	diff, err := app.blobHandler.DiffGenerations(uid, from, to)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, viewmodel.GenerationDiff{
+		From:     diff.From,
+		To:       diff.To,
+		Added:    toDocumentChanges(diff.Added),
+		Removed:  toDocumentChanges(diff.Removed),
+		Modified: toDocumentChanges(diff.Modified),
+	})
+}
+
+func (app *ReactAppWrapper) restoreGeneration(c *gin.Context) {
+	uid := c.Param(useridParam)
+	generation, err := strconv.ParseInt(c.Param(generationParam), 10, 64)
+	if err != nil {
+		badReq(c, "invalid generation")
+		return
+	}
+
+	newGeneration, err := app.blobHandler.RestoreGeneration(uid, generation)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+	log.Infof("%s restored generation %d of %s", userID(c), generation, uid)
+	recordAudit(c, audit.Entry{
+		Action: audit.ActionRootRestore,
+		Details: map[string]string{
+			"user":          uid,
+			"generation":    strconv.FormatInt(generation, 10),
+			"newGeneration": strconv.FormatInt(newGeneration, 10),
+		},
+	})
+
+	app.h.NotifySync(uid, uuid.NewString())
+	c.JSON(http.StatusOK, viewmodel.RestoredGeneration{Generation: newGeneration})
+}
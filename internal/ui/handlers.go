@@ -4,12 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/ddvk/rmfakecloud/internal/audit"
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/integrations"
 	"github.com/ddvk/rmfakecloud/internal/model"
 	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/storage/exporter"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
 	"github.com/gin-gonic/gin"
@@ -37,6 +40,16 @@ func userID(c *gin.Context) string {
 	return c.GetString(userIDContextKey)
 }
 
+// recordAudit fills in the user and the client of an audit entry
+func recordAudit(c *gin.Context, e audit.Entry) {
+	if e.User == "" {
+		e.User = userID(c)
+	}
+	e.Source = audit.SourceWeb
+	e.IP = c.ClientIP()
+	audit.Record(e)
+}
+
 func (app *ReactAppWrapper) register(c *gin.Context) {
 
 	if !app.cfg.RegistrationOpen {
@@ -111,10 +124,21 @@ func (app *ReactAppWrapper) login(c *gin.Context) {
 		app.cfg.CreateFirstUser = false
 	}
 
+	ip := c.ClientIP()
+	account := common.SanitizeUid(form.Email)
+	if wait, ok := app.limiter.Allow(ip, account); !ok {
+		log.Warn(uiLogger, "too many failed logins for: ", account, ", ip: ", ip)
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, viewmodel.NewErrorResponse("too many failed attempts, try again later"))
+		return
+	}
+
 	// Try to find the user
 	user, err := app.userStorer.GetUser(form.Email)
 	if err != nil {
-		log.Error(uiLogger, err, " cannot load user, login failed ip: ", c.ClientIP())
+		log.Error(uiLogger, err, " cannot load user, login failed ip: ", ip)
+		app.limiter.Fail(ip, account)
+		recordAudit(c, audit.Entry{User: account, Action: audit.ActionLoginFailed, Details: map[string]string{"reason": "unknown user"}})
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
@@ -123,12 +147,49 @@ func (app *ReactAppWrapper) login(c *gin.Context) {
 		if err != nil {
 			log.Error(err)
 		} else if !ok {
-			log.Warn(uiLogger, "wrong password for: ", form.Email, ", login failed ip: ", c.ClientIP())
+			log.Warn(uiLogger, "wrong password for: ", form.Email, ", login failed ip: ", ip)
 		}
+		app.limiter.Fail(ip, account)
+		recordAudit(c, audit.Entry{User: user.ID, Action: audit.ActionLoginFailed, Details: map[string]string{"reason": "wrong password"}})
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
+	if user.TOTPEnabled {
+		if form.Code == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, viewmodel.SecondFactorRequired{Error: "code required", TOTPRequired: true})
+			return
+		}
+		if !user.CheckSecondFactor(form.Code, time.Now()) {
+			log.Warn(uiLogger, "wrong two-factor code for: ", form.Email, ", login failed ip: ", ip)
+			app.limiter.Fail(ip, account)
+			recordAudit(c, audit.Entry{User: user.ID, Action: audit.ActionLoginFailed, Details: map[string]string{"reason": "wrong code"}})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, viewmodel.SecondFactorRequired{Error: "wrong code", TOTPRequired: true})
+			return
+		}
+		// the code can't be used again
+		if err = app.userStorer.UpdateUser(user); err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.limiter.Succeed(ip, account)
+
+	tokenString, err := app.newSession(c, user)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	recordAudit(c, audit.Entry{User: user.ID, Action: audit.ActionLogin})
+
+	c.String(http.StatusOK, tokenString)
+}
+
+// newSession signs the web token of the user and sets the cookie
+func (app *ReactAppWrapper) newSession(c *gin.Context, user *model.User) (string, error) {
 	scopes := ""
 	if user.Sync15 {
 		scopes = isSync15Key
@@ -153,17 +214,13 @@ func (app *ReactAppWrapper) login(c *gin.Context) {
 	}
 
 	tokenString, err := common.SignClaims(claims, app.cfg.JWTSecretKey)
-
 	if err != nil {
-		log.Error(err)
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+		return "", err
 	}
 	log.Debug("cookie expires after: ", expiresAfter)
 	c.SetSameSite(http.SameSiteStrictMode)
 	c.SetCookie(cookieName, tokenString, int(expiresAfter.Seconds()), "/", "", app.cfg.HTTPSCookie, true)
-
-	c.String(http.StatusOK, tokenString)
+	return tokenString, nil
 }
 
 func (app *ReactAppWrapper) changePassword(c *gin.Context) {
@@ -265,12 +322,20 @@ func (app *ReactAppWrapper) getDocument(c *gin.Context) {
 	uid := userID(c)
 	docid := common.ParamS(docIDParam, c)
 
-	exportType := c.DefaultQuery("type", "pdf")
+	exportType := c.Query("exporttype")
+	if exportType == "" {
+		exportType = c.DefaultQuery("type", "pdf")
+	}
 	var exportOption storage.ExportOption = 0
 
 	log.Info("exporting ", docid, " as ", exportType)
 	backend := app.getBackend(c)
 
+	if exporter.IsImageFormat(exportType) {
+		app.getDocumentPages(c, backend, uid, docid, exportType)
+		return
+	}
+
 	reader, err := backend.Export(uid, docid, exportType, exportOption)
 	if err != nil {
 		log.Error(err)
@@ -287,6 +352,36 @@ func (app *ReactAppWrapper) getDocument(c *gin.Context) {
 	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", reader, nil)
 }
 
+// getDocumentPages a single page (starting from 1) as an image or all of them in a zip
+func (app *ReactAppWrapper) getDocumentPages(c *gin.Context, backend backend, uid, docid, format string) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "0"))
+	if err != nil || page < 0 {
+		badReq(c, "invalid page")
+		return
+	}
+
+	reader, err := backend.ExportPages(uid, docid, format, page)
+	if err == exporter.ErrorNoSuchPage {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	contentType := "application/zip"
+	filename := docid + ".zip"
+	if page > 0 {
+		contentType = exporter.ImageContentType(format)
+		filename = fmt.Sprintf("%s-%d.%s", docid, page, format)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.DataFromReader(http.StatusOK, -1, contentType, reader, nil)
+}
+
 func (app *ReactAppWrapper) getDocumentMetadata(c *gin.Context) {
 	uid := userID(c)
 	docid := common.ParamS(docIDParam, c)
@@ -316,6 +411,12 @@ func (app *ReactAppWrapper) updateDocument(c *gin.Context) {
 		badReq(c, err.Error())
 		return
 	}
+	e := audit.Entry{Action: audit.ActionDocumentRename, Document: upd.DocumentID, Details: map[string]string{"name": upd.Name}}
+	if upd.ParentID != "" {
+		e.Action = audit.ActionDocumentMove
+		e.Details["parent"] = upd.ParentID
+	}
+	recordAudit(c, e)
 
 	c.Status(http.StatusOK)
 }
@@ -327,7 +428,9 @@ func (app *ReactAppWrapper) deleteDocument(c *gin.Context) {
 	err := backend.DeleteDocument(uid, docid)
 	if err != nil {
 		badReq(c, err.Error())
+		return
 	}
+	recordAudit(c, audit.Entry{Action: audit.ActionDocumentDelete, Document: docid})
 	c.Status(http.StatusOK)
 }
 
@@ -348,6 +451,7 @@ func (app *ReactAppWrapper) createFolder(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	recordAudit(c, audit.Entry{Action: audit.ActionDocumentCreate, Document: doc.ID, Details: map[string]string{"name": upd.Name, "type": "folder"}})
 	c.JSON(http.StatusOK, doc)
 }
 
@@ -385,7 +489,15 @@ func (app *ReactAppWrapper) createDocument(c *gin.Context) {
 		//do the stuff
 		log.Info(uiLogger, fmt.Sprintf("Uploading %s , size: %d", file.Filename, file.Size))
 
-		doc, err := backend.CreateDocument(uid, file.Filename, parentID, f)
+		var doc *storage.Document
+		body, err := app.quota.QuotaReader(uid, f, file.Size)
+		if err == nil {
+			doc, err = backend.CreateDocument(uid, file.Filename, parentID, body)
+		}
+		if errors.Is(err, storage.ErrorQuotaExceeded) {
+			c.AbortWithStatusJSON(http.StatusInsufficientStorage, viewmodel.NewErrorResponse(err.Error()))
+			return
+		}
 		if err != nil {
 			var existsErr *models.ErrDocumentExists
 			if errors.As(err, &existsErr) {
@@ -396,6 +508,7 @@ func (app *ReactAppWrapper) createDocument(c *gin.Context) {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		recordAudit(c, audit.Entry{Action: audit.ActionDocumentCreate, Document: doc.ID, Details: map[string]string{"name": file.Filename}})
 		docs = append(docs, doc)
 	}
 	backend.Sync(uid)
@@ -415,11 +528,17 @@ func (app *ReactAppWrapper) getAppUsers(c *gin.Context) {
 	uilist := make([]viewmodel.User, 0)
 	for _, u := range users {
 		usr := viewmodel.User{
-			ID:        u.ID,
-			Email:     u.Email,
-			Name:      u.Name,
-			CreatedAt: u.CreatedAt,
-			IsAdmin:   u.IsAdmin,
+			ID:          u.ID,
+			Email:       u.Email,
+			Name:        u.Name,
+			CreatedAt:   u.CreatedAt,
+			IsAdmin:     u.IsAdmin,
+			Quota:       &u.Quota,
+			TOTPEnabled: u.TOTPEnabled,
+		}
+		usr.Used, err = app.quota.StorageUsage(u.ID)
+		if err != nil {
+			log.Warn("cannot get the usage of ", u.ID, " ", err)
 		}
 		uilist = append(uilist, usr)
 	}
@@ -449,10 +568,11 @@ func (app *ReactAppWrapper) getUser(c *gin.Context) {
 	}
 
 	vmUser := &viewmodel.User{
-		ID:        user.ID,
-		Email:     user.Email,
-		Name:      user.Name,
-		CreatedAt: user.CreatedAt,
+		ID:          user.ID,
+		Email:       user.Email,
+		Name:        user.Name,
+		CreatedAt:   user.CreatedAt,
+		TOTPEnabled: user.TOTPEnabled,
 	}
 	for _, i := range user.Integrations {
 		vmUser.Integrations = append(vmUser.Integrations, i.Name)
@@ -487,11 +607,23 @@ func (app *ReactAppWrapper) updateUser(c *gin.Context) {
 		user.Email = req.Email
 	}
 
+	if req.Quota != nil {
+		user.Quota = *req.Quota
+	}
+
 	err = app.userStorer.UpdateUser(user)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	details := map[string]string{"user": user.ID}
+	if req.NewPassword != "" {
+		details["password"] = "changed"
+	}
+	if req.Quota != nil {
+		details["quota"] = strconv.FormatInt(*req.Quota, 10)
+	}
+	recordAudit(c, audit.Entry{Action: audit.ActionUserUpdate, Details: details})
 	c.Status(http.StatusAccepted)
 }
 func (app *ReactAppWrapper) deleteUser(c *gin.Context) {
@@ -507,6 +639,7 @@ func (app *ReactAppWrapper) deleteUser(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	recordAudit(c, audit.Entry{Action: audit.ActionUserDelete, Details: map[string]string{"user": uid}})
 	c.Status(http.StatusAccepted)
 }
 
@@ -533,6 +666,7 @@ func (app *ReactAppWrapper) createUser(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	recordAudit(c, audit.Entry{Action: audit.ActionUserCreate, Details: map[string]string{"user": user.ID}})
 	c.Status(http.StatusCreated)
 }
 
@@ -593,10 +727,23 @@ func (app *ReactAppWrapper) createIntegration(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	recordAudit(c, integrationAudit(audit.ActionIntegrationCreate, &int))
 
 	c.JSON(http.StatusOK, int)
 }
 
+// integrationAudit an integration change, without the credentials
+func integrationAudit(action string, int *model.IntegrationConfig) audit.Entry {
+	return audit.Entry{
+		Action: action,
+		Details: map[string]string{
+			"integration": int.ID,
+			"name":        int.Name,
+			"provider":    int.Provider,
+		},
+	}
+}
+
 func (app *ReactAppWrapper) getIntegration(c *gin.Context) {
 	uid := userID(c)
 
@@ -655,6 +802,7 @@ func (app *ReactAppWrapper) updateIntegration(c *gin.Context) {
 				c.AbortWithStatus(http.StatusInternalServerError)
 				return
 			}
+			recordAudit(c, integrationAudit(audit.ActionIntegrationUpdate, &int))
 
 			c.JSON(http.StatusOK, int)
 			return
@@ -687,6 +835,7 @@ func (app *ReactAppWrapper) deleteIntegration(c *gin.Context) {
 				c.AbortWithStatus(http.StatusInternalServerError)
 				return
 			}
+			recordAudit(c, integrationAudit(audit.ActionIntegrationDelete, &integration))
 
 			c.Status(http.StatusAccepted)
 			return
@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const errNoLibrary = "only for sync15, migrate the user first"
+
+// exportLibrary downloads all the documents of the current user
+func (app *ReactAppWrapper) exportLibrary(c *gin.Context) {
+	if v, _ := c.Get(backendVersionKey); v != common.Sync15 {
+		badReq(c, errNoLibrary)
+		return
+	}
+	app.streamLibrary(c, userID(c))
+}
+
+// exportUserLibrary downloads all the documents of a user
+func (app *ReactAppWrapper) exportUserLibrary(c *gin.Context) {
+	user, err := app.userStorer.GetUser(c.Param(useridParam))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !user.Sync15 {
+		badReq(c, errNoLibrary)
+		return
+	}
+	app.streamLibrary(c, user.ID)
+}
+
+func (app *ReactAppWrapper) streamLibrary(c *gin.Context, uid string) {
+	filename := fmt.Sprintf("%s-library-%s.zip", uid, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	// the headers are gone, a failure only truncates the archive
+	if err := app.blobHandler.ExportLibrary(uid, c.Writer); err != nil {
+		log.Error("library export: ", err)
+		return
+	}
+	recordAudit(c, audit.Entry{Action: audit.ActionLibraryExport, Details: map[string]string{"user": uid}})
+}
+
+// importUserLibrary adds the documents of an exported library to a user
+func (app *ReactAppWrapper) importUserLibrary(c *gin.Context) {
+	user, err := app.userStorer.GetUser(c.Param(useridParam))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !user.Sync15 {
+		badReq(c, errNoLibrary)
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		badReq(c, "no file")
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		log.Error(err)
+		badReq(c, "cant open attachment")
+		return
+	}
+	defer f.Close()
+
+	// the documents over the quota are reported as problems
+	report, err := app.blobHandler.ImportLibrary(user.ID, f, file.Size)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+	recordAudit(c, audit.Entry{
+		Action: audit.ActionLibraryImport,
+		Details: map[string]string{
+			"user":      user.ID,
+			"documents": strconv.Itoa(report.Documents),
+			"folders":   strconv.Itoa(report.Folders),
+			"problems":  strconv.Itoa(len(report.Problems)),
+		},
+	})
+	if report.Documents+report.Folders > 0 {
+		app.h.NotifySync(user.ID, webDevice)
+	}
+
+	problems := make([]viewmodel.MigrationProblem, 0, len(report.Problems))
+	for _, p := range report.Problems {
+		problems = append(problems, viewmodel.MigrationProblem{ID: p.ID, Name: p.Name, Reason: p.Reason})
+	}
+	c.JSON(http.StatusOK, viewmodel.ImportReport{
+		Documents: report.Documents,
+		Folders:   report.Folders,
+		Existing:  report.Existing,
+		Problems:  problems,
+	})
+}
@@ -0,0 +1,41 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (app *ReactAppWrapper) listLockouts(c *gin.Context) {
+	blocked := app.limiter.Blocked()
+	response := make([]viewmodel.Lockout, 0, len(blocked))
+	for _, b := range blocked {
+		response = append(response, viewmodel.Lockout{
+			Kind:     b.Kind,
+			Key:      b.Key,
+			IP:       b.IP,
+			Failures: b.Failures,
+			Until:    b.Until,
+			Locked:   b.Locked,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (app *ReactAppWrapper) unlock(c *gin.Context) {
+	kind := c.Param("kind")
+	if kind != ratelimit.KindAccount && kind != ratelimit.KindIP {
+		badReq(c, "kind must be account or ip")
+		return
+	}
+	key := c.Param("key")
+	if !app.limiter.Unlock(kind, key) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	log.Info("unlocked ", kind, ": ", key, " by: ", userID(c))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user, err := model.NewUser("alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &ReactAppWrapper{
+		cfg:        &config.Config{JWTSecretKey: []byte("jwt")},
+		userStorer: &memoryUsers{users: map[string]*model.User{user.ID: user}},
+		limiter:    ratelimit.New(ratelimit.Config{FreeAttempts: 5, LockoutAfter: 3, LockoutDuration: time.Hour}),
+	}
+	router := gin.New()
+	router.SetTrustedProxies([]string{"10.0.0.0/8"})
+	router.POST("/ui/api/login", app.login)
+	router.GET("/ui/api/lockouts", app.listLockouts)
+	router.DELETE("/ui/api/lockouts/:kind/:key", app.unlock)
+
+	loginFrom := func(client, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(viewmodel.LoginForm{Email: "alice", Password: password})
+		req := httptest.NewRequest(http.MethodPost, "/ui/api/login", bytes.NewReader(body))
+		// the proxy appends the address of the client to a spoofed header
+		req.RemoteAddr = "10.0.0.2:4321"
+		req.Header.Set("X-Forwarded-For", "127.0.0.1, "+client)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		return loginFrom("203.0.113.7", password)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+	}
+	w := login("password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "locked even with the right password")
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, loginFrom("198.51.100.1", "password").Code, "the owner isn't locked out")
+
+	var lockouts []viewmodel.Lockout
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/api/lockouts", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lockouts))
+	if assert.Len(t, lockouts, 1) {
+		assert.Equal(t, "alice", lockouts[0].Key)
+		assert.Equal(t, "203.0.113.7", lockouts[0].IP)
+		assert.True(t, lockouts[0].Locked)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/ui/api/lockouts/account/alice", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusOK, login("password").Code)
+
+	// the failures are counted for the real client, not the spoofed address
+	for i := 0; i < 6; i++ {
+		app.limiter.Fail("203.0.113.7", "")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, login("password").Code)
+}
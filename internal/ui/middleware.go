@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/model"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -41,6 +42,10 @@ func (app *ReactAppWrapper) authMiddleware() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
 			return
 		}
+		if model.IsAccessToken(token) {
+			app.accessTokenAuth(c, token)
+			return
+		}
 		claims := &WebUserClaims{}
 		err = common.ClaimsFromToken(claims, token, app.cfg.JWTSecretKey)
 		if err != nil {
@@ -0,0 +1,72 @@
+package ui
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrateUser converts the sync10 documents of a user to sync15
+func (app *ReactAppWrapper) migrateUser(c *gin.Context) {
+	var req viewmodel.MigrateUser
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		badReq(c, err.Error())
+		return
+	}
+	uid := c.Param(useridParam)
+	user, err := app.userStorer.GetUser(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	report, err := app.blobHandler.MigrateToSync15(user.ID, req.DryRun)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, viewmodel.NewErrorResponse(err.Error()))
+		return
+	}
+
+	enabled := false
+	if req.Enable && !req.DryRun && !user.Sync15 {
+		user.Sync15 = true
+		if err = app.userStorer.UpdateUser(user); err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		enabled = true
+	}
+	if !req.DryRun {
+		recordAudit(c, audit.Entry{
+			Action: audit.ActionUserMigrate,
+			Details: map[string]string{
+				"user":      user.ID,
+				"documents": strconv.Itoa(report.Documents),
+				"folders":   strconv.Itoa(report.Folders),
+				"problems":  strconv.Itoa(len(report.Problems)),
+			},
+		})
+		app.h.NotifySync(user.ID, webDevice)
+	}
+
+	problems := make([]viewmodel.MigrationProblem, 0, len(report.Problems))
+	for _, p := range report.Problems {
+		problems = append(problems, viewmodel.MigrationProblem{ID: p.ID, Name: p.Name, Reason: p.Reason})
+	}
+	c.JSON(http.StatusOK, viewmodel.MigrationReport{
+		DryRun:    report.DryRun,
+		Documents: report.Documents,
+		Folders:   report.Folders,
+		Existing:  report.Existing,
+		Problems:  problems,
+		Enabled:   enabled,
+	})
+}
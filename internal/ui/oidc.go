@@ -0,0 +1,289 @@
+package ui
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	oidcCookieName = ".Authrmfakecloud-oidc"
+	oidcCookiePath = "/ui/api/oidc"
+	oidcUsage      = "oidc"
+	oidcLogger     = "[oidc] "
+	// oidcLoginTimeout how long the user has to log in at the identity provider
+	oidcLoginTimeout = 10 * time.Minute
+
+	// the login of a user with two-factor authentication waits for the code
+	oidcSecondFactorCookieName = ".Authrmfakecloud-oidc-totp"
+	oidcSecondFactorUsage      = "oidc-totp"
+	oidcSecondFactorTimeout    = 5 * time.Minute
+)
+
+var errOIDCUnknownUser = errors.New("unknown user")
+
+// oidcFlowClaims the state of a login in progress, kept in a cookie
+type oidcFlowClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// oidcSecondFactorClaims an identity provider login waiting for the code of the authenticator, kept in a cookie
+type oidcSecondFactorClaims struct {
+	UserID string `json:"userid"`
+	jwt.RegisteredClaims
+}
+
+func (app *ReactAppWrapper) oidcEnabled(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": app.oidc != nil})
+}
+
+// oidcLogin redirects to the identity provider
+func (app *ReactAppWrapper) oidcLogin(c *gin.Context) {
+	flow := &oidcFlowClaims{
+		State:    uuid.NewString(),
+		Nonce:    uuid.NewString(),
+		Verifier: oidc.GenerateVerifier(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcLoginTimeout)),
+			Audience:  []string{oidcUsage},
+		},
+	}
+	authURL, err := app.oidc.AuthCodeURL(c.Request.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	token, err := common.SignClaims(flow, app.cfg.JWTSecretKey)
+	if err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	// lax, the callback is a redirect from the identity provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookieName, token, int(oidcLoginTimeout.Seconds()), oidcCookiePath, "", app.cfg.HTTPSCookie, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcCallback finishes the login and starts a session
+func (app *ReactAppWrapper) oidcCallback(c *gin.Context) {
+	if e := c.Query("error"); e != "" {
+		log.Warn(oidcLogger, "login failed: ", e, " ", c.Query("error_description"))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": e})
+		return
+	}
+
+	cookie, err := c.Cookie(oidcCookieName)
+	if err != nil {
+		badReq(c, "no login in progress")
+		return
+	}
+	c.SetCookie(oidcCookieName, "", -1, oidcCookiePath, "", app.cfg.HTTPSCookie, true)
+	flow := &oidcFlowClaims{}
+	err = common.ClaimsFromToken(flow, cookie, app.cfg.JWTSecretKey)
+	if err != nil || !slices.Contains(flow.Audience, oidcUsage) {
+		log.Warn(oidcLogger, "login state: ", err)
+		badReq(c, "login expired")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(flow.State), []byte(c.Query("state"))) != 1 {
+		log.Warn(oidcLogger, "wrong state, ip: ", c.ClientIP())
+		badReq(c, "wrong state")
+		return
+	}
+
+	identity, err := app.oidc.Exchange(c.Request.Context(), c.Query("code"), flow.Nonce, flow.Verifier)
+	if err != nil {
+		log.Warn(oidcLogger, err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+	user, err := app.oidcUser(identity)
+	if err == errOIDCUnknownUser {
+		log.Warn(oidcLogger, "unknown user: ", identity.UserID, ", login failed ip: ", c.ClientIP())
+		recordAudit(c, audit.Entry{User: identity.UserID, Action: audit.ActionLoginFailed, Details: map[string]string{"reason": "unknown user", "method": "oidc"}})
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unknown user"})
+		return
+	}
+	if err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if user.TOTPEnabled {
+		app.oidcWaitSecondFactor(c, user)
+		return
+	}
+
+	if _, err = app.newSession(c, user); err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info(oidcLogger, "logged in: ", user.ID)
+	recordAudit(c, audit.Entry{User: user.ID, Action: audit.ActionLogin, Details: map[string]string{"method": "oidc"}})
+	// only the cookie has the token, the ui gets the user from the session
+	c.Redirect(http.StatusFound, "/login#oidc")
+}
+
+// session the user of the session cookie, the ui asks for it after the identity provider login
+func (app *ReactAppWrapper) session(c *gin.Context) {
+	claims := &WebUserClaims{}
+	token, err := c.Cookie(cookieName)
+	if err == nil {
+		err = common.ClaimsFromToken(claims, token, app.cfg.JWTSecretKey)
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "no session"})
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+// oidcWaitSecondFactor sends the user back to the login page to enter the code,
+// the identity provider doesn't replace the second factor
+func (app *ReactAppWrapper) oidcWaitSecondFactor(c *gin.Context, user *model.User) {
+	pending := &oidcSecondFactorClaims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcSecondFactorTimeout)),
+			Audience:  []string{oidcSecondFactorUsage},
+		},
+	}
+	token, err := common.SignClaims(pending, app.cfg.JWTSecretKey)
+	if err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcSecondFactorCookieName, token, int(oidcSecondFactorTimeout.Seconds()), oidcCookiePath, "", app.cfg.HTTPSCookie, true)
+	log.Info(oidcLogger, "waiting for the code of: ", user.ID)
+	c.Redirect(http.StatusFound, "/login#oidc-totp")
+}
+
+// oidcSecondFactor finishes the identity provider login of a user with two-factor authentication
+func (app *ReactAppWrapper) oidcSecondFactor(c *gin.Context) {
+	var form viewmodel.SecondFactorForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		badReq(c, err.Error())
+		return
+	}
+	cookie, err := c.Cookie(oidcSecondFactorCookieName)
+	if err != nil {
+		badReq(c, "no login in progress")
+		return
+	}
+	pending := &oidcSecondFactorClaims{}
+	err = common.ClaimsFromToken(pending, cookie, app.cfg.JWTSecretKey)
+	if err != nil || !slices.Contains(pending.Audience, oidcSecondFactorUsage) {
+		log.Warn(oidcLogger, "second factor state: ", err)
+		badReq(c, "login expired")
+		return
+	}
+
+	ip := c.ClientIP()
+	account := common.SanitizeUid(pending.UserID)
+	if wait, ok := app.limiter.Allow(ip, account); !ok {
+		log.Warn(oidcLogger, "too many failed logins for: ", account, ", ip: ", ip)
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, viewmodel.NewErrorResponse("too many failed attempts, try again later"))
+		return
+	}
+	user, err := app.userStorer.GetUser(pending.UserID)
+	if err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if user.TOTPEnabled {
+		if !user.CheckSecondFactor(form.Code, time.Now()) {
+			log.Warn(oidcLogger, "wrong two-factor code for: ", user.ID, ", login failed ip: ", ip)
+			app.limiter.Fail(ip, account)
+			recordAudit(c, audit.Entry{User: user.ID, Action: audit.ActionLoginFailed, Details: map[string]string{"reason": "wrong code", "method": "oidc"}})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, viewmodel.SecondFactorRequired{Error: "wrong code", TOTPRequired: true})
+			return
+		}
+		// the code can't be used again
+		if err = app.userStorer.UpdateUser(user); err != nil {
+			log.Error(oidcLogger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	app.limiter.Succeed(ip, account)
+	c.SetCookie(oidcSecondFactorCookieName, "", -1, oidcCookiePath, "", app.cfg.HTTPSCookie, true)
+
+	token, err := app.newSession(c, user)
+	if err != nil {
+		log.Error(oidcLogger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info(oidcLogger, "logged in: ", user.ID)
+	recordAudit(c, audit.Entry{User: user.ID, Action: audit.ActionLogin, Details: map[string]string{"method": "oidc"}})
+	c.String(http.StatusOK, token)
+}
+
+// oidcUser the user matching the identity, created if configured.
+// The admin flag follows the admin group when it is set.
+func (app *ReactAppWrapper) oidcUser(identity *oidc.Identity) (*model.User, error) {
+	cfg := app.cfg.OIDC
+	isAdmin := cfg.AdminGroup != "" && identity.InGroup(cfg.AdminGroup)
+
+	user, err := app.userStorer.GetUser(identity.UserID)
+	if err != nil && !errors.Is(err, storage.ErrorUserNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		if !cfg.AutoCreate {
+			return nil, errOIDCUnknownUser
+		}
+		password, err := model.GenPassword()
+		if err != nil {
+			return nil, err
+		}
+		user, err = model.NewUser(identity.UserID, password)
+		if err != nil {
+			return nil, err
+		}
+		if user.ID != identity.UserID {
+			return nil, errors.New("the user claim has unsupported characters: " + identity.UserID)
+		}
+		if identity.Email != "" {
+			user.Email = identity.Email
+		}
+		user.Name = identity.Name
+		user.IsAdmin = isAdmin
+		log.Info(oidcLogger, "creating user: ", user.ID)
+		return user, app.userStorer.RegisterUser(user)
+	}
+
+	if cfg.AdminGroup != "" && user.IsAdmin != isAdmin {
+		log.Info(oidcLogger, "admin: ", isAdmin, " for: ", user.ID)
+		user.IsAdmin = isAdmin
+		if err = app.userStorer.UpdateUser(user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
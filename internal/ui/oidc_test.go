@@ -0,0 +1,179 @@
+package ui
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/ddvk/rmfakecloud/internal/oidc/oidctest"
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryUsers struct {
+	storage.UserStorer
+	users map[string]*model.User
+	err   error
+}
+
+func (m *memoryUsers) GetUser(uid string) (*model.User, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if u, ok := m.users[uid]; ok {
+		return u, nil
+	}
+	return nil, storage.ErrorUserNotFound
+}
+
+func (m *memoryUsers) RegisterUser(u *model.User) error {
+	m.users[u.ID] = u
+	return nil
+}
+
+func (m *memoryUsers) UpdateUser(u *model.User) error {
+	m.users[u.ID] = u
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	provider := oidctest.NewServer("rmfakecloud", "secret")
+	defer provider.Close()
+
+	users := &memoryUsers{users: make(map[string]*model.User)}
+	app := &ReactAppWrapper{
+		cfg: &config.Config{
+			JWTSecretKey: []byte("jwt"),
+			OIDC: &oidc.Config{
+				Issuer:       provider.Issuer(),
+				ClientID:     "rmfakecloud",
+				ClientSecret: "secret",
+				RedirectURL:  "http://rmfakecloud.test/ui/api/oidc/callback",
+				Scopes:       []string{"openid", "email"},
+				UserClaim:    "email",
+				GroupsClaim:  "groups",
+				AdminGroup:   "admins",
+			},
+		},
+		userStorer: users,
+		limiter:    ratelimit.New(ratelimit.Config{}),
+	}
+	app.oidc = oidc.New(app.cfg.OIDC)
+	router := gin.New()
+	router.GET("/ui/api/oidc/login", app.oidcLogin)
+	router.GET("/ui/api/oidc/callback", app.oidcCallback)
+	router.POST("/ui/api/oidc/totp", app.oidcSecondFactor)
+	router.GET("/ui/api/session", app.session)
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	// login, the provider approves and redirects back to the callback
+	login := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/api/oidc/login", nil))
+		if !assert.Equal(t, http.StatusFound, w.Code) {
+			t.FailNow()
+		}
+		resp, err := noRedirect.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+		for _, c := range w.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	provider.Claims["email"] = "alice@example.com"
+	provider.Claims["groups"] = []string{"users", "admins"}
+	w := login()
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the email is not verified")
+
+	provider.Claims["email_verified"] = true
+	w = login()
+	assert.Equal(t, http.StatusForbidden, w.Code, "unknown users are not created")
+
+	app.cfg.OIDC.AutoCreate = true
+	users.err = errors.New("storage down")
+	w = login()
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, users.users, "created only when not found")
+
+	users.err = nil
+	w = login()
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login#oidc", w.Header().Get("Location"), "no token in the url")
+	user := users.users["alice@example.com"]
+	if assert.NotNil(t, user) {
+		assert.True(t, user.IsAdmin)
+	}
+	// the ui gets the user with the session cookie
+	req := httptest.NewRequest(http.MethodGet, "/ui/api/session", nil)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == cookieName {
+			req.AddCookie(c)
+		}
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"UserID":"alice@example.com"`)
+
+	// the admin flag follows the group
+	provider.Claims["groups"] = "users"
+	w = login()
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.False(t, users.users["alice@example.com"].IsAdmin)
+
+	// the code is still asked with two-factor authentication
+	codes, err := users.users["alice@example.com"].NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.users["alice@example.com"].TOTPEnabled = true
+	w = login()
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login#oidc-totp", w.Header().Get("Location"))
+	pending := w.Result().Cookies()
+	for _, c := range pending {
+		assert.NotEqual(t, cookieName, c.Name, "no session before the second factor")
+	}
+	secondFactor := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ui/api/oidc/totp", strings.NewReader(`{"code": "`+code+`"}`))
+		for _, c := range pending {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusUnauthorized, secondFactor("123456").Code)
+	w = secondFactor(codes[0])
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, secondFactor(codes[0]).Code, "a recovery code is used once")
+
+	// a callback without the login cookie
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/api/oidc/callback?code=x&state=y", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
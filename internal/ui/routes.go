@@ -37,6 +37,12 @@ func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
 	r := router.Group("/ui/api")
 	r.POST("register", app.register)
 	r.POST("login", app.login)
+	r.GET("oidc", app.oidcEnabled)
+	if app.oidc != nil {
+		r.GET("oidc/login", app.oidcLogin)
+		r.GET("oidc/callback", app.oidcCallback)
+		r.POST("oidc/totp", app.oidcSecondFactor)
+	}
 	r.GET("logout", func(c *gin.Context) {
 		c.SetCookie(cookieName, "/", -1, "", "", false, true)
 		c.Status(http.StatusOK)
@@ -55,6 +61,23 @@ func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
 	})
 
 	auth.GET("newcode", app.newCode)
+	auth.GET("session", app.session)
+
+	// personal access tokens
+	auth.GET("tokens", app.listAccessTokens)
+	auth.POST("tokens", app.createAccessToken)
+	auth.DELETE("tokens/:tokenid", app.deleteAccessToken)
+
+	// two-factor authentication
+	auth.GET("totp", app.getTOTP)
+	auth.POST("totp", app.enrollTOTP)
+	auth.POST("totp/confirm", app.confirmTOTP)
+	auth.POST("totp/disable", app.disableTOTP)
+
+	// paired devices
+	auth.GET("devices", app.listDevices)
+	auth.PUT("devices/:deviceid", app.renameDevice)
+	auth.DELETE("devices/:deviceid", app.revokeDevice)
 
 	// passcode (PIN) reset approval
 	auth.GET("passcode/resets", app.listPasscodeResets)
@@ -74,6 +97,18 @@ func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
 	auth.PUT("documents", app.updateDocument)
 	auth.POST("folders", app.createFolder)
 	auth.GET("documents/:docid/metadata", app.getDocumentMetadata)
+	auth.GET("documents/:docid/versions", app.listDocumentVersions)
+	auth.GET("documents/:docid/versions/:version", app.getDocumentVersion)
+	auth.POST("documents/:docid/versions/:version/restore", app.restoreDocumentVersion)
+	auth.GET("search", app.search)
+
+	// download all my data
+	auth.GET("library", app.exportLibrary)
+
+	// sync15 trash
+	auth.GET("trash", app.listTrash)
+	auth.POST("trash/:docid/restore", app.restoreTrash)
+	auth.DELETE("trash", app.emptyTrash)
 
 	// integrations
 	auth.GET("integrations", app.listIntegrations)
@@ -94,4 +129,24 @@ func (app *ReactAppWrapper) RegisterRoutes(router *gin.Engine) {
 	admin.PUT("users", app.updateUser)
 	admin.POST("users", app.createUser)
 	admin.GET("users", app.getAppUsers)
+	admin.DELETE("users/:userid/totp", app.resetUserTOTP)
+
+	// failed login lockouts
+	admin.GET("lockouts", app.listLockouts)
+	admin.DELETE("lockouts/:kind/:key", app.unlock)
+
+	// who did what
+	admin.GET("audit", app.listAudit)
+
+	// sync15 history
+	admin.GET("users/:userid/generations", app.listGenerations)
+	admin.GET("users/:userid/generations/diff", app.diffGenerations)
+	admin.POST("users/:userid/generations/:generation/restore", app.restoreGeneration)
+
+	// sync10 to sync15
+	admin.POST("users/:userid/migrate", app.migrateUser)
+
+	// move a library between instances
+	admin.GET("users/:userid/library", app.exportUserLibrary)
+	admin.POST("users/:userid/library", app.importUserLibrary)
 }
@@ -0,0 +1,42 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (app *ReactAppWrapper) search(c *gin.Context) {
+	uid := userID(c)
+	query := c.Query("q")
+	if query == "" {
+		badReq(c, "missing q")
+		return
+	}
+
+	results, err := app.getBackend(c).Search(uid, query)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+
+	response := make([]viewmodel.SearchResult, 0, len(results))
+	for _, r := range results {
+		result := viewmodel.SearchResult{
+			ID:   r.ID,
+			Name: r.Name,
+			Hits: make([]viewmodel.SearchHit, 0, len(r.Hits)),
+		}
+		for _, h := range r.Hits {
+			result.Hits = append(result.Hits, viewmodel.SearchHit{
+				Page:    h.Page,
+				Snippet: h.Snippet,
+			})
+		}
+		response = append(response, result)
+	}
+	c.JSON(http.StatusOK, response)
+}
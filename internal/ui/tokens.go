@@ -0,0 +1,184 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tokenIDParam         = "tokenid"
+	tokenScopeContextKey = "tokenScope"
+	uploadRoute          = "/ui/api/documents/upload"
+	// a pairing code gives the full access
+	newCodeRoute = "/ui/api/newcode"
+)
+
+// tokenAllows checks if the scope of an access token allows the request
+func tokenAllows(scope string, c *gin.Context) bool {
+	switch scope {
+	case model.TokenScopeAdmin:
+		return true
+	case model.TokenScopeRead:
+		return (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) &&
+			c.FullPath() != newCodeRoute
+	case model.TokenScopeUpload:
+		return c.Request.Method == http.MethodPost && c.FullPath() == uploadRoute
+	}
+	return false
+}
+
+// accessTokenAuth authenticates the request with a personal access token
+func (app *ReactAppWrapper) accessTokenAuth(c *gin.Context, token string) {
+	uid, err := model.AccessTokenUser(token)
+	if err != nil {
+		log.Warn("[ui-authmiddleware] ", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
+		return
+	}
+	user, err := app.userStorer.GetUser(uid)
+	if err != nil {
+		log.Warn("[ui-authmiddleware] access token user, ", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
+		return
+	}
+	accessToken := user.CheckAccessToken(token)
+	if accessToken == nil {
+		log.Warn("[ui-authmiddleware] wrong access token for: ", uid, " ip: ", c.ClientIP())
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
+		return
+	}
+	if !tokenAllows(accessToken.Scope, c) {
+		log.Warn("[ui-authmiddleware] access token scope: ", accessToken.Scope, " doesn't allow: ", c.Request.Method, " ", c.FullPath())
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed by the token scope"})
+		return
+	}
+	if accessToken.Device != "" {
+		device, err := app.devices.GetDevice(user.ID, accessToken.Device)
+		if err != nil || device.Revoked {
+			log.Warn("[ui-authmiddleware] access token: ", accessToken.Name, " of a revoked or removed device: ", accessToken.Device, " err: ", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or incorrect token"})
+			return
+		}
+	}
+
+	c.Set(backendVersionKey, common.Sync10)
+	if user.Sync15 {
+		c.Set(backendVersionKey, common.Sync15)
+	}
+	c.Set(userIDContextKey, common.SanitizeUid(user.ID))
+	c.Set(tokenScopeContextKey, accessToken.Scope)
+	if accessToken.Scope == model.TokenScopeAdmin && user.IsAdmin {
+		c.Set(AdminRole, true)
+	}
+	log.Info("[ui-authmiddleware] User from access token: ", user.ID, " token: ", accessToken.Name)
+	c.Next()
+}
+
+func accessTokenViewModel(t *model.AccessToken) viewmodel.AccessToken {
+	return viewmodel.AccessToken{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scope:     t.Scope,
+		CreatedAt: t.CreatedAt,
+		Device:    t.Device,
+	}
+}
+
+func (app *ReactAppWrapper) listAccessTokens(c *gin.Context) {
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	response := make([]viewmodel.AccessToken, 0, len(user.AccessTokens))
+	for i := range user.AccessTokens {
+		response = append(response, accessTokenViewModel(&user.AccessTokens[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (app *ReactAppWrapper) createAccessToken(c *gin.Context) {
+	var req viewmodel.NewAccessToken
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badReq(c, err.Error())
+		return
+	}
+	if !model.IsTokenScope(req.Scope) {
+		badReq(c, "scope must be read, upload or admin")
+		return
+	}
+
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if req.Scope == model.TokenScopeAdmin && !user.IsAdmin {
+		c.AbortWithStatusJSON(http.StatusForbidden, viewmodel.NewErrorResponse("only admins can create admin tokens"))
+		return
+	}
+
+	if req.Device != "" {
+		device, err := app.devices.GetDevice(user.ID, req.Device)
+		if err == storage.ErrorDeviceNotFound {
+			badReq(c, "unknown device")
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if device.Revoked {
+			badReq(c, "the device is revoked")
+			return
+		}
+	}
+
+	accessToken, token, err := user.NewAccessToken(req.Name, req.Scope, req.Device)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info("created access token: ", accessToken.Name, " scope: ", accessToken.Scope, " for: ", user.ID)
+	recordAudit(c, audit.Entry{Action: audit.ActionTokenCreate, Details: map[string]string{"name": accessToken.Name, "scope": accessToken.Scope}, Device: accessToken.Device})
+	c.JSON(http.StatusCreated, viewmodel.CreatedAccessToken{
+		AccessToken: accessTokenViewModel(accessToken),
+		Token:       token,
+	})
+}
+
+func (app *ReactAppWrapper) deleteAccessToken(c *gin.Context) {
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !user.RemoveAccessToken(c.Param(tokenIDParam)) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	recordAudit(c, audit.Entry{Action: audit.ActionTokenDelete, Details: map[string]string{"token": c.Param(tokenIDParam)}})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testUsers struct {
+	storage.UserStorer
+	user *model.User
+}
+
+func (u *testUsers) GetUser(uid string) (*model.User, error) {
+	if uid != u.user.ID {
+		return nil, errors.New("user not found")
+	}
+	return u.user, nil
+}
+
+type testDevices struct {
+	storage.DeviceStorer
+	devices map[string]*model.Device
+}
+
+func (d *testDevices) GetDevice(uid, deviceID string) (*model.Device, error) {
+	device, ok := d.devices[deviceID]
+	if !ok {
+		return nil, storage.ErrorDeviceNotFound
+	}
+	return device, nil
+}
+
+func TestAccessTokenAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user := &model.User{ID: "test"}
+	_, readToken, err := user.NewAccessToken("backup", model.TokenScopeRead, "")
+	assert.NoError(t, err)
+	_, uploadToken, err := user.NewAccessToken("scanner", model.TokenScopeUpload, "")
+	assert.NoError(t, err)
+	_, deviceToken, err := user.NewAccessToken("tablet script", model.TokenScopeRead, "tablet")
+	assert.NoError(t, err)
+	tablet := &model.Device{ID: "tablet"}
+
+	app := &ReactAppWrapper{
+		cfg:        &config.Config{JWTSecretKey: []byte("secret")},
+		userStorer: &testUsers{user: user},
+		devices:    &testDevices{devices: map[string]*model.Device{"tablet": tablet}},
+	}
+	router := gin.New()
+	auth := router.Group("/ui/api")
+	auth.Use(app.authMiddleware())
+	ok := func(c *gin.Context) { c.String(http.StatusOK, userID(c)) }
+	auth.GET("documents", ok)
+	auth.GET("newcode", ok)
+	auth.POST("documents/upload", ok)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/ui/api/documents", readToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test", w.Body.String())
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/ui/api/documents/upload", readToken).Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/ui/api/newcode", readToken).Code)
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/ui/api/documents/upload", uploadToken).Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/ui/api/documents", uploadToken).Code)
+
+	// the device token stops working with the device
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/ui/api/documents", deviceToken).Code)
+	tablet.Revoked = true
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/ui/api/documents", deviceToken).Code)
+
+	// a revoked or forged token
+	user.RemoveAccessToken(user.AccessTokens[0].ID)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/ui/api/documents", readToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/ui/api/documents", "rmfc.dGVzdA.forged").Code)
+}
@@ -0,0 +1,163 @@
+package ui
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// totpIssuer the account name shown in the authenticator app
+const totpIssuer = "rmfakecloud"
+
+func (app *ReactAppWrapper) getTOTP(c *gin.Context) {
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, viewmodel.TOTPStatus{
+		Enabled:       user.TOTPEnabled,
+		RecoveryCodes: len(user.RecoveryCodes),
+	})
+}
+
+// enrollTOTP generates a new secret, the second factor is enabled by confirmTOTP
+func (app *ReactAppWrapper) enrollTOTP(c *gin.Context) {
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	secret, err := user.NewTOTPSecret()
+	if errors.Is(err, model.ErrorTOTPEnabled) {
+		c.AbortWithStatusJSON(http.StatusConflict, viewmodel.NewErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, viewmodel.TOTPEnrolment{
+		Secret: secret,
+		URI:    model.TOTPURI(totpIssuer, user.ID, secret),
+	})
+}
+
+// allowCodeCheck the codes entered on the profile page are limited like the login, aborts when blocked
+func (app *ReactAppWrapper) allowCodeCheck(c *gin.Context) bool {
+	ip := c.ClientIP()
+	if wait, ok := app.limiter.Allow(ip, userID(c)); !ok {
+		log.Warn("too many wrong two-factor codes for: ", userID(c), ", ip: ", ip)
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, viewmodel.NewErrorResponse("too many failed attempts, try again later"))
+		return false
+	}
+	return true
+}
+
+func (app *ReactAppWrapper) confirmTOTP(c *gin.Context) {
+	var req viewmodel.TOTPCode
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badReq(c, err.Error())
+		return
+	}
+	if !app.allowCodeCheck(c) {
+		return
+	}
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	codes, err := user.EnableTOTP(req.Code, time.Now())
+	switch {
+	case errors.Is(err, model.ErrorTOTPEnabled):
+		c.AbortWithStatusJSON(http.StatusConflict, viewmodel.NewErrorResponse(err.Error()))
+		return
+	case errors.Is(err, model.ErrorWrongCode):
+		app.limiter.Fail(c.ClientIP(), userID(c))
+		badReq(c, err.Error())
+		return
+	case err != nil:
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	app.limiter.Succeed(c.ClientIP(), userID(c))
+	log.Info("two-factor authentication enabled for: ", user.ID)
+	c.JSON(http.StatusOK, viewmodel.RecoveryCodes{Codes: codes})
+}
+
+// disableTOTP turns off the second factor, needs a current code
+func (app *ReactAppWrapper) disableTOTP(c *gin.Context) {
+	var req viewmodel.TOTPCode
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badReq(c, err.Error())
+		return
+	}
+	if !app.allowCodeCheck(c) {
+		return
+	}
+	user, err := app.userStorer.GetUser(userID(c))
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !user.CheckSecondFactor(req.Code, time.Now()) {
+		log.Warn("wrong two-factor code for: ", user.ID, " ip: ", c.ClientIP())
+		app.limiter.Fail(c.ClientIP(), userID(c))
+		badReq(c, model.ErrorWrongCode.Error())
+		return
+	}
+	user.ResetTOTP()
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	app.limiter.Succeed(c.ClientIP(), userID(c))
+	log.Info("two-factor authentication disabled for: ", user.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// resetUserTOTP lets an admin remove the second factor of a user who lost it
+func (app *ReactAppWrapper) resetUserTOTP(c *gin.Context) {
+	uid := c.Param(useridParam)
+	user, err := app.userStorer.GetUser(uid)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	user.ResetTOTP()
+	if err = app.userStorer.UpdateUser(user); err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	log.Info("two-factor authentication of: ", uid, " reset by: ", userID(c))
+	recordAudit(c, audit.Entry{Action: audit.ActionUserResetTOTP, Details: map[string]string{"user": uid}})
+	c.Status(http.StatusNoContent)
+}
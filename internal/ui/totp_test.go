@@ -0,0 +1,98 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ddvk/rmfakecloud/internal/config"
+	"github.com/ddvk/rmfakecloud/internal/model"
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginSecondFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user, err := model.NewUser("alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := user.NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPEnabled = true
+	users := &memoryUsers{users: map[string]*model.User{user.ID: user}}
+	app := &ReactAppWrapper{
+		cfg:        &config.Config{JWTSecretKey: []byte("jwt")},
+		userStorer: users,
+		limiter:    ratelimit.New(ratelimit.Config{}),
+	}
+	router := gin.New()
+	router.POST("/ui/api/login", app.login)
+
+	login := func(form viewmodel.LoginForm) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(form)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ui/api/login", bytes.NewReader(body)))
+		return w
+	}
+
+	w := login(viewmodel.LoginForm{Email: "alice", Password: "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotContains(t, w.Body.String(), "totpRequired", "the password is checked first")
+
+	var resp viewmodel.SecondFactorRequired
+	w = login(viewmodel.LoginForm{Email: "alice", Password: "password"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.TOTPRequired)
+	assert.Empty(t, w.Result().Cookies(), "no session before the second factor")
+
+	w = login(viewmodel.LoginForm{Email: "alice", Password: "password", Code: "123456"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = login(viewmodel.LoginForm{Email: "alice", Password: "password", Code: codes[0]})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Result().Cookies())
+	assert.Len(t, users.users["alice"].RecoveryCodes, len(codes)-1)
+
+	w = login(viewmodel.LoginForm{Email: "alice", Password: "password", Code: codes[0]})
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "recovery codes are used once")
+}
+
+func TestDisableTOTPLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user, err := model.NewUser("alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := user.NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPEnabled = true
+	app := &ReactAppWrapper{
+		cfg:        &config.Config{JWTSecretKey: []byte("jwt")},
+		userStorer: &memoryUsers{users: map[string]*model.User{user.ID: user}},
+		limiter:    ratelimit.New(ratelimit.Config{}),
+	}
+	router := gin.New()
+	router.POST("/ui/api/totp/disable", func(c *gin.Context) { c.Set(userIDContextKey, "alice") }, app.disableTOTP)
+	disable := func(code string) int {
+		body, _ := json.Marshal(viewmodel.TOTPCode{Code: code})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ui/api/totp/disable", bytes.NewReader(body)))
+		return w.Code
+	}
+
+	for range ratelimit.DefaultFreeAttempts + 1 {
+		assert.Equal(t, http.StatusBadRequest, disable("123456"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, disable(codes[0]), "even the right code waits")
+	assert.True(t, user.TOTPEnabled)
+}
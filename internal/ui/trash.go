@@ -0,0 +1,67 @@
+package ui
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ddvk/rmfakecloud/internal/audit"
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/storage/fs"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+func (app *ReactAppWrapper) listTrash(c *gin.Context) {
+	trash, err := app.getBackend(c).ListTrash(userID(c))
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, trash)
+}
+
+// restoreTrash moves a document out of the trash, to the root unless a parent is sent
+func (app *ReactAppWrapper) restoreTrash(c *gin.Context) {
+	var req viewmodel.RestoreDoc
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		badReq(c, err.Error())
+		return
+	}
+	uid := userID(c)
+	docid := common.ParamS(docIDParam, c)
+
+	backend := app.getBackend(c)
+	parent, err := backend.RestoreDocument(uid, docid, req.ParentID)
+	if errors.Is(err, fs.ErrorNotInTrash) {
+		c.AbortWithStatusJSON(http.StatusConflict, viewmodel.NewErrorResponse(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+	recordAudit(c, audit.Entry{Action: audit.ActionDocumentRestore, Document: docid, Details: map[string]string{"parent": parent}})
+	backend.Sync(uid)
+	c.Status(http.StatusOK)
+}
+
+func (app *ReactAppWrapper) emptyTrash(c *gin.Context) {
+	uid := userID(c)
+	backend := app.getBackend(c)
+	deleted, err := backend.EmptyTrash(uid)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+	recordAudit(c, audit.Entry{Action: audit.ActionTrashEmpty, Details: map[string]string{"deleted": strconv.Itoa(deleted)}})
+	if deleted > 0 {
+		backend.Sync(uid)
+	}
+	c.JSON(http.StatusOK, viewmodel.EmptiedTrash{Deleted: deleted})
+}
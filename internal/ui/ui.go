@@ -12,6 +12,8 @@ import (
 	"github.com/ddvk/rmfakecloud/internal/common"
 	"github.com/ddvk/rmfakecloud/internal/config"
 	"github.com/ddvk/rmfakecloud/internal/messages"
+	"github.com/ddvk/rmfakecloud/internal/oidc"
+	"github.com/ddvk/rmfakecloud/internal/ratelimit"
 	"github.com/ddvk/rmfakecloud/internal/storage"
 	"github.com/ddvk/rmfakecloud/internal/storage/models"
 	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
@@ -22,10 +24,19 @@ import (
 type backend interface {
 	GetDocumentTree(uid string) (tree *viewmodel.DocumentTree, err error)
 	Export(uid, doc, exporttype string, opt storage.ExportOption) (stream io.ReadCloser, err error)
+	ExportPages(uid, doc, format string, page int) (stream io.ReadCloser, err error)
 	CreateDocument(uid, name, parent string, stream io.Reader) (doc *storage.Document, err error)
 	CreateFolder(uid, name, parent string) (doc *storage.Document, err error)
 	UpdateDocument(uid, docID, name, parent string) (err error)
 	DeleteDocument(uid, docID string) (err error)
+	ListTrash(uid string) ([]viewmodel.Entry, error)
+	// RestoreDocument returns the parent, the root if the requested one is gone
+	RestoreDocument(uid, docID, parent string) (string, error)
+	EmptyTrash(uid string) (int, error)
+	DocumentVersions(uid, docID string) ([]*storage.DocumentVersion, error)
+	ExportVersion(uid, docID, version, exporttype string) (io.ReadCloser, error)
+	RestoreVersion(uid, docID, version string) error
+	Search(uid, query string) ([]*storage.SearchResult, error)
 	Sync(uid string)
 }
 type codeGenerator interface {
@@ -37,6 +48,7 @@ type documentHandler interface {
 	CreateFolder(uid, name, parent string) (doc *storage.Document, err error)
 	GetAllMetadata(uid string) (documents []*messages.RawMetadata, err error)
 	ExportDocument(uid, id, format string, exportOption storage.ExportOption) (stream io.ReadCloser, err error)
+	ExportDocumentPages(uid, id, format string, page int) (stream io.ReadCloser, err error)
 	GetMetadata(uid, id string) (*messages.RawMetadata, error)
 	UpdateMetadata(uid string, r *messages.RawMetadata) error
 	RemoveDocument(uid, docid string) error
@@ -47,9 +59,28 @@ type blobHandler interface {
 	CreateBlobDocument(uid, name, parent string, reader io.Reader) (doc *storage.Document, err error)
 	UpdateBlobDocument(uid, docID, name, parent string) (err error)
 	DeleteBlobDocument(uid, docID string) (err error)
+	TrashBlobDocument(uid, docID string) error
+	RestoreBlobDocument(uid, docID, parent string) (string, error)
+	EmptyTrash(uid string) (int, error)
 	CreateBlobFolder(uid, name, parent string) (doc *storage.Document, err error)
 	Export(uid, docid string) (io.ReadCloser, error)
 	ExportRmDoc(uid, docid string) (io.ReadCloser, error)
+	ExportBlobPages(uid, docid, format string, page int) (io.ReadCloser, error)
+	ListGenerations(uid string) ([]*storage.Generation, error)
+	DiffGenerations(uid string, from, to int64) (*storage.GenerationDiff, error)
+	RestoreGeneration(uid string, generation int64) (int64, error)
+	MigrateToSync15(uid string, dryRun bool) (*storage.MigrationReport, error)
+	ExportLibrary(uid string, w io.Writer) error
+	ImportLibrary(uid string, r io.ReaderAt, size int64) (*storage.ImportReport, error)
+	DocumentVersions(uid, docID string) ([]*storage.DocumentVersion, error)
+	ExportVersion(uid, docID, version, exporttype string) (io.ReadCloser, error)
+	RestoreDocumentVersion(uid, docID, version string) error
+	Search(uid, query string) ([]*storage.SearchResult, error)
+}
+
+type quotaHandler interface {
+	StorageUsage(uid string) (int64, error)
+	QuotaReader(uid string, r io.Reader, size int64) (io.Reader, error)
 }
 
 type notificationHub interface {
@@ -68,6 +99,11 @@ type ReactAppWrapper struct {
 	codeConnector codeGenerator
 	h             *hub.Hub
 	passcodeStore passcodestore.Store
+	blobHandler   blobHandler
+	quota         quotaHandler
+	devices       storage.DeviceStorer
+	oidc          *oidc.Provider
+	limiter       *ratelimit.Limiter
 	backends      map[common.SyncVersion]backend
 }
 
@@ -82,7 +118,10 @@ func New(cfg *config.Config,
 	h *hub.Hub,
 	pcStore passcodestore.Store,
 	docHandler documentHandler,
-	blobHandler blobHandler) *ReactAppWrapper {
+	blobHandler blobHandler,
+	quota quotaHandler,
+	devices storage.DeviceStorer,
+	limiter *ratelimit.Limiter) *ReactAppWrapper {
 
 	sub, err := fs.Sub(webui.Assets, jsBuildFolder)
 	if err != nil {
@@ -104,11 +143,18 @@ func New(cfg *config.Config,
 		codeConnector: codeConnector,
 		h:             h,
 		passcodeStore: pcStore,
+		blobHandler:   blobHandler,
+		quota:         quota,
+		devices:       devices,
+		limiter:       limiter,
 		backends: map[common.SyncVersion]backend{
 			common.Sync10: backend10,
 			common.Sync15: backend15,
 		},
 	}
+	if cfg.OIDC != nil {
+		staticWrapper.oidc = oidc.New(cfg.OIDC)
+	}
 	return &staticWrapper
 }
 
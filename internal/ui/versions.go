@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ddvk/rmfakecloud/internal/common"
+	"github.com/ddvk/rmfakecloud/internal/ui/viewmodel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const versionParam = "version"
+
+func (app *ReactAppWrapper) listDocumentVersions(c *gin.Context) {
+	uid := userID(c)
+	docid := common.ParamS(docIDParam, c)
+
+	versions, err := app.getBackend(c).DocumentVersions(uid, docid)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+
+	result := make([]viewmodel.DocumentVersion, 0, len(versions))
+	for _, v := range versions {
+		result = append(result, viewmodel.DocumentVersion{
+			Version:      v.Version,
+			Generation:   v.Generation,
+			Date:         v.Date,
+			Name:         v.Name,
+			Size:         v.Size,
+			LastModified: v.LastModified,
+			Current:      v.Current,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (app *ReactAppWrapper) getDocumentVersion(c *gin.Context) {
+	uid := userID(c)
+	docid := common.ParamS(docIDParam, c)
+	version := common.ParamS(versionParam, c)
+	exportType := c.DefaultQuery("type", "pdf")
+
+	log.Info("exporting ", docid, " version ", version, " as ", exportType)
+	reader, err := app.getBackend(c).ExportVersion(uid, docid, version, exportType)
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	if exportType == "rmdoc" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.rmdoc\"", docid))
+	}
+
+	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", reader, nil)
+}
+
+func (app *ReactAppWrapper) restoreDocumentVersion(c *gin.Context) {
+	uid := userID(c)
+	docid := common.ParamS(docIDParam, c)
+	version := common.ParamS(versionParam, c)
+
+	backend := app.getBackend(c)
+	err := backend.RestoreVersion(uid, docid, version)
+	if err != nil {
+		log.Error(err)
+		badReq(c, err.Error())
+		return
+	}
+	backend.Sync(uid)
+	c.Status(http.StatusOK)
+}
@@ -15,6 +15,19 @@ const trashID = "trash"
 type LoginForm struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Code of the authenticator app or a recovery code, when two-factor authentication is enabled
+	Code string `json:"code,omitempty"`
+}
+
+// SecondFactorRequired the login needs the code of the authenticator
+type SecondFactorRequired struct {
+	Error        string `json:"error"`
+	TOTPRequired bool   `json:"totpRequired"`
+}
+
+// SecondFactorForm the code of the authenticator or a recovery code
+type SecondFactorForm struct {
+	Code string `json:"code" binding:"required"`
 }
 
 // ResetPasswordForm reset password
@@ -219,6 +232,12 @@ type User struct {
 	IsAdmin 	 bool `json:"isAdmin"`
 	CreatedAt    time.Time
 	Integrations []string `json:"integrations,omitempty"`
+	// Quota in bytes, 0 unlimited
+	Quota *int64 `json:"quota,omitempty"`
+	// Used storage in bytes
+	Used int64 `json:"used,omitempty"`
+	// TOTPEnabled the user has two-factor authentication
+	TOTPEnabled bool `json:"totpEnabled,omitempty"`
 }
 
 // NewUser new user creation
@@ -239,3 +258,175 @@ type NewFolder struct {
 	ParentID string `json:"parentId"`
 	Name     string `json:"name"`
 }
+
+// Generation a root in the sync15 history
+type Generation struct {
+	Generation int64     `json:"generation"`
+	Date       time.Time `json:"date"`
+	Documents  int       `json:"documents"`
+	Available  bool      `json:"available"`
+	Current    bool      `json:"current"`
+}
+
+// DocumentChange a document that changed between generations
+type DocumentChange struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GenerationDiff the changes between two generations
+type GenerationDiff struct {
+	From     int64            `json:"from"`
+	To       int64            `json:"to"`
+	Added    []DocumentChange `json:"added"`
+	Removed  []DocumentChange `json:"removed"`
+	Modified []DocumentChange `json:"modified"`
+}
+
+// MigrateUser options of the sync10 to sync15 migration
+type MigrateUser struct {
+	DryRun bool `json:"dryRun"`
+	// Enable switch the user to sync15 afterwards
+	Enable bool `json:"enable"`
+}
+
+// MigrationProblem a document that could not be converted
+type MigrationProblem struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// MigrationReport the result of the migration
+type MigrationReport struct {
+	DryRun    bool               `json:"dryRun"`
+	Documents int                `json:"documents"`
+	Folders   int                `json:"folders"`
+	Existing  int                `json:"existing"`
+	Problems  []MigrationProblem `json:"problems"`
+	Enabled   bool               `json:"enabled"`
+}
+
+// ImportReport the result of a library import
+type ImportReport struct {
+	Documents int                `json:"documents"`
+	Folders   int                `json:"folders"`
+	Existing  int                `json:"existing"`
+	Problems  []MigrationProblem `json:"problems"`
+}
+
+// RestoreDoc where to restore a trashed document, the root if empty
+type RestoreDoc struct {
+	ParentID string `json:"parentId"`
+}
+
+// EmptiedTrash the result of emptying the trash
+type EmptiedTrash struct {
+	Deleted int `json:"deleted"`
+}
+
+// RestoredGeneration the result of a restore
+type RestoredGeneration struct {
+	Generation int64 `json:"generation"`
+}
+
+// SearchHit a page matching a search
+type SearchHit struct {
+	Page    int    `json:"page"`
+	Snippet string `json:"snippet"`
+}
+
+// SearchResult a document matching a search
+type SearchResult struct {
+	ID   string      `json:"id"`
+	Name string      `json:"name"`
+	Hits []SearchHit `json:"hits"`
+}
+
+// DocumentVersion a version of a document
+type DocumentVersion struct {
+	Version      string    `json:"version"`
+	Generation   int64     `json:"generation"`
+	Date         time.Time `json:"date"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Current      bool      `json:"current"`
+}
+
+// Device a paired device
+type Device struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description"`
+	Name        string    `json:"name"`
+	PairedAt    time.Time `json:"pairedAt"`
+	LastSeen    time.Time `json:"lastSeen"`
+	LastIP      string    `json:"lastIp"`
+	LastSync    time.Time `json:"lastSync"`
+	Revoked     bool      `json:"revoked"`
+}
+
+// UpdateDevice rename a device
+type UpdateDevice struct {
+	Name string `json:"name"`
+}
+
+// AccessToken a personal access token
+type AccessToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	CreatedAt time.Time `json:"createdAt"`
+	Device    string    `json:"device,omitempty"`
+}
+
+// NewAccessToken creates an access token, scope: read, upload or admin,
+// bound to a paired device if set
+type NewAccessToken struct {
+	Name   string `json:"name" binding:"required"`
+	Scope  string `json:"scope" binding:"required"`
+	Device string `json:"device"`
+}
+
+// CreatedAccessToken the token is shown only once
+type CreatedAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+// TOTPStatus the two-factor authentication of the user
+type TOTPStatus struct {
+	Enabled bool `json:"enabled"`
+	// RecoveryCodes the number of unused recovery codes
+	RecoveryCodes int `json:"recoveryCodes"`
+}
+
+// TOTPEnrolment the secret to add to the authenticator app
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	// URI the otpauth provisioning uri for the qr code
+	URI string `json:"uri"`
+}
+
+// TOTPCode a code of the authenticator or a recovery code
+type TOTPCode struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// RecoveryCodes shown only once
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// Lockout an account or ip blocked after failed logins
+type Lockout struct {
+	// Kind account or ip
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+	// IP the client which failed to log in to the account
+	IP       string    `json:"ip,omitempty"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+	// Locked the account reached the lockout limit, otherwise a short backoff
+	Locked bool `json:"locked"`
+}
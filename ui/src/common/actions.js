@@ -1,19 +1,47 @@
 import apiService from "../services/api.service";
 
 export async function loginUser(dispatch, loginPayload) {
+  return login(dispatch, () => apiService.login(loginPayload), !!loginPayload.code);
+}
+
+// the code of the authenticator after the OpenID Connect login
+export async function loginOidcSecondFactor(dispatch, code) {
+  return login(dispatch, () => apiService.oidcSecondFactor(code), !!code);
+}
+
+async function login(dispatch, request, withCode) {
 
   try {
     dispatch({ type: "REQUEST_LOGIN" });
 
-    let user = await apiService.login(loginPayload)
+    let user = await request()
     dispatch({
       type: "LOGIN_SUCCESS",
       payload: { user: user },
     });
 
     return;
+  } catch (error) {
+    if (error.totpRequired) {
+      const message = withCode ? "Wrong code" : "Enter the code of your authenticator app or a recovery code";
+      dispatch({ type: "LOGIN_ERROR", error: message });
+      return { totpRequired: true };
+    }
+    dispatch({ type: "LOGIN_ERROR", error: "Can't login: " + error.message});
+  }
+}
+
+export async function loginOidcUser(dispatch) {
+  try {
+    let user = await apiService.oidcLogin();
+    dispatch({
+      type: "LOGIN_SUCCESS",
+      payload: { user: user },
+    });
+    return true;
   } catch (error) {
     dispatch({ type: "LOGIN_ERROR", error: "Can't login: " + error.message});
+    return false;
   }
 }
 
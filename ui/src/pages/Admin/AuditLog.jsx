@@ -0,0 +1,54 @@
+import { useState } from "react";
+import { Form, Table } from "react-bootstrap";
+
+import useFetch from "../../hooks/useFetch";
+import { formatDate } from "../../common/date";
+
+export default function AuditLog() {
+  const [user, setUser] = useState("");
+  const [action, setAction] = useState("");
+  const params = new URLSearchParams({ user, action });
+  const { data: entries } = useFetch(`audit?${params}`);
+
+  return (
+    <>
+      <h3>Audit log</h3>
+      <Form className="d-flex gap-2 mb-2">
+        <Form.Control
+          placeholder="user"
+          value={user}
+          onChange={(e) => setUser(e.target.value)}
+        />
+        <Form.Control
+          placeholder="action, e.g. document.delete"
+          value={action}
+          onChange={(e) => setAction(e.target.value)}
+        />
+      </Form>
+      <Table striped bordered hover size="sm">
+        <thead>
+          <tr>
+            <th>Time</th>
+            <th>User</th>
+            <th>Action</th>
+            <th>From</th>
+            <th>Document</th>
+            <th>Details</th>
+          </tr>
+        </thead>
+        <tbody>
+          {entries && entries.map((x, i) => (
+            <tr key={i}>
+              <td>{formatDate(x.time)}</td>
+              <td>{x.user}</td>
+              <td>{x.action}</td>
+              <td>{[x.source, x.device, x.ip].filter(Boolean).join(" ")}</td>
+              <td>{x.document}</td>
+              <td>{x.details && Object.entries(x.details).map(([k, v]) => `${k}: ${v}`).join(", ")}</td>
+            </tr>
+          ))}
+        </tbody>
+      </Table>
+    </>
+  );
+}
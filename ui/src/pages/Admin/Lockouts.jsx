@@ -0,0 +1,51 @@
+import { useState } from "react";
+import { toast } from "react-toastify";
+import { Button, Table } from "react-bootstrap";
+
+import useFetch from "../../hooks/useFetch";
+import apiService from "../../services/api.service";
+import { formatDate } from "../../common/date";
+
+export default function Lockouts() {
+  const [index, setIndex] = useState(0);
+  const { data: lockouts } = useFetch("lockouts", index);
+
+  const unlock = async (kind, key) => {
+    try {
+      await apiService.unlock(kind, key);
+      setIndex(previous => previous + 1);
+    } catch (e) {
+      toast.error("Error:" + e);
+    }
+  };
+
+  if (!lockouts || !lockouts.length) {
+    return null;
+  }
+
+  return (
+    <>
+      <h3>Failed logins</h3>
+      <Table striped bordered hover>
+        <thead>
+          <tr>
+            <th>Account/IP</th>
+            <th>Failures</th>
+            <th>Blocked until</th>
+            <th></th>
+          </tr>
+        </thead>
+        <tbody>
+          {lockouts.map((x) => (
+            <tr key={x.kind + x.key + (x.ip || "")}>
+              <td>{x.kind === "ip" ? `IP ${x.key}` : `${x.key} from ${x.ip}`}{x.locked && " (locked)"}</td>
+              <td>{x.failures}</td>
+              <td>{formatDate(x.until)}</td>
+              <td><Button variant="warning" onClick={() => unlock(x.kind, x.key)}>Unlock</Button></td>
+            </tr>
+          ))}
+        </tbody>
+      </Table>
+    </>
+  );
+}
@@ -48,6 +48,15 @@ export default function UserProfileModal(params) {
     }
   }
 
+  async function handleResetTotp() {
+    try {
+      await apiService.resetUserTotp(user.userid);
+      onSave();
+    } catch (e) {
+      setFormErrors({ error: e.toString() });
+    }
+  }
+
   if (!user) return null;
   return (
     <Form onSubmit={handleSubmit}>
@@ -84,6 +93,14 @@ export default function UserProfileModal(params) {
               />
             </Form.Group>
 
+            {user.totpEnabled && (
+              <div className="mt-3">
+                <Button variant="warning" onClick={handleResetTotp}>
+                  Reset two-factor authentication
+                </Button>
+              </div>
+            )}
+
             <Alert variant="danger" hidden={!formErrors.error}>
               <Alert.Heading>An Error Occurred</Alert.Heading>
               {formErrors.error}
@@ -1,12 +1,16 @@
 import Container from "react-bootstrap/Container";
 import Stack from "react-bootstrap/Stack";
 import UserList from "./UserList";
+import Lockouts from "./Lockouts";
+import AuditLog from "./AuditLog";
 
 const Home = () => {
   return (
     <Container fluid>
       <Stack>
           <UserList />
+          <Lockouts />
+          <AuditLog />
       </Stack>
     </Container>
   );
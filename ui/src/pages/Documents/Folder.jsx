@@ -48,6 +48,32 @@ export default function Folder({ selection, onSelect, onUpdate }) {
     onUpdate();
   }
 
+  const onRestoreClick = async () => {
+    for (const id of selectedIds) {
+      const file = folder.children.find(f => f.id === id);
+      const name = file?.data?.name || id;
+      try {
+        await apiservice.restoreDocument(id);
+        toast.success(`Restored ${name}`);
+      } catch (e) {
+        toast.error(`Failed to restore ${name}`);
+      }
+    }
+    setSelectedIds([]);
+    onUpdate();
+  }
+
+  const onEmptyTrashClick = async () => {
+    if (!window.confirm(`Delete everything in the trash for good?`)) return;
+    try {
+      const { deleted } = await apiservice.emptyTrash();
+      toast.success(`Deleted ${deleted} item(s)`);
+    } catch (e) {
+      toast.error(`Failed to empty the trash`);
+    }
+    onUpdate();
+  }
+
   const fileUploaded = () => {
     onUpdate();
   }
@@ -69,8 +95,15 @@ export default function Folder({ selection, onSelect, onUpdate }) {
       </Navbar>
 
       <Navbar className={styles.filedivider}>
-        <Button size="sm" variant="outline" onClick={() => setShowCreateFolder(true)}>Create Folder</Button>
+        {folder.id === "trash" ? (
+          <Button size="sm" variant="outline" onClick={onEmptyTrashClick}>Empty Trash</Button>
+        ) : (
+          <Button size="sm" variant="outline" onClick={() => setShowCreateFolder(true)}>Create Folder</Button>
+        )}
         <div className={styles.stretch}></div>
+        {folder.id === "trash" && (
+          <Button size="sm" onClick={onRestoreClick} disabled={selectedIds.length === 0}>Restore</Button>
+        )}
         <Button size="sm" onClick={onDeleteClick} disabled={selectedIds.length === 0}>Delete</Button>
         <ToggleButtonGroup value={listStyle} onChange={(v) => setListStyle(v)} name="abc">
           <ToggleButton id="grid" name="grid" size="sm" value="grid" variant="outline">
@@ -1,9 +1,11 @@
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { useHistory } from "react-router-dom";
 import { Button, Form } from "react-bootstrap";
 
 import { useAuthState } from "../../common/useAuthContext";
-import { loginUser } from "../../common/actions";
+import { loginOidcSecondFactor, loginOidcUser, loginUser } from "../../common/actions";
+import apiService from "../../services/api.service";
+import constants from "../../common/constants";
 
 import styles from "./Login.module.scss";
 
@@ -11,16 +13,49 @@ const Login = () => {
   let history = useHistory();
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
+  const [code, setCode] = useState("");
+  const [totpRequired, setTotpRequired] = useState(false);
+  // the identity provider login is done, only the code is missing
+  const [oidcTotp, setOidcTotp] = useState(false);
 
   const { state, dispatch } = useAuthState(); //read the values of loading and errorMessage from context
   const { errorMessage, loading } = state;
+  const [oidcEnabled, setOidcEnabled] = useState(false);
+
+  useEffect(() => {
+    const hash = window.location.hash;
+    if (hash === "#oidc") {
+      window.history.replaceState(null, "", window.location.pathname);
+      loginOidcUser(dispatch).then((ok) => {
+        if (ok) {
+          history.push("/documents");
+        }
+      });
+    }
+    if (hash === "#oidc-totp") {
+      window.history.replaceState(null, "", window.location.pathname);
+      setOidcTotp(true);
+      setTotpRequired(true);
+    }
+    apiService.oidcEnabled().then(setOidcEnabled);
+  }, [dispatch, history]);
 
   const handleLogin = async (e) => {
     e.preventDefault();
 
     let payload = { email: username, password };
+    if (totpRequired) {
+      payload.code = code;
+    }
     try {
-      await loginUser(dispatch, payload);
+      const result = oidcTotp
+        ? await loginOidcSecondFactor(dispatch, code)
+        : await loginUser(dispatch, payload);
+      if (result && result.totpRequired) {
+        setTotpRequired(true);
+        setCode("");
+        return;
+      }
       history.push("/documents"); //TODO: usenavigate or return redirect
     } catch (error) {
       console.log(error);
@@ -33,6 +68,8 @@ const Login = () => {
         {errorMessage ? <p className={styles.error}>{errorMessage}</p> : null}
 
         <Form>
+          {oidcTotp ? null : (
+          <>
           <Form.Group className="mb-3">
             <Form.Label htmlFor="username">Username</Form.Label>
             <Form.Control
@@ -58,10 +95,37 @@ const Login = () => {
               autoComplete="current-password"
               />
           </Form.Group>
+          </>
+          )}
+
+          {totpRequired ? (
+            <Form.Group className="mb-3">
+              <Form.Label htmlFor="code">Code</Form.Label>
+              <Form.Control
+                id="code"
+                value={code}
+                autoFocus
+                onChange={(e) => setCode(e.target.value)}
+                disabled={loading}
+                placeholder="123456"
+                autoComplete="one-time-code"
+                />
+            </Form.Group>
+          ) : null}
 
           <Button type="submit" onClick={handleLogin} disabled={loading}>
             Login
           </Button>
+          {oidcEnabled ? (
+            <Button
+              variant="secondary"
+              className="ms-2"
+              href={`${constants.ROOT_URL}/oidc/login`}
+              disabled={loading}
+            >
+              Login with SSO
+            </Button>
+          ) : null}
         </Form>
 
       </div>
@@ -0,0 +1,114 @@
+import { useEffect, useState } from "react";
+import Form from "react-bootstrap/Form";
+import Button from "react-bootstrap/Button";
+
+import apiservice from "../../services/api.service";
+
+export default function TwoFactor() {
+  const [status, setStatus] = useState(null);
+  const [enrolment, setEnrolment] = useState(null);
+  const [recoveryCodes, setRecoveryCodes] = useState(null);
+  const [code, setCode] = useState("");
+  const [error, setError] = useState("");
+
+  function refresh() {
+    apiservice.getTotp()
+      .then(setStatus)
+      .catch(e => setError(e.toString()));
+  }
+
+  useEffect(refresh, []);
+
+  function handleEnroll() {
+    setError("");
+    apiservice.enrollTotp()
+      .then(setEnrolment)
+      .catch(e => setError(e.toString()));
+  }
+
+  function handleConfirm(event) {
+    event.preventDefault();
+    setError("");
+    apiservice.confirmTotp(code)
+      .then(r => {
+        setRecoveryCodes(r.codes);
+        setEnrolment(null);
+        setCode("");
+        refresh();
+      })
+      .catch(e => setError(e.toString()));
+  }
+
+  function handleDisable(event) {
+    event.preventDefault();
+    setError("");
+    apiservice.disableTotp(code)
+      .then(() => {
+        setRecoveryCodes(null);
+        setCode("");
+        refresh();
+      })
+      .catch(e => setError(e.toString()));
+  }
+
+  if (!status) return null;
+
+  return (
+    <>
+      <h3>Two-factor authentication</h3>
+      {recoveryCodes && (
+        <div className="alert alert-warning">
+          <p>Keep these recovery codes somewhere safe, each can be used once instead of a code and they are not shown again:</p>
+          <ul>
+            {recoveryCodes.map((c) => <li key={c}><code>{c}</code></li>)}
+          </ul>
+        </div>
+      )}
+      {status.enabled ? (
+        <Form onSubmit={handleDisable} autoComplete="off">
+          <p>Enabled, {status.recoveryCodes} recovery codes left.</p>
+          <Form.Group controlId="formTotpDisable" className="mb-3">
+            <Form.Label>Code</Form.Label>
+            <Form.Control
+              value={code}
+              placeholder="code or recovery code"
+              onChange={(e) => setCode(e.target.value)}
+              autoComplete="one-time-code"
+            />
+          </Form.Group>
+          <Button variant="danger" type="submit">
+            Disable
+          </Button>
+        </Form>
+      ) : enrolment ? (
+        <Form onSubmit={handleConfirm} autoComplete="off">
+          <p>
+            Add the account to your authenticator app with <a href={enrolment.uri}>this link</a> or
+            the key <code>{enrolment.secret}</code>, then enter the code it shows.
+          </p>
+          <Form.Group controlId="formTotpConfirm" className="mb-3">
+            <Form.Label>Code</Form.Label>
+            <Form.Control
+              value={code}
+              placeholder="123456"
+              onChange={(e) => setCode(e.target.value)}
+              autoComplete="one-time-code"
+            />
+          </Form.Group>
+          <Button variant="primary" type="submit">
+            Confirm
+          </Button>
+        </Form>
+      ) : (
+        <Button variant="primary" onClick={handleEnroll}>
+          Enable
+        </Button>
+      )}
+      {error && (
+        <div className="alert alert-danger">
+          {error}
+        </div>
+      )}
+    </>
+  );
+}
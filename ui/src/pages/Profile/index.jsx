@@ -1,8 +1,10 @@
 import Container from "react-bootstrap/Container";
 import Stack from "react-bootstrap/Stack";
 import { useAuthState } from "../../common/useAuthContext";
+import constants from "../../common/constants";
 
 import ResetPassword from "./ResetPassword";
+import TwoFactor from "./TwoFactor";
 
 const Home = () => {
   const { state: { user } } = useAuthState();
@@ -12,9 +14,19 @@ const Home = () => {
         <div>
           {user.scopes === "sync15" && (<span>Using sync 15</span>)}
         </div>
+        {user.scopes === "sync15" && (
+          <div className="mt-2">
+            <a className="btn btn-secondary" href={`${constants.ROOT_URL}/library`} download>
+              Download all my data
+            </a>
+          </div>
+        )}
         <div>
           <ResetPassword />
         </div>
+        <div className="mt-4">
+          <TwoFactor />
+        </div>
       </Stack>
     </Container>
   );
@@ -19,15 +19,32 @@ class ApiServices {
       method: "POST",
       headers: this.header(),
       body: JSON.stringify(loginData),
-    })
+    }).then(handleLogin);
+  }
+  // the code of the authenticator after the OpenID Connect login
+  oidcSecondFactor(code) {
+    return fetch(`${constants.ROOT_URL}/oidc/totp`, {
+      method: "POST",
+      headers: this.header(),
+      body: JSON.stringify({ code }),
+    }).then(handleLogin);
+  }
+  oidcEnabled() {
+    return fetch(`${constants.ROOT_URL}/oidc`)
+      .then((r) => (r.ok ? r.json() : { enabled: false }))
+      .then((d) => d.enabled)
+      .catch(() => false);
+  }
+  // the OpenID Connect login set the session cookie, get the user of the session
+  oidcLogin() {
+    return fetch(`${constants.ROOT_URL}/session`)
       .then((r) => {
         if (!r.ok) {
           throw new Error(r.statusText);
         }
-        return r.text();
+        return r.json();
       })
-      .then((text) => {
-        let user = jwtDecode(text);
+      .then((user) => {
         localStorage.setItem("currentUser", JSON.stringify(user));
         return user;
       });
@@ -73,6 +90,45 @@ class ApiServices {
     });
   }
 
+  getTotp() {
+    return fetch(`${constants.ROOT_URL}/totp`, {
+      method: "GET",
+      headers: this.header(),
+    }).then((r) => (r.ok ? r.json() : handleError(r)));
+  }
+  enrollTotp() {
+    return fetch(`${constants.ROOT_URL}/totp`, {
+      method: "POST",
+      headers: this.header(),
+    }).then((r) => (r.ok ? r.json() : handleError(r)));
+  }
+  confirmTotp(code) {
+    return fetch(`${constants.ROOT_URL}/totp/confirm`, {
+      method: "POST",
+      headers: this.header(),
+      body: JSON.stringify({ code }),
+    }).then((r) => (r.ok ? r.json() : handleError(r)));
+  }
+  disableTotp(code) {
+    return fetch(`${constants.ROOT_URL}/totp/disable`, {
+      method: "POST",
+      headers: this.header(),
+      body: JSON.stringify({ code }),
+    }).then((r) => handleError(r));
+  }
+  unlock(kind, key) {
+    return fetch(`${constants.ROOT_URL}/lockouts/${kind}/${encodeURIComponent(key)}`, {
+      method: "DELETE",
+      headers: this.header(),
+    }).then((r) => handleError(r));
+  }
+  resetUserTotp(userid) {
+    return fetch(`${constants.ROOT_URL}/users/${userid}/totp`, {
+      method: "DELETE",
+      headers: this.header(),
+    }).then((r) => handleError(r));
+  }
+
   listPasscodeResets() {
     return fetch(`${constants.ROOT_URL}/passcode/resets`, {
       method: "GET",
@@ -130,6 +186,22 @@ class ApiServices {
       headers: this.header(),
     }).then((r) => handleError(r));
   }
+  restoreDocument(id, parentId) {
+    return fetch(`${constants.ROOT_URL}/trash/${id}/restore`, {
+      method: "POST",
+      headers: this.header(),
+      body: JSON.stringify({ parentId }),
+    }).then((r) => handleError(r));
+  }
+  emptyTrash() {
+    return fetch(`${constants.ROOT_URL}/trash`, {
+      method: "DELETE",
+      headers: this.header(),
+    }).then((r) => {
+      handleError(r);
+      return r.json();
+    });
+  }
   download(id, exportType) {
     let url = `${constants.ROOT_URL}/documents/${id}`;
     if (exportType) url += `?type=${exportType}`;
@@ -203,6 +275,22 @@ class ApiServices {
   }
 }
 
+// the response of a login, the session token or the error
+async function handleLogin(r) {
+  if (!r.ok) {
+    let error = new Error(r.statusText);
+    // the password was right, the code of the authenticator is missing or wrong
+    if (r.status === 401) {
+      const body = await r.json().catch(() => ({}));
+      error.totpRequired = !!body.totpRequired;
+    }
+    throw error;
+  }
+  const text = await r.text();
+  let user = jwtDecode(text);
+  localStorage.setItem("currentUser", JSON.stringify(user));
+  return user;
+}
 function removeUser(){
   localStorage.removeItem("currentUser");
 }